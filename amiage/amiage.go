@@ -10,28 +10,28 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
-// Cache of creation-time/date
-var cache map[string]string
+// Cache of images we've looked up
+var cache map[string]*ec2.Image
 
 // NotFound is the error returned when an AMI isn't found
 var NotFound error
 
 // init ensures that our cache is initialized
 func init() {
-	cache = make(map[string]string)
+	cache = make(map[string]*ec2.Image)
 	NotFound = fmt.Errorf("not-found")
 }
 
-// AMICreation returns the creation-date of the given AMI as a string.
+// lookup returns the details of the given AMI.
 //
 // Values are cached.
-func AMICreation(svc *ec2.EC2, id string) (string, error) {
+func lookup(svc *ec2.EC2, id string) (*ec2.Image, error) {
 
-	// Lookup in the cache to see if we've already found the creation
-	// date for this AMI
+	// Lookup in the cache to see if we've already found this AMI
 	cached, ok := cache[id]
 	if ok {
 		return cached, nil
@@ -44,26 +44,52 @@ func AMICreation(svc *ec2.EC2, id string) (string, error) {
 		},
 	}
 
-	// Run the search, return NotFound if we got an error.
+	// Run the search, return NotFound if the AMI doesn't exist.
 	//
-	// Yes we're losing the "real" error here, but logically we've not found the
-	// details we want, so it's a not-found.
+	// Other errors, such as throttling or permission problems, are
+	// returned to the caller as they mean we don't know the answer.
 	result, err := svc.DescribeImages(input)
 	if err != nil {
-		return "", NotFound
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidAMIID.NotFound" {
+			return nil, NotFound
+		}
+		return nil, err
 	}
 
-	// If we got a result then we can return the creation time (as a string)
+	// If we got a result then we can return it
 	if len(result.Images) > 0 {
 
 		// But save in a cache for the future
-		date := *result.Images[0].CreationDate
-		cache[id] = date
-		return date, nil
+		cache[id] = result.Images[0]
+		return result.Images[0], nil
 	}
 
 	// No result found
-	return "", NotFound
+	return nil, NotFound
+}
+
+// AMICreation returns the creation-date of the given AMI as a string.
+//
+// Values are cached.
+func AMICreation(svc *ec2.EC2, id string) (string, error) {
+
+	image, err := lookup(svc, id)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(image.CreationDate), nil
+}
+
+// AMIOwner returns the ID of the account which owns the given AMI.
+//
+// Values are cached.
+func AMIOwner(svc *ec2.EC2, id string) (string, error) {
+
+	image, err := lookup(svc, id)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(image.OwnerId), nil
 }
 
 // AMIAge returns the number of days since the specified image was created,
@@ -0,0 +1,282 @@
+// Check the age of the AMIs our instances are running, and exit with
+// a non-zero status if they're too old.
+//
+// Primarily written to be used from cron, or as a CI gate.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/skx/aws-utils/amiage"
+	"github.com/skx/aws-utils/instances"
+	"github.com/skx/aws-utils/utils"
+)
+
+// amiOverride contains different thresholds to use for instances which
+// have a particular tag.
+type amiOverride struct {
+	// Key is the name of the tag.
+	Key string
+
+	// Value is the value the tag must have.
+	Value string
+
+	// Warn is the age, in days, at which we'll warn.
+	Warn int
+
+	// Fail is the age, in days, at which we'll fail.
+	Fail int
+}
+
+// amiOverrides allows overrides to be specified multiple times upon the
+// command-line.
+type amiOverrides []amiOverride
+
+// String is part of the flag.Value interface.
+func (o *amiOverrides) String() string {
+	out := []string{}
+	for _, ent := range *o {
+		out = append(out, fmt.Sprintf("%s=%s:%d:%d", ent.Key, ent.Value, ent.Warn, ent.Fail))
+	}
+	return strings.Join(out, ",")
+}
+
+// Set is part of the flag.Value interface, it parses a value of the
+// form "Key=Value:warn:fail".
+func (o *amiOverrides) Set(value string) error {
+
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return fmt.Errorf("override should be of the form Key=Value:warn:fail, got %s", value)
+	}
+
+	kv := strings.SplitN(parts[0], "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("override should be of the form Key=Value:warn:fail, got %s", value)
+	}
+
+	warn, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return fmt.Errorf("invalid warning threshold %s: %s", parts[1], err)
+	}
+	fail, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return fmt.Errorf("invalid failure threshold %s: %s", parts[2], err)
+	}
+
+	if warn > fail {
+		return fmt.Errorf("the warning threshold must not be greater than the failure threshold, got %s", value)
+	}
+
+	*o = append(*o, amiOverride{Key: kv[0], Value: kv[1], Warn: warn, Fail: fail})
+	return nil
+}
+
+// Structure for our options and state.
+type amiCheckCommand struct {
+
+	// Path to a file containing roles
+	rolesPath string
+
+	// Age, in days, at which we warn
+	warn int
+
+	// Age, in days, at which we fail
+	fail int
+
+	// Per-tag overrides of the thresholds
+	overrides amiOverrides
+
+	// The instances we've found, across all accounts
+	instanceCollector
+}
+
+// Arguments adds per-command args to the object.
+func (a *amiCheckCommand) Arguments(f *flag.FlagSet) {
+	f.StringVar(&a.rolesPath, "roles", "", "Path to a list of roles to process, one by one")
+	f.IntVar(&a.warn, "warn", 60, "Warn if an AMI is older than this many days")
+	f.IntVar(&a.fail, "fail", 90, "Fail if an AMI is older than this many days")
+	f.Var(&a.overrides, "override", "Different thresholds for tagged instances, as Key=Value:warn:fail (may be repeated)")
+}
+
+// Info returns the name of this subcommand.
+func (a *amiCheckCommand) Info() (string, string) {
+	return "ami-check", `Check for instances running old AMIs.
+
+Details:
+
+This command examines the running instances, and reports upon those which
+are running from AMIs older than the given thresholds.  Offending instances
+are shown grouped by the AMI they're running:
+
+    $ aws-utils ami-check -warn 60 -fail 90
+    FAIL ami-0123456789abcdef0 - 120 days old
+         123456789012 i-0123456789abcdef0 prod-web-1
+    WARN ami-0fedcba9876543210 - 75 days old
+         123456789012 i-0fedcba9876543210 prod-db-1
+
+The exit code of the command reflects the result, so it may be used to
+gate pipelines, or from cron:
+
+    0 - No instance is running an AMI older than the thresholds.
+    1 - At least one instance exceeds the warning threshold.
+    2 - At least one instance exceeds the failure threshold.
+    3 - An error prevented the check from completing.
+
+Instances with a particular tag may be given different thresholds, for
+example to allow development instances to run older AMIs:
+
+    $ aws-utils ami-check -override Environment=dev:120:180
+
+The first override which matches an instance is used, and its warning
+threshold must not be greater than its failure threshold.
+
+Instances whose AMI no longer exists, perhaps because it has been
+deregistered, are ignored.  Any other failure to look up an AMI, such as
+throttling or a permission problem, is an error.
+`
+
+}
+
+// CollectInstances gathers the running instances of each account.
+func (a *amiCheckCommand) CollectInstances(svc *ec2.EC2, acct string, void interface{}) error {
+
+	// Get the running instances.
+	before := len(a.results)
+	err := a.instanceCollector.CollectInstances(svc, acct, void)
+	if err != nil {
+		return err
+	}
+
+	// The age of an AMI which couldn't be looked up is unknown, so we
+	// look each one up again, to report failures other than the AMI
+	// no longer existing.
+	seen := make(map[string]bool)
+	for _, obj := range a.results[before:] {
+		if obj.AMIAge >= 0 || seen[obj.InstanceAMI] {
+			continue
+		}
+		seen[obj.InstanceAMI] = true
+
+		_, err = amiage.AMIAge(svc, obj.InstanceAMI)
+		if err != nil && !errors.Is(err, amiage.NotFound) {
+			return fmt.Errorf("error getting AMI age for %s: %s", obj.InstanceAMI, err)
+		}
+	}
+	return nil
+}
+
+// thresholds returns the warning and failure thresholds for the given
+// instance.
+func (a *amiCheckCommand) thresholds(obj instances.InstanceOutput) (int, int) {
+
+	for _, o := range a.overrides {
+		if val, ok := obj.Tags[o.Key]; ok && val == o.Value {
+			return o.Warn, o.Fail
+		}
+	}
+	return a.warn, a.fail
+}
+
+// Check reports upon instances which exceed their thresholds, and returns
+// the status code to exit with.
+func (a *amiCheckCommand) Check(objs []instances.InstanceOutput) int {
+
+	// The offending instances, keyed by AMI, for each level
+	failed := make(map[string][]instances.InstanceOutput)
+	warned := make(map[string][]instances.InstanceOutput)
+
+	for _, obj := range objs {
+
+		// AMI not found
+		if obj.AMIAge < 0 {
+			continue
+		}
+
+		warn, fail := a.thresholds(obj)
+
+		switch {
+		case obj.AMIAge > fail:
+			failed[obj.InstanceAMI] = append(failed[obj.InstanceAMI], obj)
+		case obj.AMIAge > warn:
+			warned[obj.InstanceAMI] = append(warned[obj.InstanceAMI], obj)
+		}
+	}
+
+	a.report("FAIL", failed)
+	a.report("WARN", warned)
+
+	if len(failed) > 0 {
+		return 2
+	}
+	if len(warned) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// report shows the offending instances, grouped by AMI, oldest first.
+func (a *amiCheckCommand) report(level string, amis map[string][]instances.InstanceOutput) {
+
+	keys := make([]string, 0, len(amis))
+	for key := range amis {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		x := amis[keys[i]][0].AMIAge
+		y := amis[keys[j]][0].AMIAge
+		if x != y {
+			return x > y
+		}
+		return keys[i] < keys[j]
+	})
+
+	for _, key := range keys {
+		objs := amis[key]
+		fmt.Printf("%s %s - %d days old\n", level, key, objs[0].AMIAge)
+		for _, obj := range objs {
+			fmt.Printf("     %s %s %s\n", obj.AWSAccount, obj.InstanceID, obj.InstanceName)
+		}
+	}
+}
+
+// Execute is invoked if the user specifies this subcommand.
+func (a *amiCheckCommand) Execute(args []string) int {
+
+	if a.warn > a.fail {
+		fmt.Printf("the warning threshold must not be greater than the failure threshold\n")
+		return 3
+	}
+
+	//
+	// Get the connection, using default credentials
+	//
+	session, err := utils.NewSession()
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 3
+	}
+
+	//
+	// Now invoke our callback - this will call the function
+	// "CollectInstances" once if we're not running with a role-file,
+	// otherwise once for each role.
+	//
+	errs := utils.HandleRoles(session, a.rolesPath, a.CollectInstances, nil)
+	if len(errs) > 0 {
+		fmt.Printf("errors running AMI check\n")
+		for _, err := range errs {
+			fmt.Printf("%s\n", err)
+		}
+		return 3
+	}
+
+	return a.Check(a.results)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/skx/aws-utils/instances"
+)
+
+// TestAMIOverrides tests the parsing of threshold overrides.
+func TestAMIOverrides(t *testing.T) {
+
+	type TestCase struct {
+		Input string
+		Error bool
+	}
+
+	tests := []TestCase{
+		{"Environment=dev:120:180", false},
+		{"Environment=dev:90:90", false},
+		{"Environment=dev:180:120", true},
+		{"Environment=dev:120", true},
+		{"Environment:120:180", true},
+		{"Environment=dev:x:180", true},
+		{"Environment=dev:120:y", true},
+	}
+
+	for _, test := range tests {
+
+		var o amiOverrides
+		err := o.Set(test.Input)
+
+		if test.Error && err == nil {
+			t.Errorf("expected error parsing %s, got none", test.Input)
+		}
+		if !test.Error && err != nil {
+			t.Errorf("unexpected error parsing %s: %s", test.Input, err)
+		}
+	}
+}
+
+// TestAMICheck tests the exit codes returned by our check.
+func TestAMICheck(t *testing.T) {
+
+	type TestCase struct {
+		Ages   []int
+		Result int
+	}
+
+	tests := []TestCase{
+		{[]int{}, 0},
+		{[]int{10, 60}, 0},
+		{[]int{-1, 500}, 2},
+		{[]int{-1}, 0},
+		{[]int{61}, 1},
+		{[]int{90}, 1},
+		{[]int{91}, 2},
+		{[]int{61, 91}, 2},
+	}
+
+	for _, test := range tests {
+
+		objs := []instances.InstanceOutput{}
+		for _, age := range test.Ages {
+			objs = append(objs, instances.InstanceOutput{InstanceAMI: "ami-1", AMIAge: age})
+		}
+
+		a := &amiCheckCommand{warn: 60, fail: 90}
+		out := a.Check(objs)
+		if out != test.Result {
+			t.Errorf("ages %v: expected %d, got %d", test.Ages, test.Result, out)
+		}
+	}
+}
+
+// TestAMIThresholds tests that overrides are applied to tagged instances.
+func TestAMIThresholds(t *testing.T) {
+
+	a := &amiCheckCommand{warn: 60, fail: 90}
+	if err := a.overrides.Set("Environment=dev:120:180"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	dev := instances.InstanceOutput{Tags: map[string]string{"Environment": "dev"}}
+	prod := instances.InstanceOutput{Tags: map[string]string{"Environment": "prod"}}
+
+	if w, f := a.thresholds(dev); w != 120 || f != 180 {
+		t.Errorf("wrong thresholds for dev: %d %d", w, f)
+	}
+	if w, f := a.thresholds(prod); w != 60 || f != 90 {
+		t.Errorf("wrong thresholds for prod: %d %d", w, f)
+	}
+}
@@ -0,0 +1,247 @@
+// Output our instances as an Ansible dynamic inventory.
+//
+// Primarily written to avoid post-processing the output of csv-instances.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/skx/aws-utils/instances"
+	"github.com/skx/aws-utils/utils"
+)
+
+// Structure for our options and state.
+type ansibleInventoryCommand struct {
+
+	// Path to a file containing roles
+	rolesPath string
+
+	// Output the whole inventory?
+	list bool
+
+	// Output the variables for a single host
+	host string
+
+	// Use the public IPv4 address for ansible_host?
+	public bool
+
+	// Tags to create groups from
+	tags string
+
+	// Filtering and sorting options
+	instanceSelection
+
+	// The instances we've found, across all accounts
+	instanceCollector
+}
+
+// Arguments adds per-command args to the object.
+func (a *ansibleInventoryCommand) Arguments(f *flag.FlagSet) {
+	f.StringVar(&a.rolesPath, "roles", "", "Path to a list of roles to process, one by one")
+	f.BoolVar(&a.list, "list", false, "Output the complete inventory")
+	f.StringVar(&a.host, "host", "", "Output the variables of the given host")
+	f.BoolVar(&a.public, "public", false, "Use the public IPv4 address of each instance for ansible_host")
+	f.StringVar(&a.tags, "tags", "", "Comma-separated list of tag names to create groups from")
+	a.selectionArguments(f)
+}
+
+// Info returns the name of this subcommand.
+func (a *ansibleInventoryCommand) Info() (string, string) {
+	return "ansible-inventory", `Output running instances as an Ansible inventory.
+
+Details:
+
+This command implements the Ansible dynamic inventory protocol, allowing
+your running instances to be used as an inventory directly.
+
+Hosts are named after their instance name, and grouped by account, VPC,
+subnet, and instance type - for example "account_123456789012",
+"vpc_vpc_0123abcd", "subnet_subnet_0123abcd", and "type_t3_small".
+
+Groups may also be created from the values of tags, via '-tags':
+
+    $ aws-utils ansible-inventory -list -tags=Environment,Role
+
+Would add groups such as "tag_Environment_prod" and "tag_Role_web".
+
+The address Ansible connects to, ansible_host, is the private IPv4 address
+of each instance unless '-public' is specified.
+
+To use this as an inventory create a small wrapper script, and point
+Ansible at it:
+
+    $ cat inventory.sh
+    #!/bin/sh
+    exec aws-utils ansible-inventory -roles=/path/to/roles -tags=Role "$@"
+    $ ansible -i ./inventory.sh all -m ping
+` + selectionHelp
+
+}
+
+// groupName converts the given parts into a valid Ansible group name.
+func groupName(parts ...string) string {
+	re := regexp.MustCompile("[^A-Za-z0-9_]")
+	return re.ReplaceAllString(strings.Join(parts, "_"), "_")
+}
+
+// Inventory builds the inventory from the given instances.
+func (a *ansibleInventoryCommand) Inventory(objs []instances.InstanceOutput) map[string]interface{} {
+
+	// The tags we'll group by
+	tags := []string{}
+	for _, tag := range strings.Split(a.tags, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	// Count the names, so duplicates can be made unique.
+	seen := make(map[string]int)
+	for _, obj := range objs {
+		seen[obj.InstanceName]++
+	}
+
+	groups := make(map[string][]string)
+	hostvars := make(map[string]interface{})
+
+	for _, obj := range objs {
+
+		host := obj.InstanceName
+		if seen[host] > 1 {
+			host = obj.InstanceName + "_" + obj.InstanceID
+		}
+
+		// Choose the address to connect to
+		addr := obj.PrivateIPv4
+		if a.public || addr == "" {
+			addr = obj.PublicIPv4
+		}
+		if addr == "" {
+			addr = obj.PrivateIPv4
+		}
+
+		hostvars[host] = map[string]interface{}{
+			"ansible_host":   addr,
+			"ec2_account":    obj.AWSAccount,
+			"ec2_id":         obj.InstanceID,
+			"ec2_type":       obj.InstanceType,
+			"ec2_ami":        obj.InstanceAMI,
+			"ec2_az":         obj.AvailabilityZone,
+			"ec2_vpc_id":     obj.VPCID,
+			"ec2_subnet_id":  obj.SubnetID,
+			"ec2_private_ip": obj.PrivateIPv4,
+			"ec2_public_ip":  obj.PublicIPv4,
+			"ec2_tags":       obj.Tags,
+		}
+
+		add := func(name string) {
+			groups[name] = append(groups[name], host)
+		}
+		add(groupName("account", obj.AWSAccount))
+		add(groupName("vpc", obj.VPCID))
+		add(groupName("subnet", obj.SubnetID))
+		add(groupName("type", obj.InstanceType))
+		for _, tag := range tags {
+			if val, ok := obj.Tags[tag]; ok {
+				add(groupName("tag", tag, val))
+			}
+		}
+	}
+
+	ret := make(map[string]interface{})
+	children := []string{}
+	for name, hosts := range groups {
+		sort.Strings(hosts)
+		ret[name] = map[string]interface{}{"hosts": hosts}
+		children = append(children, name)
+	}
+	sort.Strings(children)
+
+	ret["all"] = map[string]interface{}{"children": children}
+	ret["_meta"] = map[string]interface{}{"hostvars": hostvars}
+	return ret
+}
+
+// Output returns what we should output, which is either the whole
+// inventory, with '-list', or the variables of the host given via '-host'.
+//
+// Unknown hosts have no variables, rather than being an error.
+func (a *ansibleInventoryCommand) Output(objs []instances.InstanceOutput) interface{} {
+
+	inventory := a.Inventory(objs)
+	if a.list {
+		return inventory
+	}
+
+	vars := inventory["_meta"].(map[string]interface{})["hostvars"].(map[string]interface{})
+	out, ok := vars[a.host]
+	if !ok {
+		out = map[string]interface{}{}
+	}
+	return out
+}
+
+// Execute is invoked if the user specifies this subcommand.
+//
+// Ansible parses our standard output as JSON, so all errors are written
+// to standard error.
+func (a *ansibleInventoryCommand) Execute(args []string) int {
+
+	if !a.list && a.host == "" {
+		fmt.Fprintf(os.Stderr, "Please specify either '-list' or '-host name'\n")
+		return 1
+	}
+
+	// Parse the filter-expression and sort-keys
+	err := a.parseSelection()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	//
+	// Get the connection, using default credentials
+	//
+	session, err := utils.NewSession()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 1
+	}
+
+	//
+	// Now invoke our callback - this will call the function
+	// "CollectInstances" once if we're not running with a role-file,
+	// otherwise once for each role.
+	//
+	errs := utils.HandleRoles(session, a.rolesPath, a.CollectInstances, nil)
+
+	ret, err := a.applySelection(a.results)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "errors running inventory\n")
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+		return 1
+	}
+
+	b, err := json.MarshalIndent(a.Output(ret), "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error exporting to JSON %s\n", err)
+		return 1
+	}
+	fmt.Println(string(b))
+
+	return 0
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/skx/aws-utils/instances"
+)
+
+// inventoryInstances returns the instances our inventory tests use.
+func inventoryInstances() []instances.InstanceOutput {
+	return []instances.InstanceOutput{
+		{AWSAccount: "123456789012", InstanceID: "i-1", InstanceName: "web", InstanceType: "t3.small",
+			VPCID: "vpc-1", SubnetID: "subnet-1", PrivateIPv4: "10.0.0.1", PublicIPv4: "3.1.1.1",
+			Tags: map[string]string{"Role": "web", "Environment": "prod"}},
+		{AWSAccount: "123456789012", InstanceID: "i-2", InstanceName: "db", InstanceType: "r5.large",
+			VPCID: "vpc-1", SubnetID: "subnet-2", PrivateIPv4: "10.0.0.2",
+			Tags: map[string]string{"Role": "db"}},
+		{AWSAccount: "210987654321", InstanceID: "i-3", InstanceName: "db", InstanceType: "r5.large",
+			VPCID: "vpc-2", SubnetID: "subnet-3", PublicIPv4: "3.3.3.3"},
+	}
+}
+
+// inventoryJSON returns the output of the inventory, as Ansible would
+// parse it.
+func inventoryJSON(t *testing.T, a *ansibleInventoryCommand) map[string]interface{} {
+	b, err := json.Marshal(a.Output(inventoryInstances()))
+	if err != nil {
+		t.Fatalf("failed to export JSON: %s", err)
+	}
+
+	out := make(map[string]interface{})
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatalf("failed to parse JSON: %s", err)
+	}
+	return out
+}
+
+// TestInventoryList tests the groups and host variables of '-list'.
+func TestInventoryList(t *testing.T) {
+
+	a := &ansibleInventoryCommand{list: true, tags: "Role, Missing"}
+	out := inventoryJSON(t, a)
+
+	type TestCase struct {
+		Group string
+		Hosts string
+	}
+
+	tests := []TestCase{
+		{"account_123456789012", "db_i-2,web"},
+		{"account_210987654321", "db_i-3"},
+		{"vpc_vpc_1", "db_i-2,web"},
+		{"subnet_subnet_3", "db_i-3"},
+		{"type_r5_large", "db_i-2,db_i-3"},
+		{"type_t3_small", "web"},
+		{"tag_Role_web", "web"},
+		{"tag_Role_db", "db_i-2"},
+	}
+
+	for _, test := range tests {
+		group, ok := out[test.Group].(map[string]interface{})
+		if !ok {
+			t.Errorf("group %s is missing", test.Group)
+			continue
+		}
+
+		hosts := []string{}
+		for _, h := range group["hosts"].([]interface{}) {
+			hosts = append(hosts, h.(string))
+		}
+		if strings.Join(hosts, ",") != test.Hosts {
+			t.Errorf("%s: expected %s, got %s", test.Group, test.Hosts, strings.Join(hosts, ","))
+		}
+	}
+
+	// Untagged, and unrequested, tags have no groups.
+	for _, name := range []string{"tag_Environment_prod", "tag_Missing_"} {
+		if _, ok := out[name]; ok {
+			t.Errorf("unexpected group %s", name)
+		}
+	}
+
+	// Every group, except "all" and "_meta", is a child of "all".
+	children := out["all"].(map[string]interface{})["children"].([]interface{})
+	if len(children) != len(out)-2 {
+		t.Errorf("expected %d children of all, got %d", len(out)-2, len(children))
+	}
+
+	// Each host has its variables within _meta.
+	hostvars := out["_meta"].(map[string]interface{})["hostvars"].(map[string]interface{})
+	if len(hostvars) != 3 {
+		t.Errorf("expected 3 hosts, got %d", len(hostvars))
+	}
+	web := hostvars["web"].(map[string]interface{})
+	if web["ansible_host"] != "10.0.0.1" || web["ec2_id"] != "i-1" {
+		t.Errorf("unexpected variables for web: %v", web)
+	}
+}
+
+// TestInventoryHost tests the variables output by '-host'.
+func TestInventoryHost(t *testing.T) {
+
+	type TestCase struct {
+		Host    string
+		Public  bool
+		Address string
+	}
+
+	tests := []TestCase{
+		{"web", false, "10.0.0.1"},
+		{"web", true, "3.1.1.1"},
+
+		// Falling back to the other address, if one is missing
+		{"db_i-2", true, "10.0.0.2"},
+		{"db_i-3", false, "3.3.3.3"},
+
+		// Unknown hosts have no variables
+		{"db", false, ""},
+	}
+
+	for _, test := range tests {
+
+		a := &ansibleInventoryCommand{host: test.Host, public: test.Public}
+		out := inventoryJSON(t, a)
+
+		if test.Address == "" {
+			if len(out) != 0 {
+				t.Errorf("%s: expected no variables, got %v", test.Host, out)
+			}
+			continue
+		}
+		if out["ansible_host"] != test.Address {
+			t.Errorf("%s: expected ansible_host %s, got %v", test.Host, test.Address, out["ansible_host"])
+		}
+	}
+}
@@ -0,0 +1,462 @@
+// Shell completion, including the names of instances and stacks.
+//
+// The built-in "bash-completion" sub-command only completes the names
+// of sub-commands and their flags.
+
+package main
+
+import (
+	"crypto/sha1"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/skx/aws-utils/utils"
+)
+
+// completionBash is the template for our bash completion-script.
+var completionBash = `
+_#Command#_complete()
+{
+    local cur prev sub roles
+    COMPREPLY=()
+
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+    sub="${COMP_WORDS[1]}"
+
+    # The first argument is one of the available sub-commands.
+    if [ $COMP_CWORD = 1 ]; then
+        COMPREPLY=($(compgen -W "$(#Command# commands)" -- "$cur"))
+        return
+    fi
+
+    # bash splits "-flag=value" into three words, handle that.
+    if [ "$prev" = "=" ] && [ $COMP_CWORD -gt 2 ]; then
+        prev="${COMP_WORDS[COMP_CWORD-2]}"
+    fi
+    if [ "$cur" = "=" ]; then
+        cur=""
+    fi
+
+    # Any role-file which has already been specified.
+    roles=$(echo "$COMP_LINE" | sed -n 's/.*-roles[= ]\([^ ]*\).*/\1/p')
+
+    case "$prev" in
+        -roles|--roles)
+            COMPREPLY=($(compgen -f -- "$cur"))
+            return
+            ;;
+        -filter|--filter)
+            if [ "$sub" = "stacks" ]; then
+                COMPREPLY=($(compgen -W "$(#Command# completion -list=stacks -roles="$roles" 2>/dev/null)" -- "$cur"))
+                return
+            fi
+            ;;
+    esac
+
+    if [[ "$cur" =~ ^-.* ]]; then
+        local flags="$(#Command# help $sub | awk '{print $1}' | grep -- '^-[a-z0-9-]*$')"
+        COMPREPLY=($(compgen -W "${flags}" -- "$cur"))
+        return
+    fi
+
+    case "$sub" in
+        ip|connect)
+            COMPREPLY=($(compgen -W "$(#Command# completion -list=instances -roles="$roles" 2>/dev/null)" -- "$cur"))
+            ;;
+        help)
+            COMPREPLY=($(compgen -W "$(#Command# commands)" -- "$cur"))
+            ;;
+        *)
+            COMPREPLY=($(compgen -f -- "$cur"))
+            ;;
+    esac
+}
+
+complete -F _#Command#_complete #Command#
+`
+
+// completionZsh is the template for our zsh completion-script.
+var completionZsh = `#compdef #Command#
+
+_#Command#() {
+    local sub roles
+    sub="${words[2]}"
+
+    # The first argument is one of the available sub-commands.
+    if (( CURRENT == 2 )); then
+        compadd -- $(#Command# commands)
+        return
+    fi
+
+    # Any role-file which has already been specified.
+    roles=$(print -r -- "$BUFFER" | sed -n 's/.*-roles[= ]\([^ ]*\).*/\1/p')
+
+    case "${words[CURRENT-1]}" in
+        -roles|--roles)
+            _files
+            return
+            ;;
+        -filter|--filter)
+            if [[ "$sub" == "stacks" ]]; then
+                compadd -- $(#Command# completion -list=stacks -roles="$roles" 2>/dev/null)
+                return
+            fi
+            ;;
+    esac
+
+    case "${words[CURRENT]}" in
+        -roles=*|--roles=*)
+            compset -P '*='
+            _files
+            return
+            ;;
+        -filter=*|--filter=*)
+            if [[ "$sub" == "stacks" ]]; then
+                compset -P '*='
+                compadd -- $(#Command# completion -list=stacks -roles="$roles" 2>/dev/null)
+                return
+            fi
+            ;;
+        -*)
+            compadd -- $(#Command# help $sub | awk '{print $1}' | grep -- '^-[a-z0-9-]*$')
+            return
+            ;;
+    esac
+
+    case "$sub" in
+        ip|connect)
+            compadd -- $(#Command# completion -list=instances -roles="$roles" 2>/dev/null)
+            ;;
+        help)
+            compadd -- $(#Command# commands)
+            ;;
+        *)
+            _files
+            ;;
+    esac
+}
+
+compdef _#Command# #Command#
+`
+
+// completionFish is the template for our fish completion-script.
+var completionFish = `
+function __#Command#_roles
+    set -l tokens (commandline -opc)
+    for i in (seq (count $tokens))
+        switch $tokens[$i]
+            case '-roles=*' '--roles=*'
+                string replace -r '^-+roles=' '' -- $tokens[$i]
+            case '-roles' '--roles'
+                set -l j (math $i + 1)
+                if test $j -le (count $tokens)
+                    echo $tokens[$j]
+                end
+        end
+    end
+end
+
+function __#Command#_list
+    #Command# completion -list=$argv[1] -roles=(__#Command#_roles)[1] 2>/dev/null
+end
+
+function __#Command#_complete
+    set -l tokens (commandline -opc)
+    set -l cur (commandline -ct)
+
+    # The first argument is one of the available sub-commands.
+    if test (count $tokens) -eq 1
+        #Command# commands
+        return
+    end
+
+    set -l sub $tokens[2]
+
+    switch $tokens[-1]
+        case '-roles' '--roles'
+            __fish_complete_path $cur
+            return
+        case '-filter' '--filter'
+            if test "$sub" = stacks
+                __#Command#_list stacks
+                return
+            end
+    end
+
+    switch $cur
+        case '-roles=*' '--roles=*'
+            set -l prefix (string replace -r '=.*' '=' -- $cur)
+            for p in (__fish_complete_path (string replace -r '^[^=]*=' '' -- $cur))
+                echo $prefix$p
+            end
+            return
+        case '-filter=*' '--filter=*'
+            if test "$sub" = stacks
+                set -l prefix (string replace -r '=.*' '=' -- $cur)
+                for s in (__#Command#_list stacks)
+                    echo $prefix$s
+                end
+                return
+            end
+        case '-*'
+            #Command# help $sub | awk '{print $1}' | grep -- '^-[a-z0-9-]*$'
+            return
+    end
+
+    switch $sub
+        case ip connect
+            __#Command#_list instances
+        case help
+            #Command# commands
+        case '*'
+            __fish_complete_path $cur
+    end
+end
+
+complete -c #Command# -f -a '(__#Command#_complete)'
+`
+
+// Structure for our options and state.
+type completionCommand struct {
+
+	// The shell to output a completion-script for
+	shell string
+
+	// The type of names to list, for use by the completion-scripts
+	list string
+
+	// Path to a file containing roles
+	rolesPath string
+
+	// How long cached names remain valid
+	ttl time.Duration
+
+	// The instances we've found, across all accounts
+	instanceCollector
+
+	// The names we've found, across all accounts
+	names []string
+}
+
+// Arguments adds per-command args to the object.
+func (c *completionCommand) Arguments(f *flag.FlagSet) {
+	f.StringVar(&c.shell, "shell", "bash", "The shell to output a completion-script for: bash, zsh, or fish")
+	f.StringVar(&c.list, "list", "", "List the names of 'instances' or 'stacks', for use by the completion-scripts")
+	f.StringVar(&c.rolesPath, "roles", "", "Path to a list of roles to process, one by one, when listing names")
+	f.DurationVar(&c.ttl, "ttl", 5*time.Minute, "How long to cache the listed names for")
+}
+
+// Info returns the name of this subcommand.
+func (c *completionCommand) Info() (string, string) {
+	return "completion", `Generate a completion-script, including instance names.
+
+Details:
+
+The built-in 'bash-completion' sub-command completes only the names of
+sub-commands and their flags.  This command outputs a completion-script
+which also completes:
+
+* The names of running instances, for 'ip' and 'connect'.
+* The names of cloudformation stacks, for 'stacks -filter'.
+* The path to a role-file, for '-roles'.
+
+Scripts are available for bash, zsh, and fish:
+
+    $ source <(aws-utils completion -shell=bash)
+    $ aws-utils completion -shell=zsh > ~/.zsh/completions/_aws-utils
+    $ aws-utils completion -shell=fish > ~/.config/fish/completions/aws-utils.fish
+
+The scripts find names by running this command with '-list', which
+caches them locally for five minutes, by default, to keep completion fast:
+
+    $ aws-utils completion -list=instances -roles=./roles
+
+If some accounts fail the names found within the others are still listed,
+and cached, and the errors are reported upon STDERR.
+`
+
+}
+
+// CollectStacks gathers the names of the stacks which haven't been deleted.
+func (c *completionCommand) CollectStacks(svc *ec2.EC2, acct string, void interface{}) error {
+
+	stacks, err := listStacks(cloudformationClient(svc))
+	if err != nil {
+		return err
+	}
+
+	for _, ent := range stacks {
+		if !strings.Contains(*ent.StackStatus, "DELETE") {
+			c.names = append(c.names, *ent.StackName)
+		}
+	}
+	return nil
+}
+
+// cachePath returns the path of the cache-file for the given list,
+// which is specific to the current profile and role-file.
+func (c *completionCommand) cachePath(list string) (string, error) {
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	roles := c.rolesPath
+	if roles != "" {
+		roles, _ = filepath.Abs(roles)
+	}
+
+	key := fmt.Sprintf("%s|%s|%s", list, os.Getenv("AWS_PROFILE"), roles)
+	sum := sha1.Sum([]byte(key))
+
+	return filepath.Join(dir, "aws-utils", fmt.Sprintf("%s-%x", list, sum[:6])), nil
+}
+
+// readCache returns the names stored in the given cache-file, if it is
+// younger than the given age.
+func readCache(path string, ttl time.Duration) ([]string, bool) {
+
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) >= ttl {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return strings.Fields(string(data)), true
+}
+
+// writeCache stores the given names in the cache-file, ignoring failures.
+func writeCache(path string, names []string) {
+
+	if os.MkdirAll(filepath.Dir(path), 0755) == nil {
+		os.WriteFile(path, []byte(strings.Join(names, "\n")+"\n"), 0644)
+	}
+}
+
+// uniqueNames returns the given names sorted, without duplicates, and
+// without any names which can't be completed as a single word.
+func uniqueNames(names []string) []string {
+
+	sorted := make([]string, len(names))
+	copy(sorted, names)
+	sort.Strings(sorted)
+
+	ret := []string{}
+	for _, name := range sorted {
+		if name == "" || strings.ContainsAny(name, " \t") {
+			continue
+		}
+		if len(ret) > 0 && ret[len(ret)-1] == name {
+			continue
+		}
+		ret = append(ret, name)
+	}
+	return ret
+}
+
+// Names returns the names of the given type, using the cache if it
+// is fresh enough.
+//
+// If some accounts fail the names found within the others are returned,
+// and cached, along with the errors.
+func (c *completionCommand) Names(list string) ([]string, []error) {
+
+	var collect utils.AWSCallback
+	switch list {
+	case "instances":
+		collect = c.CollectInstances
+	case "stacks":
+		collect = c.CollectStacks
+	default:
+		return nil, []error{fmt.Errorf("unknown list '%s', valid choices are 'instances' or 'stacks'", list)}
+	}
+
+	// Is there a fresh cache?
+	path, err := c.cachePath(list)
+	if err == nil {
+		if names, ok := readCache(path, c.ttl); ok {
+			return names, nil
+		}
+	}
+
+	session, err := utils.NewSession()
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	errs := utils.HandleRoles(session, c.rolesPath, collect, nil)
+
+	// The names of instances come from the instances we collected.
+	for _, obj := range c.results {
+		c.names = append(c.names, obj.InstanceName)
+	}
+	ret := uniqueNames(c.names)
+
+	// Update the cache.
+	if path != "" {
+		writeCache(path, ret)
+	}
+
+	return ret, errs
+}
+
+// completionScript returns the completion-script for the given shell,
+// which completes the given command.
+func completionScript(shell string, command string) (string, error) {
+
+	tmpl := ""
+	switch shell {
+	case "bash":
+		tmpl = completionBash
+	case "zsh":
+		tmpl = completionZsh
+	case "fish":
+		tmpl = completionFish
+	default:
+		return "", fmt.Errorf("unknown shell '%s', valid choices are 'bash', 'zsh', or 'fish'", shell)
+	}
+
+	return strings.ReplaceAll(tmpl, "#Command#", command), nil
+}
+
+// Execute is invoked if the user specifies this subcommand.
+func (c *completionCommand) Execute(args []string) int {
+
+	// Listing names?
+	//
+	// Errors are shown upon STDERR, after the names, so that the
+	// completion-scripts may ignore them.
+	if c.list != "" {
+		names, errs := c.Names(c.list)
+		for _, name := range names {
+			fmt.Printf("%s\n", name)
+		}
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+		if len(errs) > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	script, err := completionScript(c.shell, filepath.Base(os.Args[0]))
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	fmt.Printf("%s\n", script)
+	return 0
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCompletionScript tests generating the completion-scripts.
+func TestCompletionScript(t *testing.T) {
+
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+
+		out, err := completionScript(shell, "my-utils")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", shell, err)
+			continue
+		}
+		if strings.Contains(out, "#Command#") {
+			t.Errorf("%s: placeholder wasn't replaced", shell)
+		}
+		if !strings.Contains(out, "my-utils completion -list=") {
+			t.Errorf("%s: script doesn't invoke the command", shell)
+		}
+	}
+
+	if _, err := completionScript("csh", "my-utils"); err == nil {
+		t.Errorf("expected error with an unknown shell")
+	}
+}
+
+// TestUniqueNames tests sorting, and removing duplicates from, names.
+func TestUniqueNames(t *testing.T) {
+
+	type TestCase struct {
+		Input  []string
+		Output string
+	}
+
+	tests := []TestCase{
+		{nil, ""},
+		{[]string{"web", "db", "web", "app"}, "app,db,web"},
+		{[]string{"", "db", "has space", "has\ttab", "db"}, "db"},
+	}
+
+	for _, test := range tests {
+		out := strings.Join(uniqueNames(test.Input), ",")
+		if out != test.Output {
+			t.Errorf("%v: expected %s, got %s", test.Input, test.Output, out)
+		}
+	}
+}
+
+// TestCompletionCachePath tests that each list, profile, and role-file
+// has its own cache.
+func TestCompletionCachePath(t *testing.T) {
+
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("AWS_PROFILE", "one")
+
+	path := func(list string, roles string) string {
+		c := &completionCommand{rolesPath: roles}
+		p, err := c.cachePath(list)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return p
+	}
+
+	base := path("instances", "roles")
+	if base != path("instances", "roles") {
+		t.Errorf("cache path isn't stable")
+	}
+	if !strings.HasPrefix(filepath.Base(base), "instances-") {
+		t.Errorf("cache path doesn't name the list: %s", base)
+	}
+	if base == path("stacks", "roles") {
+		t.Errorf("lists share a cache")
+	}
+	if base == path("instances", "") || base == path("instances", "other") {
+		t.Errorf("role-files share a cache")
+	}
+
+	// Relative role-files are resolved, so the directory matters.
+	abs, _ := filepath.Abs("roles")
+	if base != path("instances", abs) {
+		t.Errorf("relative and absolute role-files don't share a cache")
+	}
+
+	t.Setenv("AWS_PROFILE", "two")
+	if base == path("instances", "roles") {
+		t.Errorf("profiles share a cache")
+	}
+}
+
+// TestCompletionCache tests reading and writing the cache of names.
+func TestCompletionCache(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "aws-utils", "instances-abc")
+
+	if _, ok := readCache(path, time.Minute); ok {
+		t.Errorf("missing cache was read")
+	}
+
+	writeCache(path, []string{"app", "db"})
+
+	names, ok := readCache(path, time.Minute)
+	if !ok || strings.Join(names, ",") != "app,db" {
+		t.Errorf("unexpected cache contents %v %t", names, ok)
+	}
+
+	// A stale cache is ignored
+	old := time.Now().Add(-2 * time.Minute)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatalf("failed to age cache: %s", err)
+	}
+	if _, ok := readCache(path, time.Minute); ok {
+		t.Errorf("stale cache was read")
+	}
+
+	// An empty list is cached too
+	writeCache(path, []string{})
+	names, ok = readCache(path, time.Minute)
+	if !ok || len(names) != 0 {
+		t.Errorf("unexpected cache contents %v %t", names, ok)
+	}
+}
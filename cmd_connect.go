@@ -0,0 +1,417 @@
+// Connect to the named instance, via SSH.
+//
+// Primarily written to avoid "ssh ec2-user@$(aws-utils ip foo)".
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/skx/aws-utils/instances"
+	"github.com/skx/aws-utils/utils"
+)
+
+// connectDefaultCommand is the default template for the command we run.
+var connectDefaultCommand = `ssh{{if .Identity}} -i {{.Identity}}{{end}}{{if .Jump}} -J {{.Jump}}{{end}} {{.User}}@{{.Address}}`
+
+// connectTarget is the structure passed to the command template.
+type connectTarget struct {
+
+	// Name of the instance
+	Name string
+
+	// ID of the instance
+	ID string
+
+	// Address to connect to
+	Address string
+
+	// User to connect as
+	User string
+
+	// Identity file, if it exists
+	Identity string
+
+	// Jump-host, in the form "user@address", if any
+	Jump string
+}
+
+// Structure for our options and state.
+type connectCommand struct {
+
+	// Path to a file containing roles
+	rolesPath string
+
+	// Connect to the public address?
+	public bool
+
+	// Default user, if the AMI isn't recognized
+	user string
+
+	// Format string for the identity file, "%s" is replaced by the key-name
+	identity string
+
+	// Pattern matching bastion hosts, if any
+	bastion string
+
+	// Template for the command to execute
+	command string
+
+	// Name-matching options
+	instanceMatcher
+
+	// Filtering and sorting options
+	instanceSelection
+
+	// The instances we've found, across all accounts
+	instanceCollector
+}
+
+// Arguments adds per-command args to the object.
+func (c *connectCommand) Arguments(f *flag.FlagSet) {
+	f.StringVar(&c.rolesPath, "roles", "", "Path to a list of roles to process, one by one")
+	f.BoolVar(&c.public, "public", false, "Connect to the public IPv4 address of the instance")
+	f.StringVar(&c.user, "user", "ec2-user", "The user to use when the AMI owner isn't recognized")
+	f.StringVar(&c.identity, "identity", "~/.ssh/%s.pem", "The identity file to use, if present, '%s' is replaced with the instance key-name")
+	f.StringVar(&c.bastion, "bastion", "", "A regular expression matching the names of bastion hosts, used as a jump-host within their VPC")
+	f.StringVar(&c.command, "command", connectDefaultCommand, "The template of the command to execute")
+	c.matcherArguments(f)
+	c.selectionArguments(f)
+}
+
+// Info returns the name of this subcommand.
+func (c *connectCommand) Info() (string, string) {
+	return "connect", `Connect to the given instance via SSH.
+
+Details:
+
+This command finds the instance which matches the given regular
+expression, in the same way as the 'ip' command, and connects to it
+via SSH:
+
+    $ aws-utils connect 'prod.*manager'
+
+The user is chosen by the owner of the AMI the instance is running, in
+the same way as 'ssh-config', falling back to the value of '-user'.
+
+If several instances match you'll be presented with a numbered list to
+choose from.
+
+If your instances are only reachable via a bastion host you may specify
+a regular expression matching the names of your bastions, via '-bastion',
+and the bastion within the VPC of the instance will be used as a jump-host.
+Only instances with a public address are considered to be bastions.
+
+Any arguments following '--' are passed to the command:
+
+    $ aws-utils connect -roles=./roles prod-web -- -L 8080:localhost:80
+
+The command to execute is a template, which has access to the fields
+Name, ID, Address, User, Identity, and Jump.  The default is:
+
+    ` + connectDefaultCommand + `
+
+The values of the fields are quoted, and the result is split into words
+as the shell would, so values containing spaces are handled correctly.
+The command replaces this process, so signals, the terminal, and the exit
+status are those of the command.  (On Windows it is run as a child.)
+` + selectionHelp
+
+}
+
+// Choose returns the instance the user selects from the given list.
+func (c *connectCommand) Choose(objs []instances.InstanceOutput) (instances.InstanceOutput, error) {
+
+	// We can only prompt if we're running upon a terminal.
+	stat, err := os.Stdin.Stat()
+	if err != nil || (stat.Mode()&os.ModeCharDevice) == 0 {
+		return instances.InstanceOutput{}, fmt.Errorf("%d instances matched, and STDIN is not a terminal", len(objs))
+	}
+
+	for n, obj := range objs {
+		fmt.Printf("%3d. %-30s %-20s %-15s %s\n", n+1, obj.InstanceName, obj.InstanceID, obj.PrivateIPv4, obj.AWSAccount)
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf("Choose an instance [1-%d]: ", len(objs))
+
+		text, err := reader.ReadString('\n')
+		if err != nil {
+			return instances.InstanceOutput{}, fmt.Errorf("no instance chosen")
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(text))
+		if err == nil && n >= 1 && n <= len(objs) {
+			return objs[n-1], nil
+		}
+	}
+}
+
+// Target returns the details of how to connect to the given instance.
+func (c *connectCommand) Target(obj instances.InstanceOutput) (connectTarget, error) {
+
+	t := connectTarget{
+		Name:    obj.InstanceName,
+		ID:      obj.InstanceID,
+		Address: obj.PrivateIPv4,
+		User:    sshUser(obj, c.user),
+	}
+	if c.public {
+		t.Address = obj.PublicIPv4
+	}
+
+	// Is there a bastion we should jump via?
+	//
+	// Bastions always have a public address, as findBastions ignores
+	// instances without one.
+	if c.bastion != "" {
+		bastions, err := findBastions(c.results, c.bastion)
+		if err != nil {
+			return t, err
+		}
+		if b, ok := bastions[obj.VPCID]; ok {
+			if b.InstanceID == obj.InstanceID {
+				t.Address = obj.PublicIPv4
+			} else if !c.public {
+				t.Jump = sshUser(b, c.user) + "@" + b.PublicIPv4
+			}
+		}
+	}
+
+	if t.Address == "" {
+		return t, fmt.Errorf("instance %s has no address to connect to", obj.InstanceName)
+	}
+
+	// Only use the identity if it exists.
+	if obj.SSHKeyName != "" && c.identity != "" {
+		path, err := expandHome(strings.ReplaceAll(c.identity, "%s", obj.SSHKeyName))
+		if err != nil {
+			return t, err
+		}
+		if _, err := os.Stat(path); err == nil {
+			t.Identity = path
+		}
+	}
+
+	return t, nil
+}
+
+// Command returns the command to run for the given target, with the
+// extra arguments appended.
+//
+// The values of the target are quoted before the template is executed,
+// and the result is split into arguments using the same quoting rules as
+// the shell, so values containing spaces are preserved.
+func (c *connectCommand) Command(t connectTarget, extra []string) ([]string, error) {
+
+	tmpl, err := template.New("command").Parse(c.command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse command template %s", err)
+	}
+
+	quoted := connectTarget{
+		Name:     shellQuote(t.Name),
+		ID:       shellQuote(t.ID),
+		Address:  shellQuote(t.Address),
+		User:     shellQuote(t.User),
+		Identity: shellQuote(t.Identity),
+		Jump:     shellQuote(t.Jump),
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, quoted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute command template %s", err)
+	}
+
+	args, err := splitCommand(buf.String())
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("the command template produced an empty command")
+	}
+	return append(args, extra...), nil
+}
+
+// shellQuote quotes the given value, if necessary, so that splitCommand
+// will treat it as a single word.
+//
+// Empty values are left alone, so they may be tested in templates.
+func shellQuote(value string) string {
+
+	safe := true
+	for _, r := range value {
+		if !strings.ContainsRune("@%+=:,./_-", r) &&
+			!(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// splitCommand splits the given command into arguments, honouring single
+// and double quotes, and backslash escapes, as the shell does.
+func splitCommand(command string) ([]string, error) {
+
+	ret := []string{}
+
+	var cur strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+
+	for _, r := range command {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '\\':
+			escaped = true
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				ret = append(ret, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in command: %s", command)
+	}
+	if inWord {
+		ret = append(ret, cur.String())
+	}
+	return ret, nil
+}
+
+// Execute is invoked if the user specifies this subcommand.
+func (c *connectCommand) Execute(args []string) int {
+
+	if len(args) < 1 {
+		fmt.Printf("Usage: connect [flags] pattern [-- args]\n")
+		return 1
+	}
+
+	// The pattern, and any arguments for the command.
+	pattern := args[0]
+	extra := args[1:]
+	if len(extra) > 0 && extra[0] == "--" {
+		extra = extra[1:]
+	}
+
+	// Parse the filter-expression and sort-keys
+	err := c.parseSelection()
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	//
+	// Get the connection, using default credentials
+	//
+	session, err := utils.NewSession()
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+
+	//
+	// Now invoke our callback - this will call the function
+	// "CollectInstances" once if we're not running with a role-file,
+	// otherwise once for each role.
+	//
+	errs := utils.HandleRoles(session, c.rolesPath, c.CollectInstances, nil)
+	if len(errs) > 0 {
+		fmt.Printf("errors running connect\n")
+		for _, err := range errs {
+			fmt.Printf("%s\n", err)
+		}
+		return 1
+	}
+
+	// Sort by name by default, so the choices are stable.
+	ret := c.results
+	sortByName(ret)
+
+	ret, err = c.applySelection(ret)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	found, err := c.matchInstances(ret, pattern)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	var obj instances.InstanceOutput
+	switch len(found) {
+	case 0:
+		fmt.Printf("no instance matches %s\n", pattern)
+		return 1
+	case 1:
+		obj = found[0]
+	default:
+		obj, err = c.Choose(found)
+		if err != nil {
+			fmt.Printf("%s\n", err)
+			return 1
+		}
+	}
+
+	t, err := c.Target(obj)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	command, err := c.Command(t, extra)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	// On success this replaces our process, so doesn't return.
+	code, err := execCommand(command)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+	}
+	return code
+}
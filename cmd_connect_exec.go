@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// execCommand replaces our process with the given command, so that
+// signals, the terminal, and the exit status are those of the command.
+//
+// It only returns if the command could not be executed.
+func execCommand(args []string) (int, error) {
+
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return 1, fmt.Errorf("failed to find %s: %s", args[0], err)
+	}
+
+	err = syscall.Exec(path, args, os.Environ())
+	return 1, fmt.Errorf("failed to run %s: %s", args[0], err)
+}
@@ -0,0 +1,32 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// execCommand runs the given command, attached to our terminal, and
+// returns its exit status.
+//
+// Windows cannot replace a running process, so the command is run as a
+// child instead.
+func execCommand(args []string) (int, error) {
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	if exit, ok := err.(*exec.ExitError); ok {
+		return exit.ExitCode(), nil
+	}
+	if err != nil {
+		return 1, fmt.Errorf("failed to run %s: %s", args[0], err)
+	}
+	return 0, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/skx/aws-utils/instances"
+)
+
+// TestSplitCommand tests splitting commands into arguments.
+func TestSplitCommand(t *testing.T) {
+
+	type TestCase struct {
+		Input  string
+		Output []string
+		Error  bool
+	}
+
+	tests := []TestCase{
+		{"", []string{}, false},
+		{"ssh host", []string{"ssh", "host"}, false},
+		{"  ssh \t host  ", []string{"ssh", "host"}, false},
+		{"ssh -i '/my keys/a.pem' host", []string{"ssh", "-i", "/my keys/a.pem", "host"}, false},
+		{`ssh -i "/my keys/a.pem" host`, []string{"ssh", "-i", "/my keys/a.pem", "host"}, false},
+		{`ssh -i /my\ keys/a.pem host`, []string{"ssh", "-i", "/my keys/a.pem", "host"}, false},
+		{`ssh 'ec2-user'@'10.0.0.1'`, []string{"ssh", "ec2-user@10.0.0.1"}, false},
+		{`echo ''`, []string{"echo", ""}, false},
+		{`echo 'it'\''s'`, []string{"echo", "it's"}, false},
+		{`echo "a \"b\""`, []string{"echo", `a "b"`}, false},
+		{"ssh 'host", nil, true},
+		{`ssh "host`, nil, true},
+		{`ssh host\`, nil, true},
+	}
+
+	for _, test := range tests {
+
+		out, err := splitCommand(test.Input)
+		if test.Error {
+			if err == nil {
+				t.Errorf("expected error splitting %s", test.Input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error splitting %s: %s", test.Input, err)
+			continue
+		}
+		if !reflect.DeepEqual(out, test.Output) {
+			t.Errorf("splitting %s: expected %q, got %q", test.Input, test.Output, out)
+		}
+	}
+}
+
+// TestConnectCommand tests the rendering of the command template.
+func TestConnectCommand(t *testing.T) {
+
+	type TestCase struct {
+		Target connectTarget
+		Extra  []string
+		Output []string
+	}
+
+	tests := []TestCase{
+		{connectTarget{User: "ec2-user", Address: "10.0.0.1"}, nil,
+			[]string{"ssh", "ec2-user@10.0.0.1"}},
+		{connectTarget{User: "ubuntu", Address: "10.0.0.1", Identity: "/home/me/My Keys/prod.pem"}, nil,
+			[]string{"ssh", "-i", "/home/me/My Keys/prod.pem", "ubuntu@10.0.0.1"}},
+		{connectTarget{User: "ec2-user", Address: "10.0.0.1", Jump: "ec2-user@3.1.2.3"}, []string{"uptime"},
+			[]string{"ssh", "-J", "ec2-user@3.1.2.3", "ec2-user@10.0.0.1", "uptime"}},
+		{connectTarget{User: "o'brien", Address: "10.0.0.1"}, nil,
+			[]string{"ssh", "o'brien@10.0.0.1"}},
+	}
+
+	c := &connectCommand{command: connectDefaultCommand}
+	for _, test := range tests {
+
+		out, err := c.Command(test.Target, test.Extra)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		if !reflect.DeepEqual(out, test.Output) {
+			t.Errorf("%+v: expected %q, got %q", test.Target, test.Output, out)
+		}
+	}
+
+	// A bogus template
+	c = &connectCommand{command: "ssh {{.Missing"}
+	if _, err := c.Command(connectTarget{}, nil); err == nil {
+		t.Errorf("expected error with a bogus template")
+	}
+}
+
+// TestConnectTarget tests choosing the address, user, and jump-host of
+// instances.
+func TestConnectTarget(t *testing.T) {
+
+	objs := []instances.InstanceOutput{
+		{InstanceID: "i-1", InstanceName: "bastion", VPCID: "vpc-1", PrivateIPv4: "10.0.0.1", PublicIPv4: "3.3.3.3", AMIOwner: "099720109477"},
+		{InstanceID: "i-2", InstanceName: "web", VPCID: "vpc-1", PrivateIPv4: "10.0.0.2"},
+		{InstanceID: "i-3", InstanceName: "bastion-private", VPCID: "vpc-2", PrivateIPv4: "10.1.0.3"},
+		{InstanceID: "i-4", InstanceName: "db", VPCID: "vpc-2", PrivateIPv4: "10.1.0.4"},
+	}
+
+	type TestCase struct {
+		Public bool
+		Obj    int
+		Result connectTarget
+		Error  bool
+	}
+
+	tests := []TestCase{
+		{false, 0, connectTarget{Name: "bastion", ID: "i-1", Address: "3.3.3.3", User: "ubuntu"}, false},
+		{false, 1, connectTarget{Name: "web", ID: "i-2", Address: "10.0.0.2", User: "ec2-user", Jump: "ubuntu@3.3.3.3"}, false},
+
+		// An instance without a public address is never a bastion
+		{false, 3, connectTarget{Name: "db", ID: "i-4", Address: "10.1.0.4", User: "ec2-user"}, false},
+
+		// Public addresses don't need a jump-host
+		{true, 0, connectTarget{Name: "bastion", ID: "i-1", Address: "3.3.3.3", User: "ubuntu"}, false},
+		{true, 1, connectTarget{}, true},
+	}
+
+	c := &connectCommand{user: "ec2-user", bastion: "^bastion"}
+	c.results = objs
+	for _, test := range tests {
+
+		c.public = test.Public
+		out, err := c.Target(objs[test.Obj])
+		if test.Error {
+			if err == nil {
+				t.Errorf("%s: expected error, got none", objs[test.Obj].InstanceName)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", objs[test.Obj].InstanceName, err)
+			continue
+		}
+		if out != test.Result {
+			t.Errorf("%s: expected %+v, got %+v", objs[test.Obj].InstanceName, test.Result, out)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// Estimate the monthly cost of our instances, grouped by account.
+//
+// Primarily written to get a rough idea of spend without needing access
+// to Cost Explorer.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/skx/aws-utils/instances"
+	"github.com/skx/aws-utils/pricing"
+	"github.com/skx/aws-utils/utils"
+)
+
+// accountCost holds the estimated monthly costs of a single account.
+type accountCost struct {
+	Account string  `json:"account"`
+	Running int     `json:"running"`
+	Stopped int     `json:"stopped"`
+	Compute float64 `json:"compute"`
+	Storage float64 `json:"storage"`
+	Total   float64 `json:"total"`
+}
+
+// Structure for our options and state.
+type costSummaryCommand struct {
+
+	// Path to a file containing roles
+	rolesPath string
+
+	// Path to a local price table, if any
+	pricesPath string
+
+	// Should we dump the price table?
+	dumpPrices bool
+
+	// Should we export our results in JSON format?
+	jsonOutput bool
+
+	// The instances we've found, across all accounts
+	instanceCollector
+}
+
+// Arguments adds per-command args to the object.
+func (c *costSummaryCommand) Arguments(f *flag.FlagSet) {
+	f.StringVar(&c.rolesPath, "roles", "", "Path to a list of roles to process, one by one")
+	f.StringVar(&c.pricesPath, "prices", "", "Path to a JSON price table, updating the embedded prices")
+	f.BoolVar(&c.dumpPrices, "dump-prices", false, "Output the price table, including any updates from -prices, and terminate")
+	f.BoolVar(&c.jsonOutput, "json", false, "Output the results in JSON.")
+}
+
+// Info returns the name of this subcommand.
+func (c *costSummaryCommand) Info() (string, string) {
+	return "cost-summary", `Estimate the monthly cost of instances, by account.
+
+Details:
+
+This command estimates the monthly on-demand cost of the instances in
+each account, from their instance types and the types and sizes of their
+volumes:
+
+    $ aws-utils cost-summary -roles=./roles
+    Account       Running  Stopped  Compute/month  Storage/month  Total/month
+    123456789012       12        3        1234.56         120.00      1354.56
+    210987654321        4        0         280.32          40.00       320.32
+    Total              16        3        1514.88         160.00      1674.88
+
+Stopped instances only incur the cost of their volumes.  The estimate
+ignores reserved instances, savings plans, provisioned IOPS, data
+transfer, and similar, so it should be treated as a rough guide only.
+
+Prices are taken from a table embedded within this binary, which will
+become outdated over time.  You may view the table with '-dump-prices',
+and add or update prices with '-prices', which accepts a JSON file in
+the same format:
+
+    {
+      "region": "eu-west-1",
+      "instances": { "m5.large": 0.107 },
+      "volumes": { "gp3": 0.088 }
+    }
+
+Instance prices are hourly, and volume prices are per GiB per month.  Any
+instance or volume types without a known price are reported, and are not
+included in the totals.
+`
+
+}
+
+// Summarize returns the estimated costs of each account, sorted by
+// account, along with a count of the instance and volume types which
+// have no known price.
+func (c *costSummaryCommand) Summarize(objs []instances.InstanceOutput) ([]accountCost, map[string]int) {
+
+	lookup := make(map[string]*accountCost)
+	unpriced := make(map[string]int)
+
+	for _, obj := range objs {
+
+		ent, ok := lookup[obj.AWSAccount]
+		if !ok {
+			ent = &accountCost{Account: obj.AWSAccount}
+			lookup[obj.AWSAccount] = ent
+		}
+
+		// Stopped instances only cost us their storage.
+		if obj.InstanceState == "stopped" || obj.InstanceState == "stopping" {
+			ent.Stopped++
+		} else {
+			ent.Running++
+
+			cost, known := pricing.Prices.Instance(obj.InstanceType)
+			if !known {
+				unpriced["instance type "+obj.InstanceType]++
+			}
+			ent.Compute += cost
+		}
+
+		for _, vol := range obj.Volumes {
+			size, _ := strconv.Atoi(vol.Size)
+			cost, known := pricing.Prices.Volume(vol.Type, size)
+			if !known {
+				unpriced["volume type "+vol.Type]++
+			}
+			ent.Storage += cost
+		}
+	}
+
+	ret := []accountCost{}
+	for _, ent := range lookup {
+		ent.Compute = pricing.Round(ent.Compute)
+		ent.Storage = pricing.Round(ent.Storage)
+		ent.Total = pricing.Round(ent.Compute + ent.Storage)
+		ret = append(ret, *ent)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Account < ret[j].Account
+	})
+	return ret, unpriced
+}
+
+// Report outputs the estimated costs of each account, and a total.
+func (c *costSummaryCommand) Report(costs []accountCost, unpriced map[string]int) error {
+
+	// Sort the unknown types, for reproducible output
+	missing := []string{}
+	for key, count := range unpriced {
+		missing = append(missing, fmt.Sprintf("%s (%d)", key, count))
+	}
+	sort.Strings(missing)
+
+	if c.jsonOutput {
+		out := struct {
+			Region   string        `json:"region"`
+			Currency string        `json:"currency"`
+			Accounts []accountCost `json:"accounts"`
+			Unpriced []string      `json:"unpriced,omitempty"`
+		}{pricing.Prices.Region, pricing.Prices.Currency, costs, missing}
+
+		b, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error exporting to JSON %s", err)
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	total := accountCost{Account: "Total"}
+
+	fmt.Printf("%-13s %7s  %7s  %13s  %13s  %11s\n", "Account", "Running", "Stopped", "Compute/month", "Storage/month", "Total/month")
+	for _, ent := range costs {
+		fmt.Printf("%-13s %7d  %7d  %13.2f  %13.2f  %11.2f\n", ent.Account, ent.Running, ent.Stopped, ent.Compute, ent.Storage, ent.Total)
+
+		total.Running += ent.Running
+		total.Stopped += ent.Stopped
+		total.Compute += ent.Compute
+		total.Storage += ent.Storage
+		total.Total += ent.Total
+	}
+	fmt.Printf("%-13s %7d  %7d  %13.2f  %13.2f  %11.2f\n", total.Account, total.Running, total.Stopped, total.Compute, total.Storage, total.Total)
+
+	fmt.Printf("\nEstimated from %s on-demand prices, in %s.\n", pricing.Prices.Region, pricing.Prices.Currency)
+	if len(missing) > 0 {
+		fmt.Printf("No price is known for the following, they are excluded from the totals:\n")
+		fmt.Printf("  %s\n", strings.Join(missing, "\n  "))
+	}
+	return nil
+}
+
+// Execute is invoked if the user specifies this subcommand.
+func (c *costSummaryCommand) Execute(args []string) int {
+
+	// Update the embedded prices, if we were given a price table
+	if err := pricing.Load(c.pricesPath); err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	// Just showing the prices?
+	if c.dumpPrices {
+		b, err := json.MarshalIndent(pricing.Prices, "", "  ")
+		if err != nil {
+			fmt.Printf("error exporting to JSON %s\n", err)
+			return 1
+		}
+		fmt.Println(string(b))
+		return 0
+	}
+
+	//
+	// Get the connection, using default credentials
+	//
+	session, err := utils.NewSession()
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+
+	// Include stopped instances, as their volumes still cost money.
+	c.states = []string{"pending", "running", "stopping", "stopped"}
+
+	//
+	// Now invoke our callback - this will call the function
+	// "CollectInstances" once if we're not running with a role-file,
+	// otherwise once for each role.
+	//
+	errs := utils.HandleRoles(session, c.rolesPath, c.CollectInstances, nil)
+
+	err = c.Report(c.Summarize(c.results))
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		fmt.Printf("errors running cost-summary\n")
+		for _, err := range errs {
+			fmt.Printf("%s\n", err)
+		}
+		return 1
+	}
+
+	return 0
+}
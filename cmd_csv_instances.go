@@ -5,18 +5,16 @@
 package main
 
 import (
-	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"regexp"
 	"strings"
 
 	"github.com/skx/aws-utils/instances"
-	"github.com/skx/aws-utils/tag2name"
+	"github.com/skx/aws-utils/pricing"
+	"github.com/skx/aws-utils/tags"
 	"github.com/skx/aws-utils/utils"
-
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
 // Structure for our options and state.
@@ -25,6 +23,12 @@ type csvInstancesCommand struct {
 	// Path to a file containing roles
 	rolesPath string
 
+	// Tags to examine for the name of each resource
+	nameTags string
+
+	// Path to a local price table, if any
+	pricesPath string
+
 	// Have we shown the CSV header?
 	header bool
 
@@ -33,13 +37,40 @@ type csvInstancesCommand struct {
 
 	// Filter to show only matching lines
 	filter string
+
+	// Should we export our results in JSON format?
+	jsonOutput bool
+
+	// The fields to output, parsed from the format string
+	fields []instances.Field
+
+	// Fields to aggregate instances by, if any
+	groupBy string
+
+	// The fields parsed from the group-by string
+	groups []instances.Field
+
+	// Output one row per volume, rather than per instance?
+	perVolume bool
+
+	// Filtering and sorting options
+	instanceSelection
+
+	// The instances we've found, across all accounts
+	instanceCollector
 }
 
 // Arguments adds per-command args to the object.
 func (c *csvInstancesCommand) Arguments(f *flag.FlagSet) {
 	f.StringVar(&c.rolesPath, "roles", "", "Path to a list of roles to process, one by one")
+	f.StringVar(&c.nameTags, "name-tags", "Name", "The tags to examine, in order, for the name of each resource, e.g. 'Name,aws:cloudformation:stack-name'")
+	f.StringVar(&c.pricesPath, "prices", "", "Path to a JSON price table, updating the embedded prices used for the 'cost' field")
 	f.StringVar(&c.format, "format", "", "Format string of the fields to print")
 	f.StringVar(&c.filter, "filter", "", "Only show lines matching this regular expression")
+	f.BoolVar(&c.jsonOutput, "json", false, "Output the results in JSON.")
+	f.StringVar(&c.groupBy, "group-by", "", "Aggregate instances by these fields, showing one row per group")
+	f.BoolVar(&c.perVolume, "per-volume", false, "Output one row per attached volume, rather than one per instance")
+	c.selectionArguments(f)
 }
 
 // Info returns the name of this subcommand.
@@ -61,276 +92,213 @@ By default the export contains the following fields:
 You can specify a different output via the 'format' argument, for
 example:
 
-     aws-utils csv-instances --format="account,id,name,ipv4address"
+     aws-utils csv-instances --format="account,id,name,privateipv4"
 
 Valid fields are
 
-* "account" - The AWS account-number.
-* "az" - The availability zone within which the instance is running.
-* "ami" - The AMI name of the running instance.
-* "amiage" - The age of the AMI in days.
-* "id" - The instance ID.
-* "name" - The instance name, as set via tags.
-* "privateipv4" - The (private) IPv4 address associated with the instance.
-* "publicipv4" - The (public) IPv4 address associated with the instance.
-* "ssh-key" - The SSH key setup for this instance.
-* "state" - The instance state (running, pending, etc).
-* "subnet" - The name of the subnet within which the instance is running.
-* "subnetid" - The ID of the subnet within which the instance is running.
-* "type" - The instance type (t2.small, t3.large, etc).
-* "vpc" - The name of the VPC within which the instance is running.
-* "vpcid" - The ID of the VPC within which the instance is running.
-`
+` + instances.FieldHelp() + `
+Instances are named by their "Name" tag, falling back to their ID.  You
+may examine other tags, in order, via '-name-tags':
 
-}
+     aws-utils csv-instances -name-tags=Name,aws:cloudformation:stack-name
 
-// DumpCSV outputs the list of running instances.
-func (c *csvInstancesCommand) DumpCSV(svc *ec2.EC2, acct string, void interface{}) error {
+The "cost" field is a rough estimate of the monthly on-demand cost of
+each instance, and its volumes, from a table of prices embedded within
+this binary.  These prices may be updated via '-prices', which accepts a
+JSON file in the format shown by 'aws-utils cost-summary -dump-prices'.
+The field is empty if the instance type, or the type of any volume, has
+no known price, and such instances sort before all others.
 
-	// Get the running instances.
-	ret, err := instances.GetInstances(svc, acct)
-	if err != nil {
-		return err
-	}
+If you'd prefer JSON output add '-json', each instance will then be output
+as a JSON object containing the selected fields.
 
-	// Get the format-string
-	format := c.format
-	if format == "" {
-		format = "account,id,name,ami"
-	}
+Rather than showing one row per instance you may aggregate the instances
+by one or more fields, via '-group-by':
 
-	// Map of subnet names to IDs.
-	var subnets map[string]string
-	fetchSubnets := false
+     aws-utils csv-instances -group-by=account,type
 
-	// Map of VPC names to IDs
-	var vpcs map[string]string
-	fetchVPCs := false
+Each row will then contain the values of the grouped fields, followed by
+the number of instances, the total number of vCPUs, the total size of all
+volumes (in GiB), and the minimum and maximum AMI ages.  '-json' may be
+used with '-group-by' too.
 
-	// Split the fields, by comma
-	supplied := strings.Split(format, ",")
+Rather than showing one row per instance you may show one row for each
+attached volume, via '-per-volume':
 
-	// Ensure all fields are lower-cased and stripped of spaces
-	fields := []string{}
-	for _, field := range supplied {
-		field = strings.TrimSpace(field)
-		field = strings.ToLower(field)
-		fields = append(fields, field)
+     aws-utils csv-instances -per-volume -format=name,vol-id,vol-size
 
-		// Do we need to fetch subnet information?
-		if field == "subnet" {
-			fetchSubnets = true
-		}
-		if field == "vpc" {
-			fetchVPCs = true
-		}
-	}
+In this mode the following fields are also available, and the default
+format is "account,id,name,vol-device,vol-id,vol-size,vol-type":
 
-	// Fetch the subnets within the account, if we're going
-	// to display the human-readable name.
-	if fetchSubnets {
-
-		// An empty filter, to get all subnets
-		input := &ec2.DescribeSubnetsInput{
-			Filters: []*ec2.Filter{
-				{},
-			},
-		}
-
-		// describe the subnets
-		result, err := svc.DescribeSubnets(input)
-		if err != nil {
-			if aerr, ok := err.(awserr.Error); ok {
-				switch aerr.Code() {
-				default:
-					fmt.Println(aerr.Error())
-				}
-			} else {
-				// Print the error, cast err to awserr.Error to get the Code and
-				// Message from an error.
-				fmt.Println(err.Error())
-			}
-			return fmt.Errorf("failed to get subnets for account %s", acct)
-		}
+` + instances.VolumeFieldHelp() + selectionHelp
 
-		// fill up our map
-		subnets = make(map[string]string)
+}
 
-		// populate it with "id -> name"
-		for i := range result.Subnets {
-			// Get the name, via tags, if present
-			name := tag2name.Lookup(result.Subnets[i].Tags, "unnamed")
-			subnets[*result.Subnets[i].SubnetId] = name
-		}
-	}
+// DumpCSV outputs the list of running instances.
+func (c *csvInstancesCommand) DumpCSV(ret []instances.InstanceOutput) error {
 
-	// Fetch the VPCs within the account, if we're going
-	// to display the human-readable name.
-	if fetchVPCs {
+	// For each instance we've discovered
+	for _, obj := range ret {
 
-		// An empty filter, to get all subnets
-		input := &ec2.DescribeVpcsInput{
-			Filters: []*ec2.Filter{
-				{},
-			},
-		}
+		// JSON output is simple, there's no header.
+		if c.jsonOutput {
 
-		// describe the vpcs
-		result, err := svc.DescribeVpcs(input)
-		if err != nil {
-			if aerr, ok := err.(awserr.Error); ok {
-				switch aerr.Code() {
-				default:
-					fmt.Println(aerr.Error())
-				}
-			} else {
-				// Print the error, cast err to awserr.Error to get the Code and
-				// Message from an error.
-				fmt.Println(err.Error())
+			b, err := json.Marshal(instances.Record(obj, c.fields))
+			if err != nil {
+				return fmt.Errorf("error exporting to JSON %s", err)
 			}
-			return fmt.Errorf("failed to get VPCs for account %s", acct)
-		}
 
-		// fill up our map
-		vpcs = make(map[string]string)
-
-		// populate it with "id -> name"
-		for i := range result.Vpcs {
-			// Get the name, via tags, if present
-			name := tag2name.Lookup(result.Vpcs[i].Tags, "unnamed")
-			vpcs[*result.Vpcs[i].VpcId] = name
+			// Should we filter this record out?
+			show, er := c.matches(string(b))
+			if er != nil {
+				return er
+			}
+			if show {
+				fmt.Println(string(b))
+			}
+			continue
 		}
-	}
-
-	// For each instance we've discovered
-	for _, obj := range ret {
 
 		// If we've not printed the header..
 		if !c.header {
 
 			// Show something human-readable
-			for i, field := range fields {
-
-				switch field {
-				case "account":
-					fmt.Printf("Account ID")
-				case "ami":
-					fmt.Printf("AMI ID")
-				case "amiage":
-					fmt.Printf("AMI Age")
-				case "az":
-					fmt.Printf("Availability Zone")
-				case "id":
-					fmt.Printf("Instance ID")
-				case "name":
-					fmt.Printf("Name")
-				case "privateipv4":
-					fmt.Printf("PrivateIPv4")
-				case "publicipv4":
-					fmt.Printf("PublicIPv4")
-				case "ssh-key":
-					fmt.Printf("SSH Key")
-				case "state":
-					fmt.Printf("Instance State")
-				case "subnet":
-					fmt.Printf("Subnet")
-				case "subnetid":
-					fmt.Printf("Subnet ID")
-				case "type":
-					fmt.Printf("Instance Type")
-				case "vpc":
-					fmt.Printf("VPC")
-				case "vpcid":
-					fmt.Printf("VPC ID")
-				default:
-					fmt.Printf("unknown field:%s", field)
-				}
-
-				// if this isn't the last one, add ","
-				if i < len(fields)-1 {
-					fmt.Printf(",")
-				}
-
+			headers := []string{}
+			for _, field := range c.fields {
+				headers = append(headers, field.Header)
 			}
-
-			// Terminate the header with a newline
-			fmt.Printf("\n")
+			fmt.Printf("%s\n", strings.Join(headers, ","))
 			c.header = true
 		}
 
-		// Buffer for this line of output
-		var line bytes.Buffer
-
-		// Show each field
-		for i, field := range fields {
-
-			switch field {
-			case "account":
-				line.WriteString(acct)
-			case "ami":
-				line.WriteString(obj.InstanceAMI)
-			case "amiage":
-				line.WriteString(fmt.Sprintf("%d", obj.AMIAge))
-			case "az":
-				line.WriteString(obj.AvailabilityZone)
-			case "id":
-				line.WriteString(obj.InstanceID)
-			case "name":
-				line.WriteString(obj.InstanceName)
-			case "privateipv4":
-				line.WriteString(obj.PrivateIPv4)
-			case "publicipv4":
-				line.WriteString(obj.PublicIPv4)
-			case "ssh-key":
-				line.WriteString(obj.SSHKeyName)
-			case "state":
-				line.WriteString(obj.InstanceState)
-			case "subnet":
-				line.WriteString(subnets[obj.SubnetID])
-			case "subnetid":
-				line.WriteString(obj.SubnetID)
-			case "type":
-				line.WriteString(obj.InstanceType)
-			case "vpc":
-				line.WriteString(vpcs[obj.VPCID])
-			case "vpcid":
-				line.WriteString(obj.VPCID)
-			default:
-				fmt.Printf("unknown field:%s", field)
-			}
-
-			// if this isn't the last one, add ","
-			if i < len(fields)-1 {
-				line.WriteString(",")
-			}
+		// Build up this line of output
+		values := []string{}
+		for _, field := range c.fields {
+			values = append(values, field.String(obj))
 		}
-
-		show := true
+		line := strings.Join(values, ",")
 
 		// Should we filter this line out?
-		if c.filter != "" {
-			// If it doesn't match then skip it.
-			match, er := regexp.MatchString(c.filter, line.String())
-			if er != nil {
-				return fmt.Errorf("error running regexp match of %s against %s: %s", c.filter, line.String(), er)
-			}
-			if !match {
-				show = false
-			}
+		show, er := c.matches(line)
+		if er != nil {
+			return er
 		}
 
 		// Newline between records
 		if show {
-			fmt.Printf("%s\n", line.String())
+			fmt.Printf("%s\n", line)
 		}
 
 	}
 	return nil
 }
 
+// DumpGroups outputs the aggregated details of the given instances.
+func (c *csvInstancesCommand) DumpGroups(ret []instances.InstanceOutput) error {
+
+	// Header for CSV output
+	if !c.jsonOutput {
+		headers := []string{}
+		for _, field := range c.groups {
+			headers = append(headers, field.Header)
+		}
+		headers = append(headers, "Count", "vCPUs", "EBS GiB", "Min AMI Age", "Max AMI Age")
+		fmt.Printf("%s\n", strings.Join(headers, ","))
+	}
+
+	for _, group := range instances.GroupBy(ret, c.groups) {
+
+		if c.jsonOutput {
+			b, err := json.Marshal(group.Record(c.groups))
+			if err != nil {
+				return fmt.Errorf("error exporting to JSON %s", err)
+			}
+			fmt.Println(string(b))
+			continue
+		}
+
+		values := append([]string{}, group.Values...)
+		values = append(values,
+			fmt.Sprintf("%d", group.Count),
+			fmt.Sprintf("%d", group.VCPUs),
+			fmt.Sprintf("%d", group.EBSGiB),
+			fmt.Sprintf("%d", group.MinAMIAge),
+			fmt.Sprintf("%d", group.MaxAMIAge))
+		fmt.Printf("%s\n", strings.Join(values, ","))
+	}
+	return nil
+}
+
+// matches returns true if the given line of output matches our filter,
+// or if there is no filter.
+func (c *csvInstancesCommand) matches(line string) (bool, error) {
+
+	if c.filter == "" {
+		return true, nil
+	}
+
+	// If it doesn't match then skip it.
+	match, er := regexp.MatchString(c.filter, line)
+	if er != nil {
+		return false, fmt.Errorf("error running regexp match of %s against %s: %s", c.filter, line, er)
+	}
+	return match, nil
+}
+
 // Execute is invoked if the user specifies this subcommand.
 func (c *csvInstancesCommand) Execute(args []string) int {
 
+	// Use the tags we were given to find names
+	tags.SetNameChain(c.nameTags)
+
+	// Update the embedded prices, if we were given a price table
+	if err := pricing.Load(c.pricesPath); err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	//
+	// Get the format-string, and ensure all the fields are valid
+	// before we go any further.
+	//
+	format := c.format
+	if format == "" {
+		format = "account,id,name,ami"
+		if c.perVolume {
+			format = "account,id,name,vol-device,vol-id,vol-size,vol-type"
+		}
+	}
+
+	var err error
+	if c.perVolume {
+		c.fields, err = instances.ParseVolumeFields(format)
+	} else {
+		c.fields, err = instances.ParseFields(format)
+	}
+	if err != nil {
+		fmt.Printf("invalid format: %s\n", err)
+		return 1
+	}
+
+	if c.perVolume && c.groupBy != "" {
+		fmt.Printf("-per-volume and -group-by are mutually exclusive\n")
+		return 1
+	}
+
+	if c.groupBy != "" {
+		c.groups, err = instances.ParseFields(c.groupBy)
+		if err != nil {
+			fmt.Printf("invalid group-by: %s\n", err)
+			return 1
+		}
+	}
+
+	err = c.parseSelection()
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
 	//
 	// Get the connection, using default credentials
 	//
@@ -342,10 +310,28 @@ func (c *csvInstancesCommand) Execute(args []string) int {
 
 	//
 	// Now invoke our callback - this will call the function
-	// "DumpCSV" once if we're not running with a role-file,
+	// "CollectInstances" once if we're not running with a role-file,
 	// otherwise once for each role.
 	//
-	errs := utils.HandleRoles(session, c.rolesPath, c.DumpCSV, nil)
+	errs := utils.HandleRoles(session, c.rolesPath, c.CollectInstances, nil)
+
+	//
+	// Filter, sort, and output what we found.
+	//
+	ret, err := c.applySelection(c.results)
+	if err == nil {
+		if len(c.groups) > 0 {
+			err = c.DumpGroups(ret)
+		} else if c.perVolume {
+			err = c.DumpCSV(instances.PerVolume(ret))
+		} else {
+			err = c.DumpCSV(ret)
+		}
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+
 	if len(errs) > 0 {
 		fmt.Printf("errors running CSV-Dump\n")
 		for _, err := range errs {
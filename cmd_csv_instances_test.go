@@ -0,0 +1,28 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/skx/aws-utils/instances"
+)
+
+// TestCSVHelpFormats tests that the formats used in our help-text are
+// valid.
+func TestCSVHelpFormats(t *testing.T) {
+
+	c := &csvInstancesCommand{}
+	_, help := c.Info()
+
+	re := regexp.MustCompile(`-format="?([a-z0-9,-]+)`)
+	found := re.FindAllStringSubmatch(help, -1)
+	if len(found) == 0 {
+		t.Fatalf("no formats found in help-text")
+	}
+
+	for _, m := range found {
+		if _, err := instances.ParseVolumeFields(m[1]); err != nil {
+			t.Errorf("invalid format %s in help-text: %s", m[1], err)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+// Export our inventory to a SQLite database.
+//
+// Primarily written to allow ad-hoc questions to be answered via SQL.
+
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/skx/aws-utils/instances"
+	"github.com/skx/aws-utils/tags"
+	"github.com/skx/aws-utils/utils"
+
+	// Pure-go SQLite driver, which avoids the need for cgo.
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema contains the tables we create.
+//
+// Existing tables are dropped, so every export starts afresh.
+var sqliteSchema = []string{
+	`DROP TABLE IF EXISTS instances`,
+	`CREATE TABLE instances (
+		account TEXT, instance_id TEXT, name TEXT, type TEXT, state TEXT,
+		ami TEXT, ami_age INTEGER, az TEXT, vpc_id TEXT, subnet_id TEXT,
+		private_ipv4 TEXT, public_ipv4 TEXT, ssh_key TEXT, launch_time TEXT,
+		platform TEXT, architecture TEXT, lifecycle TEXT, vcpus INTEGER,
+		PRIMARY KEY (account, instance_id))`,
+
+	`DROP TABLE IF EXISTS instance_security_groups`,
+	`CREATE TABLE instance_security_groups (
+		account TEXT, instance_id TEXT, group_id TEXT)`,
+
+	`DROP TABLE IF EXISTS volumes`,
+	`CREATE TABLE volumes (
+		account TEXT, volume_id TEXT, instance_id TEXT, device TEXT,
+		size_gib INTEGER, type TEXT, iops INTEGER, throughput INTEGER,
+		encrypted TEXT, kms_key_id TEXT, snapshot_id TEXT, state TEXT,
+		delete_on_termination TEXT)`,
+
+	`DROP TABLE IF EXISTS tags`,
+	`CREATE TABLE tags (
+		account TEXT, resource_type TEXT, resource_id TEXT, key TEXT, value TEXT)`,
+
+	`DROP TABLE IF EXISTS vpcs`,
+	`CREATE TABLE vpcs (
+		account TEXT, vpc_id TEXT, name TEXT, cidr TEXT, is_default TEXT,
+		PRIMARY KEY (account, vpc_id))`,
+
+	`DROP TABLE IF EXISTS subnets`,
+	`CREATE TABLE subnets (
+		account TEXT, subnet_id TEXT, vpc_id TEXT, name TEXT, cidr TEXT, az TEXT,
+		PRIMARY KEY (account, subnet_id))`,
+
+	`DROP TABLE IF EXISTS security_groups`,
+	`CREATE TABLE security_groups (
+		account TEXT, group_id TEXT, name TEXT, description TEXT, vpc_id TEXT,
+		PRIMARY KEY (account, group_id))`,
+
+	`DROP TABLE IF EXISTS security_group_rules`,
+	`CREATE TABLE security_group_rules (
+		account TEXT, group_id TEXT, direction TEXT, protocol TEXT,
+		from_port INTEGER, to_port INTEGER, source TEXT, description TEXT)`,
+
+	`DROP TABLE IF EXISTS stacks`,
+	`CREATE TABLE stacks (
+		account TEXT, name TEXT, status TEXT, created TEXT)`,
+}
+
+// Structure for our options and state.
+type exportSQLiteCommand struct {
+
+	// Path to a file containing roles
+	rolesPath string
+
+	// Path to the database to create
+	dbPath string
+
+	// The accounts we've already exported
+	exported map[string]bool
+}
+
+// Arguments adds per-command args to the object.
+func (e *exportSQLiteCommand) Arguments(f *flag.FlagSet) {
+	f.StringVar(&e.rolesPath, "roles", "", "Path to a list of roles to process, one by one")
+	f.StringVar(&e.dbPath, "db", "inventory.db", "Path to the SQLite database to write")
+}
+
+// Info returns the name of this subcommand.
+func (e *exportSQLiteCommand) Info() (string, string) {
+	return "export-sqlite", `Export our inventory to a SQLite database.
+
+Details:
+
+This command collects details of the instances (excluding those which
+have been terminated), volumes, tags, VPCs,
+subnets, security-groups (and their rules), and cloudformation stacks
+within each account, and writes them to a SQLite database.
+
+This allows ad-hoc questions to be answered via SQL, for example:
+
+    $ aws-utils export-sqlite -roles=./roles -db=inventory.db
+    $ sqlite3 inventory.db
+    sqlite> SELECT account, type, COUNT(*) FROM instances GROUP BY account, type;
+
+The following tables are created, replacing any existing ones:
+
+* instances
+* instance_security_groups
+* volumes
+* tags
+* vpcs
+* subnets
+* security_groups
+* security_group_rules
+* stacks
+
+Every table contains an "account" column, and the tags table contains a
+"resource_type" column ("instance", "volume", "vpc", "subnet", or
+"security-group") along with the "resource_id".
+`
+
+}
+
+// Export is our callback method, which is invoked once for our main
+// account - if no roles-file is specified - or once for each assumed
+// role within that file.
+//
+// Everything found in the account is written within a single transaction.
+func (e *exportSQLiteCommand) Export(svc *ec2.EC2, account string, void interface{}) error {
+
+	db := void.(*sql.DB)
+
+	// The same account may be listed more than once in the role-file,
+	// via different roles, but there's no need to export it twice.
+	if e.exported[account] {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %s", err)
+	}
+
+	err = e.exportAccount(tx, svc, account)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %s", err)
+	}
+
+	e.exported[account] = true
+	return nil
+}
+
+// exportAccount writes the details of a single account.
+func (e *exportSQLiteCommand) exportAccount(tx *sql.Tx, svc *ec2.EC2, account string) error {
+
+	// Instances, and their volumes, including those which are stopped
+	objs, err := instances.GetInstancesByState(svc, account, "pending", "running", "stopping", "stopped")
+	if err != nil {
+		return err
+	}
+	for _, obj := range objs {
+
+		_, err = tx.Exec(`INSERT INTO instances VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
+			account, obj.InstanceID, obj.InstanceName, obj.InstanceType, obj.InstanceState,
+			obj.InstanceAMI, obj.AMIAge, obj.AvailabilityZone, obj.VPCID, obj.SubnetID,
+			obj.PrivateIPv4, obj.PublicIPv4, obj.SSHKeyName, obj.LaunchTime.Format(time.RFC3339),
+			obj.Platform, obj.Architecture, obj.Lifecycle, obj.VCPUs)
+		if err != nil {
+			return fmt.Errorf("failed to insert instance %s: %s", obj.InstanceID, err)
+		}
+
+		for _, sg := range obj.SecurityGroups {
+			_, err = tx.Exec(`INSERT INTO instance_security_groups VALUES (?,?,?)`,
+				account, obj.InstanceID, sg.ID)
+			if err != nil {
+				return fmt.Errorf("failed to insert security-group of %s: %s", obj.InstanceID, err)
+			}
+		}
+
+		for _, vol := range obj.Volumes {
+			_, err = tx.Exec(`INSERT INTO volumes VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)`,
+				account, vol.ID, obj.InstanceID, vol.Device, vol.Size, vol.Type, vol.IOPS,
+				vol.Throughput, vol.Encrypted, vol.KMSKeyID, vol.SnapshotID, vol.State,
+				vol.DeleteOnTermination)
+			if err != nil {
+				return fmt.Errorf("failed to insert volume %s: %s", vol.ID, err)
+			}
+
+			err = insertTags(tx, account, "volume", vol.ID, vol.Tags)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = insertTags(tx, account, "instance", obj.InstanceID, obj.Tags)
+		if err != nil {
+			return err
+		}
+	}
+
+	// VPCs
+	vpcs := []*ec2.Vpc{}
+	err = svc.DescribeVpcsPages(&ec2.DescribeVpcsInput{}, func(page *ec2.DescribeVpcsOutput, lastPage bool) bool {
+		vpcs = append(vpcs, page.Vpcs...)
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get VPCs for account %s: %s", account, err)
+	}
+	for _, vpc := range vpcs {
+		_, err = tx.Exec(`INSERT INTO vpcs VALUES (?,?,?,?,?)`,
+			account, aws.StringValue(vpc.VpcId), tags.Name(tags.FromEC2(vpc.Tags), aws.StringValue(vpc.VpcId)),
+			aws.StringValue(vpc.CidrBlock), fmt.Sprintf("%t", aws.BoolValue(vpc.IsDefault)))
+		if err != nil {
+			return fmt.Errorf("failed to insert VPC %s: %s", aws.StringValue(vpc.VpcId), err)
+		}
+		err = insertEC2Tags(tx, account, "vpc", aws.StringValue(vpc.VpcId), vpc.Tags)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Subnets
+	subnets, err := describeSubnets(svc)
+	if err != nil {
+		return fmt.Errorf("failed to get subnets for account %s: %s", account, err)
+	}
+	for _, subnet := range subnets {
+		_, err = tx.Exec(`INSERT INTO subnets VALUES (?,?,?,?,?,?)`,
+			account, aws.StringValue(subnet.SubnetId), aws.StringValue(subnet.VpcId),
+			tags.Name(tags.FromEC2(subnet.Tags), aws.StringValue(subnet.SubnetId)), aws.StringValue(subnet.CidrBlock),
+			aws.StringValue(subnet.AvailabilityZone))
+		if err != nil {
+			return fmt.Errorf("failed to insert subnet %s: %s", aws.StringValue(subnet.SubnetId), err)
+		}
+		err = insertEC2Tags(tx, account, "subnet", aws.StringValue(subnet.SubnetId), subnet.Tags)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Security-groups, and their rules
+	groups, err := describeSecurityGroups(svc)
+	if err != nil {
+		return fmt.Errorf("unable to get security-groups %s", err)
+	}
+	for _, group := range groups {
+		id := aws.StringValue(group.GroupId)
+
+		_, err = tx.Exec(`INSERT INTO security_groups VALUES (?,?,?,?,?)`,
+			account, id, aws.StringValue(group.GroupName),
+			aws.StringValue(group.Description), aws.StringValue(group.VpcId))
+		if err != nil {
+			return fmt.Errorf("failed to insert security-group %s: %s", id, err)
+		}
+
+		err = insertRules(tx, account, id, "ingress", group.IpPermissions)
+		if err != nil {
+			return err
+		}
+		err = insertRules(tx, account, id, "egress", group.IpPermissionsEgress)
+		if err != nil {
+			return err
+		}
+		err = insertEC2Tags(tx, account, "security-group", id, group.Tags)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Stacks
+	stacks, err := listStacks(cloudformationClient(svc))
+	if err != nil {
+		return fmt.Errorf("failed to list stacks for account %s: %s", account, err)
+	}
+	for _, stack := range stacks {
+		created := ""
+		if stack.CreationTime != nil {
+			created = stack.CreationTime.Format(time.RFC3339)
+		}
+		_, err = tx.Exec(`INSERT INTO stacks VALUES (?,?,?,?)`,
+			account, aws.StringValue(stack.StackName), aws.StringValue(stack.StackStatus), created)
+		if err != nil {
+			return fmt.Errorf("failed to insert stack %s: %s", aws.StringValue(stack.StackName), err)
+		}
+	}
+
+	return nil
+}
+
+// insertRules writes a row for each source of each of the given rules.
+func insertRules(tx *sql.Tx, account string, group string, direction string, perms []*ec2.IpPermission) error {
+
+	for _, perm := range perms {
+
+		// Each rule may have many sources, collect them with their
+		// descriptions.
+		type source struct {
+			name string
+			desc *string
+		}
+		sources := []source{}
+		for _, r := range perm.IpRanges {
+			sources = append(sources, source{aws.StringValue(r.CidrIp), r.Description})
+		}
+		for _, r := range perm.Ipv6Ranges {
+			sources = append(sources, source{aws.StringValue(r.CidrIpv6), r.Description})
+		}
+		for _, r := range perm.UserIdGroupPairs {
+			sources = append(sources, source{aws.StringValue(r.GroupId), r.Description})
+		}
+		for _, r := range perm.PrefixListIds {
+			sources = append(sources, source{aws.StringValue(r.PrefixListId), r.Description})
+		}
+
+		for _, src := range sources {
+			_, err := tx.Exec(`INSERT INTO security_group_rules VALUES (?,?,?,?,?,?,?,?)`,
+				account, group, direction, aws.StringValue(perm.IpProtocol),
+				aws.Int64Value(perm.FromPort), aws.Int64Value(perm.ToPort),
+				src.name, aws.StringValue(src.desc))
+			if err != nil {
+				return fmt.Errorf("failed to insert rule for %s: %s", group, err)
+			}
+		}
+	}
+	return nil
+}
+
+// insertTags writes the given tags of a resource.
+func insertTags(tx *sql.Tx, account string, kind string, id string, tags map[string]string) error {
+
+	for key, value := range tags {
+		_, err := tx.Exec(`INSERT INTO tags VALUES (?,?,?,?,?)`, account, kind, id, key, value)
+		if err != nil {
+			return fmt.Errorf("failed to insert tag %s of %s: %s", key, id, err)
+		}
+	}
+	return nil
+}
+
+// insertEC2Tags writes the given EC2 tags of a resource.
+func insertEC2Tags(tx *sql.Tx, account string, kind string, id string, tags []*ec2.Tag) error {
+
+	m := make(map[string]string)
+	for _, tag := range tags {
+		m[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return insertTags(tx, account, kind, id, m)
+}
+
+// Execute is invoked if the user specifies this subcommand.
+func (e *exportSQLiteCommand) Execute(args []string) int {
+
+	//
+	// Open the database, and create our tables
+	//
+	db, err := sql.Open("sqlite", e.dbPath)
+	if err != nil {
+		fmt.Printf("failed to open %s: %s\n", e.dbPath, err)
+		return 1
+	}
+	defer db.Close()
+
+	for _, stmt := range sqliteSchema {
+		_, err = db.Exec(stmt)
+		if err != nil {
+			fmt.Printf("failed to create tables in %s: %s\n", e.dbPath, err)
+			return 1
+		}
+	}
+
+	//
+	// Get the connection, using default credentials
+	//
+	session, err := utils.NewSession()
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+
+	//
+	// Now invoke our callback - this will call the function
+	// "Export" once if we're not running with a role-file,
+	// otherwise once for each role.
+	//
+	e.exported = make(map[string]bool)
+	errs := utils.HandleRoles(session, e.rolesPath, e.Export, db)
+	if len(errs) > 0 {
+		fmt.Printf("errors running export\n")
+		for _, err := range errs {
+			fmt.Printf("%s\n", err)
+		}
+		return 1
+	}
+
+	return 0
+}
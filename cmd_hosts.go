@@ -0,0 +1,204 @@
+// Generate /etc/hosts entries for our running instances.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/skx/aws-utils/instances"
+	"github.com/skx/aws-utils/utils"
+)
+
+// Structure for our options and state.
+type hostsCommand struct {
+
+	// Path to a file containing roles
+	rolesPath string
+
+	// Use public addresses?
+	public bool
+
+	// Path of a hosts-file to update, rather than printing to STDOUT
+	writePath string
+
+	// Filtering and sorting options
+	instanceSelection
+
+	// The instances we've found, across all accounts
+	instanceCollector
+
+	// The alias of each account, keyed by account ID
+	aliases map[string]string
+}
+
+// Arguments adds per-command args to the object.
+func (h *hostsCommand) Arguments(f *flag.FlagSet) {
+	f.StringVar(&h.rolesPath, "roles", "", "Path to a list of roles to process, one by one")
+	f.BoolVar(&h.public, "public", false, "Use the public IPv4 address of each instance")
+	f.StringVar(&h.writePath, "write", "", "Update a managed block within the given hosts-file, such as /etc/hosts")
+	h.selectionArguments(f)
+}
+
+// Info returns the name of this subcommand.
+func (h *hostsCommand) Info() (string, string) {
+	return "hosts", `Generate /etc/hosts entries for running instances.
+
+Details:
+
+This command outputs a line in /etc/hosts format for each running
+instance, containing the address of the instance, its name, and its name
+qualified by the alias of the account in which it is running:
+
+    $ aws-utils hosts
+    10.12.43.120    prod-web-1 prod-web-1.mycompany-prod
+
+If the account has no alias the account ID is used instead.  Names may
+only contain letters, digits, dots, and hyphens, other characters are
+replaced with hyphens.  If several instances have the same name their
+instance IDs are appended, to make them unique.
+
+By default the private IPv4 address is used, add '-public' to use the
+public address.
+
+Rather than printing the entries you may update a managed block within a
+hosts-file, leaving the remainder of the file untouched:
+
+    $ sudo aws-utils hosts -roles=./roles -write /etc/hosts
+` + selectionHelp
+
+}
+
+// CollectInstances gathers the running instances of each account, along
+// with the account alias.
+func (h *hostsCommand) CollectInstances(svc *ec2.EC2, acct string, void interface{}) error {
+
+	// Get the running instances.
+	err := h.instanceCollector.CollectInstances(svc, acct, void)
+	if err != nil {
+		return err
+	}
+
+	// Find the alias, using the same credentials.
+	sess := void.(*session.Session)
+	alias, err := getAccountAlias(iam.New(sess, &aws.Config{Credentials: svc.Config.Credentials}))
+	if err != nil || alias == "" {
+		alias = acct
+	}
+	h.aliases[acct] = alias
+
+	return nil
+}
+
+// Hosts returns the hosts-file entries for the given instances.
+func (h *hostsCommand) Hosts(objs []instances.InstanceOutput) string {
+
+	// Count the names, so duplicates may be made unique with the
+	// instance ID, in the same way as ssh-config.
+	seen := make(map[string]int)
+	for _, obj := range objs {
+		seen[hostName(obj.InstanceName)]++
+	}
+
+	var out strings.Builder
+	for _, obj := range objs {
+
+		addr := obj.PrivateIPv4
+		if h.public {
+			addr = obj.PublicIPv4
+		}
+		if addr == "" {
+			continue
+		}
+
+		name := hostName(obj.InstanceName)
+		if name == "" {
+			name = obj.InstanceID
+		} else if seen[name] > 1 {
+			name = name + "-" + obj.InstanceID
+		}
+
+		alias := hostName(h.aliases[obj.AWSAccount])
+		if alias == "" {
+			alias = obj.AWSAccount
+		}
+
+		out.WriteString(fmt.Sprintf("%-15s %s %s.%s\n", addr, name, name, alias))
+	}
+	return out.String()
+}
+
+// hostName returns the given name with any characters which aren't valid
+// within a hostname replaced, so that a tag cannot produce a broken or
+// misleading entry.
+func hostName(name string) string {
+	name = regexp.MustCompile(`[^A-Za-z0-9.-]+`).ReplaceAllString(name, "-")
+	return strings.Trim(name, ".-")
+}
+
+// Execute is invoked if the user specifies this subcommand.
+func (h *hostsCommand) Execute(args []string) int {
+
+	// Parse the filter-expression and sort-keys
+	err := h.parseSelection()
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	//
+	// Get the connection, using default credentials
+	//
+	session, err := utils.NewSession()
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+
+	//
+	// Now invoke our callback - this will call the function
+	// "CollectInstances" once if we're not running with a role-file,
+	// otherwise once for each role.
+	//
+	h.aliases = make(map[string]string)
+	errs := utils.HandleRoles(session, h.rolesPath, h.CollectInstances, session)
+	if len(errs) > 0 {
+		fmt.Printf("errors running hosts\n")
+		for _, err := range errs {
+			fmt.Printf("%s\n", err)
+		}
+		return 1
+	}
+
+	// Sort by name by default, so output is stable.
+	ret := h.results
+	sortByName(ret)
+
+	ret, err = h.applySelection(ret)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	hosts := h.Hosts(ret)
+
+	// Just print them?
+	if h.writePath == "" {
+		fmt.Print(hosts)
+		return 0
+	}
+
+	err = utils.UpdateManagedBlock(h.writePath, "aws-utils hosts", hosts)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/skx/aws-utils/instances"
+)
+
+// TestHostName tests that names are made safe for hosts-files.
+func TestHostName(t *testing.T) {
+
+	type TestCase struct {
+		Input  string
+		Output string
+	}
+
+	tests := []TestCase{
+		{"prod-web-1", "prod-web-1"},
+		{"prod web 1", "prod-web-1"},
+		{"Prod.Web_1", "Prod.Web-1"},
+		{"web  #evil", "web-evil"},
+		{"10.0.0.1 localhost", "10.0.0.1-localhost"},
+		{"web\n127.0.0.1 bank.com", "web-127.0.0.1-bank.com"},
+		{"-web-", "web"},
+		{"???", ""},
+	}
+
+	for _, test := range tests {
+		if out := hostName(test.Input); out != test.Output {
+			t.Errorf("%q: expected %q, got %q", test.Input, test.Output, out)
+		}
+	}
+}
+
+// TestHosts tests the generation of hosts-file entries.
+func TestHosts(t *testing.T) {
+
+	h := &hostsCommand{aliases: map[string]string{"1": "prod", "2": ""}}
+
+	objs := []instances.InstanceOutput{
+		{AWSAccount: "1", InstanceID: "i-1", InstanceName: "web", PrivateIPv4: "10.0.0.1"},
+		{AWSAccount: "1", InstanceID: "i-2", InstanceName: "web", PrivateIPv4: "10.0.0.2"},
+		{AWSAccount: "1", InstanceID: "i-3", InstanceName: "db server", PrivateIPv4: "10.0.0.3"},
+		{AWSAccount: "2", InstanceID: "i-4", InstanceName: "!!", PrivateIPv4: "10.0.0.4"},
+		{AWSAccount: "2", InstanceID: "i-5", InstanceName: "nowhere"},
+	}
+
+	expected := `10.0.0.1        web-i-1 web-i-1.prod
+10.0.0.2        web-i-2 web-i-2.prod
+10.0.0.3        db-server db-server.prod
+10.0.0.4        i-4 i-4.2
+`
+	if out := h.Hosts(objs); out != expected {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
@@ -0,0 +1,361 @@
+// Show scheduled events, and failed status checks, of our instances.
+//
+// Primarily written to notice retirement and maintenance events.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/skx/aws-utils/tags"
+	"github.com/skx/aws-utils/utils"
+)
+
+// instanceEvent is a scheduled event, or failed status check, of an instance.
+type instanceEvent struct {
+	Account     string     `json:"account"`
+	Region      string     `json:"region"`
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Event       string     `json:"event"`
+	Description string     `json:"description"`
+	NotBefore   *time.Time `json:"not_before,omitempty"`
+	Deadline    *time.Time `json:"deadline,omitempty"`
+}
+
+// Structure for our options and state.
+type instanceEventsCommand struct {
+
+	// Path to a file containing roles
+	rolesPath string
+
+	// Regions to examine, comma-separated, or "all"
+	regions string
+
+	// Should we export our results in JSON format?
+	jsonOutput bool
+
+	// The events we've found, across all accounts and regions
+	results []instanceEvent
+}
+
+// Arguments adds per-command args to the object.
+func (e *instanceEventsCommand) Arguments(f *flag.FlagSet) {
+	f.StringVar(&e.rolesPath, "roles", "", "Path to a list of roles to process, one by one")
+	f.StringVar(&e.regions, "regions", "", "The regions to examine, comma-separated, or 'all' for every enabled region.  The default is the current region")
+	f.BoolVar(&e.jsonOutput, "json", false, "Output the results in JSON.")
+}
+
+// Info returns the name of this subcommand.
+func (e *instanceEventsCommand) Info() (string, string) {
+	return "instance-events", `Show scheduled events, and failed status checks.
+
+Details:
+
+This command shows the scheduled events of your instances, such as
+retirement, reboots, and maintenance, along with their deadlines, as well
+as any instances which are failing their system or instance status checks:
+
+    $ aws-utils instance-events -roles=./roles -regions=all
+    Account       Region          ID                   Name                           Event                Deadline          Description
+    123456789012  eu-central-1    i-01234567890abcdef  prod-web-1                     instance-retirement  2021-06-01 12:00  The instance is running on degraded hardware
+    123456789012  eu-west-1       i-0fedcba9876543210  prod-db-1                      instance-status      -                 impaired reachability
+
+By default only the current region is examined, '-regions' accepts a
+comma-separated list of regions, or "all" for every enabled region.  Add
+'-json' for machine-readable output.
+
+Events are sorted by their deadline, soonest first, and completed or
+cancelled events are ignored.
+`
+
+}
+
+// regionList returns the regions we should examine for the given account.
+func (e *instanceEventsCommand) regionList(svc *ec2.EC2) ([]string, error) {
+
+	if e.regions == "" {
+		return []string{aws.StringValue(svc.Config.Region)}, nil
+	}
+
+	if e.regions != "all" {
+		ret := []string{}
+		for _, r := range strings.Split(e.regions, ",") {
+			if r = strings.TrimSpace(r); r != "" {
+				ret = append(ret, r)
+			}
+		}
+		return ret, nil
+	}
+
+	out, err := svc.DescribeRegions(&ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, fmt.Errorf("DescribeRegions failed: %s", err)
+	}
+
+	ret := []string{}
+	for _, r := range out.Regions {
+		ret = append(ret, aws.StringValue(r.RegionName))
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// CollectEvents gathers the events of each region of the given account.
+func (e *instanceEventsCommand) CollectEvents(svc *ec2.EC2, acct string, void interface{}) error {
+
+	sess := void.(*session.Session)
+
+	regions, err := e.regionList(svc)
+	if err != nil {
+		return err
+	}
+
+	for _, region := range regions {
+
+		// A client for this region, with the same credentials.
+		rsvc := ec2.New(sess, &aws.Config{Credentials: svc.Config.Credentials, Region: aws.String(region)})
+
+		events, err := regionEvents(rsvc, acct, region)
+		if err != nil {
+			return fmt.Errorf("%s: %s", region, err)
+		}
+		if len(events) == 0 {
+			continue
+		}
+
+		// Find the names of the instances.
+		ids := []string{}
+		for _, ev := range events {
+			ids = append(ids, ev.ID)
+		}
+		names, err := instanceNames(rsvc, ids)
+		if err != nil {
+			return fmt.Errorf("%s: %s", region, err)
+		}
+
+		for _, ev := range events {
+			ev.Name = names[ev.ID]
+			e.results = append(e.results, ev)
+		}
+	}
+	return nil
+}
+
+// instanceNames returns the names of the given instances, keyed by ID.
+//
+// Only the tags of the instances are needed, so this is much cheaper than
+// retrieving their full details via the instances package.
+func instanceNames(svc *ec2.EC2, ids []string) (map[string]string, error) {
+
+	ret := make(map[string]string)
+
+	for len(ids) > 0 {
+
+		// Take the next batch of IDs, a filter accepts at most 200.
+		n := 200
+		if n > len(ids) {
+			n = len(ids)
+		}
+		batch := aws.StringSlice(ids[:n])
+		ids = ids[n:]
+
+		// A filter is used, rather than InstanceIds, so that an
+		// instance terminated since its status was retrieved doesn't
+		// cause an error.
+		input := &ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{
+				{
+					Name:   aws.String("instance-id"),
+					Values: batch,
+				},
+			},
+		}
+		err := svc.DescribeInstancesPages(input, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, res := range page.Reservations {
+				for _, inst := range res.Instances {
+					id := aws.StringValue(inst.InstanceId)
+					ret[id] = tags.Name(tags.FromEC2(inst.Tags), id)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return ret, fmt.Errorf("DescribeInstances failed: %s", err)
+		}
+	}
+
+	return ret, nil
+}
+
+// regionEvents returns the scheduled events and failed status checks of
+// the instances within a single region.
+func regionEvents(svc *ec2.EC2, acct string, region string) ([]instanceEvent, error) {
+
+	ret := []instanceEvent{}
+
+	input := &ec2.DescribeInstanceStatusInput{IncludeAllInstances: aws.Bool(true)}
+	err := svc.DescribeInstanceStatusPages(input, func(page *ec2.DescribeInstanceStatusOutput, lastPage bool) bool {
+		ret = append(ret, statusEvents(page.InstanceStatuses, acct, region)...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("DescribeInstanceStatus failed: %s", err)
+	}
+	return ret, nil
+}
+
+// statusEvents returns the scheduled events, which haven't completed or
+// been cancelled, and the failed status checks of the given instances.
+func statusEvents(statuses []*ec2.InstanceStatus, acct string, region string) []instanceEvent {
+
+	ret := []instanceEvent{}
+
+	for _, status := range statuses {
+
+		id := aws.StringValue(status.InstanceId)
+
+		for _, ev := range status.Events {
+
+			desc := aws.StringValue(ev.Description)
+			if strings.HasPrefix(desc, "[Completed]") || strings.HasPrefix(desc, "[Canceled]") {
+				continue
+			}
+
+			ent := instanceEvent{
+				Account:     acct,
+				Region:      region,
+				ID:          id,
+				Event:       aws.StringValue(ev.Code),
+				Description: desc,
+				NotBefore:   ev.NotBefore,
+				Deadline:    ev.NotBeforeDeadline,
+			}
+			if ent.Deadline == nil {
+				ent.Deadline = ev.NotBefore
+			}
+			ret = append(ret, ent)
+		}
+
+		checks := []struct {
+			name    string
+			summary *ec2.InstanceStatusSummary
+		}{
+			{"system-status", status.SystemStatus},
+			{"instance-status", status.InstanceStatus},
+		}
+		for _, check := range checks {
+			name, summary := check.name, check.summary
+			if summary == nil || aws.StringValue(summary.Status) != "impaired" {
+				continue
+			}
+
+			failed := []string{}
+			for _, d := range summary.Details {
+				if aws.StringValue(d.Status) == "failed" {
+					failed = append(failed, aws.StringValue(d.Name))
+				}
+			}
+			ret = append(ret, instanceEvent{
+				Account:     acct,
+				Region:      region,
+				ID:          id,
+				Event:       name,
+				Description: "impaired " + strings.Join(failed, ","),
+			})
+		}
+	}
+	return ret
+}
+
+// sortEvents sorts the given events by deadline, soonest first, with
+// those without a deadline last.
+func sortEvents(ret []instanceEvent) {
+	sort.SliceStable(ret, func(i, j int) bool {
+		a, b := ret[i].Deadline, ret[j].Deadline
+		if (a == nil) != (b == nil) {
+			return a != nil
+		}
+		if a != nil && !a.Equal(*b) {
+			return a.Before(*b)
+		}
+		if ret[i].Account != ret[j].Account {
+			return ret[i].Account < ret[j].Account
+		}
+		return ret[i].ID < ret[j].ID
+	})
+}
+
+// Report outputs the events we've found, sorted by deadline.
+func (e *instanceEventsCommand) Report() error {
+
+	ret := e.results
+	sortEvents(ret)
+
+	if e.jsonOutput {
+		b, err := json.MarshalIndent(ret, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error exporting to JSON %s", err)
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	if len(ret) == 0 {
+		fmt.Printf("No scheduled events, or failed status checks, found.\n")
+		return nil
+	}
+
+	fmt.Printf("%-13s %-15s %-20s %-30s %-20s %-17s %s\n", "Account", "Region", "ID", "Name", "Event", "Deadline", "Description")
+	for _, ev := range ret {
+		deadline := "-"
+		if ev.Deadline != nil {
+			deadline = ev.Deadline.UTC().Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%-13s %-15s %-20s %-30s %-20s %-17s %s\n", ev.Account, ev.Region, ev.ID, ev.Name, ev.Event, deadline, ev.Description)
+	}
+	return nil
+}
+
+// Execute is invoked if the user specifies this subcommand.
+func (e *instanceEventsCommand) Execute(args []string) int {
+
+	//
+	// Get the connection, using default credentials
+	//
+	session, err := utils.NewSession()
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+
+	//
+	// Now invoke our callback - this will call the function
+	// "CollectEvents" once if we're not running with a role-file,
+	// otherwise once for each role.
+	//
+	errs := utils.HandleRoles(session, e.rolesPath, e.CollectEvents, session)
+
+	err = e.Report()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		fmt.Printf("errors running instance-events\n")
+		for _, err := range errs {
+			fmt.Printf("%s\n", err)
+		}
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// TestStatusEvents tests finding the events, and failed status checks,
+// of instances.
+func TestStatusEvents(t *testing.T) {
+
+	soon := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	later := time.Date(2021, 6, 8, 12, 0, 0, 0, time.UTC)
+
+	ok := &ec2.InstanceStatusSummary{Status: aws.String("ok")}
+
+	statuses := []*ec2.InstanceStatus{
+		// Healthy, without events
+		{InstanceId: aws.String("i-1"), SystemStatus: ok, InstanceStatus: ok},
+
+		// A retirement, with a deadline, and a completed reboot
+		{InstanceId: aws.String("i-2"), SystemStatus: ok, InstanceStatus: ok,
+			Events: []*ec2.InstanceStatusEvent{
+				{Code: aws.String("instance-retirement"), Description: aws.String("degraded hardware"),
+					NotBefore: aws.Time(soon), NotBeforeDeadline: aws.Time(later)},
+				{Code: aws.String("system-reboot"), Description: aws.String("[Completed] scheduled reboot"),
+					NotBefore: aws.Time(soon)},
+			}},
+
+		// A maintenance event, without a deadline, which was cancelled
+		// and then rescheduled
+		{InstanceId: aws.String("i-3"),
+			Events: []*ec2.InstanceStatusEvent{
+				{Code: aws.String("system-maintenance"), Description: aws.String("[Canceled] maintenance"),
+					NotBefore: aws.Time(soon)},
+				{Code: aws.String("system-maintenance"), Description: aws.String("maintenance"),
+					NotBefore: aws.Time(later)},
+			}},
+
+		// Failed status checks
+		{InstanceId: aws.String("i-4"),
+			SystemStatus: &ec2.InstanceStatusSummary{Status: aws.String("impaired"),
+				Details: []*ec2.InstanceStatusDetails{
+					{Name: aws.String("reachability"), Status: aws.String("failed")},
+				}},
+			InstanceStatus: &ec2.InstanceStatusSummary{Status: aws.String("impaired"),
+				Details: []*ec2.InstanceStatusDetails{
+					{Name: aws.String("reachability"), Status: aws.String("failed")},
+					{Name: aws.String("other"), Status: aws.String("passed")},
+				}},
+		},
+
+		// Checks which are still initializing aren't failures
+		{InstanceId: aws.String("i-5"),
+			InstanceStatus: &ec2.InstanceStatusSummary{Status: aws.String("initializing")}},
+	}
+
+	type TestCase struct {
+		ID          string
+		Event       string
+		Description string
+		Deadline    *time.Time
+	}
+
+	tests := []TestCase{
+		{"i-2", "instance-retirement", "degraded hardware", &later},
+		{"i-3", "system-maintenance", "maintenance", &later},
+		{"i-4", "system-status", "impaired reachability", nil},
+		{"i-4", "instance-status", "impaired reachability", nil},
+	}
+
+	out := statusEvents(statuses, "123456789012", "eu-central-1")
+	if len(out) != len(tests) {
+		t.Fatalf("expected %d events, got %d: %+v", len(tests), len(out), out)
+	}
+
+	for i, test := range tests {
+		ev := out[i]
+		if ev.Account != "123456789012" || ev.Region != "eu-central-1" {
+			t.Errorf("%d: wrong account/region %s/%s", i, ev.Account, ev.Region)
+		}
+		if ev.ID != test.ID || ev.Event != test.Event || ev.Description != test.Description {
+			t.Errorf("%d: expected %s %s %q, got %s %s %q", i, test.ID, test.Event, test.Description, ev.ID, ev.Event, ev.Description)
+		}
+		if (ev.Deadline == nil) != (test.Deadline == nil) || (ev.Deadline != nil && !ev.Deadline.Equal(*test.Deadline)) {
+			t.Errorf("%d: expected deadline %v, got %v", i, test.Deadline, ev.Deadline)
+		}
+	}
+}
+
+// TestSortEvents tests that events are sorted by deadline, soonest first.
+func TestSortEvents(t *testing.T) {
+
+	soon := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	later := time.Date(2021, 6, 8, 12, 0, 0, 0, time.UTC)
+
+	events := []instanceEvent{
+		{Account: "1", ID: "i-1"},
+		{Account: "2", ID: "i-2", Deadline: &later},
+		{Account: "1", ID: "i-3", Deadline: &later},
+		{Account: "2", ID: "i-4", Deadline: &soon},
+		{Account: "1", ID: "i-0"},
+	}
+
+	sortEvents(events)
+
+	ids := []string{}
+	for _, ev := range events {
+		ids = append(ids, ev.ID)
+	}
+	if strings.Join(ids, ",") != "i-4,i-3,i-2,i-0,i-1" {
+		t.Errorf("unexpected order %s", strings.Join(ids, ","))
+	}
+}
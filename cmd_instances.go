@@ -9,10 +9,12 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"text/template"
 
-	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/skx/aws-utils/instances"
+	"github.com/skx/aws-utils/pricing"
+	"github.com/skx/aws-utils/tags"
 	"github.com/skx/aws-utils/utils"
 )
 
@@ -22,6 +24,12 @@ type instancesCommand struct {
 	// Path to a file containing roles
 	rolesPath string
 
+	// Tags to examine for the name of each resource
+	nameTags string
+
+	// Path to a local price table, if any
+	pricesPath string
+
 	// Should we export our results in JSON format?
 	jsonOutput bool
 
@@ -30,14 +38,34 @@ type instancesCommand struct {
 
 	// Specify a non-default template?
 	templatePath string
+
+	// Fields to include in JSON output, if not everything.
+	format string
+
+	// Path to save a snapshot of all instances to, if any
+	savePath string
+
+	// The fields parsed from the format string
+	fields []instances.Field
+
+	// Filtering and sorting options
+	instanceSelection
+
+	// The instances we've found, across all accounts
+	instanceCollector
 }
 
 // Arguments adds per-command args to the object.
 func (i *instancesCommand) Arguments(f *flag.FlagSet) {
 	f.StringVar(&i.rolesPath, "roles", "", "Path to a list of roles to process, one by one.")
-	f.StringVar(&i.templatePath, "template", "", "Path to a template to render, instead of the default")
-	f.BoolVar(&i.dumpTemplate, "dump-template", false, "Output the standard template to the console, and terminate")
+	f.StringVar(&i.nameTags, "name-tags", "Name", "The tags to examine, in order, for the name of each resource, e.g. 'Name,aws:cloudformation:stack-name'")
+	f.StringVar(&i.pricesPath, "prices", "", "Path to a JSON price table, updating the embedded prices used for the 'cost' field")
+	f.StringVar(&i.templatePath, "template", "", "Path to a template to render, or 'builtin:name' for a built-in template, instead of the default")
+	f.BoolVar(&i.dumpTemplate, "dump-template", false, "Output the standard template, or that chosen via -template, to the console, and terminate")
 	f.BoolVar(&i.jsonOutput, "json", false, "Output the results in JSON.")
+	f.StringVar(&i.format, "format", "", "The fields to include in JSON output, as used by csv-instances")
+	f.StringVar(&i.savePath, "save", "", "Save a snapshot of all instances to the given file, for use with inventory-diff")
+	i.selectionArguments(f)
 }
 
 // Info returns the name of this subcommand.
@@ -73,21 +101,99 @@ With the default template you can make your changes and use it like so:
     $ vi foo.tmpl
     $ aws-utils instances -template=./foo.tmpl
     ..
-`
+
+Several built-in templates are available, "brief", "volumes", and
+"markdown", which may be chosen, or dumped, in the same way:
+
+    $ aws-utils instances -template=builtin:markdown
+    $ aws-utils instances -template=builtin:brief -dump-template
+
+Templates may also show any of the fields supported by 'csv-instances',
+for example:
+
+    {{.InstanceName}} is running in {{.Field "subnet"}}
+
+Templates may use the following functions:
+
+    upper, lower    Change the case of a string.
+    join            Join a list, {{join ", " .IPv6Addresses}}.
+    pad, lpad       Pad a value to a width, {{pad 20 .InstanceName}}.
+    bytes, gib      Humanise a size in bytes or GiB, {{gib .EBSGiB}}.
+    date            Format a time, {{date "2006-01-02" .LaunchTime}}.
+    since, days     Humanise a duration, {{since .LaunchTime}}, or a number
+                    of days, {{days .AMIAge}} ("unknown" if negative).
+    add, sub, mul,
+    div             Arithmetic, {{mul .VCPUs 2}}.
+    default         A default for empty values, {{default "-" .PublicIPv4}}.
+    tag             The value of a tag, {{tag "Environment" .}}.
+    json            Encode a value as JSON, {{json .Tags}}.
+    totalEBS        The total size of the volumes of a list of instances.
+
+If a template defines templates named "header" or "footer" they are
+rendered once, before and after the instances, with the list of all
+instances:
+
+    {{define "header"}}{{len .}} instances{{end}}
+
+JSON output contains all known details by default, but you may select
+the fields to include via '-format', in the same way as 'csv-instances':
+
+    $ aws-utils instances -json -format=id,name,subnet
+
+The estimated monthly cost of each instance is available to templates
+via {{.Cost}}, and as the "cost" field, prices may be updated from a local
+file via '-prices' - see 'aws-utils help cost-summary' for details.  If
+the instance type, or the type of any volume, has no known price the cost
+is shown as "unknown".
+
+A snapshot of all the instances, including those which are stopped, may
+be saved with '-save', and two
+snapshots may later be compared via the 'inventory-diff' sub-command:
+
+    $ aws-utils instances -roles=./roles -save=snapshot.json
+` + selectionHelp
 
 }
 
-// DumpInstances looks up the appropriate details and outputs them to the
-// console, via the use of a provided template.
-func (i *instancesCommand) DumpInstances(svc *ec2.EC2, acct string, void interface{}) error {
+// templateText returns the text of the template we should use, which is
+// either a built-in template, or read from a file.
+func (i *instancesCommand) templateText() (string, error) {
 
-	// Cast our template back into the correct object-type
-	tmpl := void.(*template.Template)
+	if i.templatePath == "" {
+		return builtinTemplates["default"], nil
+	}
 
-	// Get the instances that are running.
-	ret, err := instances.GetInstances(svc, acct)
+	if strings.HasPrefix(i.templatePath, "builtin:") {
+		name := strings.TrimPrefix(i.templatePath, "builtin:")
+		text, ok := builtinTemplates[name]
+		if !ok {
+			return "", fmt.Errorf("unknown template %s, valid choices are: %s", i.templatePath, strings.Join(builtinTemplateNames(), ", "))
+		}
+		return text, nil
+	}
+
+	content, err := os.ReadFile(i.templatePath)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("failed to read %s:%s", i.templatePath, err.Error())
+	}
+	return string(content), nil
+}
+
+// DumpInstances outputs the details of the given instances to the
+// console, via the use of a provided template.
+//
+// If the template defines "header" or "footer" templates they are
+// rendered before and after the instances, with the whole result set.
+func (i *instancesCommand) DumpInstances(ret []instances.InstanceOutput, tmpl *template.Template) error {
+
+	var err error
+
+	// Output the header, if we should
+	if !i.jsonOutput && tmpl.Lookup("header") != nil {
+		err = tmpl.ExecuteTemplate(os.Stdout, "header", ret)
+		if err != nil {
+			return fmt.Errorf("error rendering header %s", err)
+		}
 	}
 
 	// For each one, output the appropriate thing.
@@ -96,8 +202,15 @@ func (i *instancesCommand) DumpInstances(svc *ec2.EC2, acct string, void interfa
 		// Output the rendered template to the console
 		if i.jsonOutput {
 
+			// Output everything, unless specific fields
+			// were chosen.
+			var data interface{} = obj
+			if len(i.fields) > 0 {
+				data = instances.Record(obj, i.fields)
+			}
+
 			var b []byte
-			b, err = json.Marshal(obj)
+			b, err = json.Marshal(data)
 			if err != nil {
 				return fmt.Errorf("error exporting to JSON %s", err)
 			}
@@ -109,55 +222,60 @@ func (i *instancesCommand) DumpInstances(svc *ec2.EC2, acct string, void interfa
 			}
 		}
 	}
+
+	// Output the footer, if we should
+	if !i.jsonOutput && tmpl.Lookup("footer") != nil {
+		err = tmpl.ExecuteTemplate(os.Stdout, "footer", ret)
+		if err != nil {
+			return fmt.Errorf("error rendering footer %s", err)
+		}
+	}
 	return nil
 }
 
 // Execute is invoked if the user specifies this subcommand.
 func (i *instancesCommand) Execute(args []string) int {
 
-	//
-	// Create the template we'll use for output
-	//
-	text := `
-{{.InstanceName}} {{.InstanceID}}
-  AMI         : {{.InstanceAMI}}
-  AMI Age     : {{.AMIAge}} days
-  AWS Account : {{.AWSAccount}}
-{{- if .SSHKeyName  }}
-  KeyName     : {{.SSHKeyName}}
-{{- end}}
-{{- if .PrivateIPv4 }}
-  Private IPv4: {{.PrivateIPv4}}
-{{- end}}
-{{- if .PublicIPv4  }}
-  Public  IPv4: {{.PublicIPv4}}
-{{- end}}
-{{if .Volumes}}
-  Volumes:{{range .Volumes}}
-     {{.Device}} {{.ID}} Size:{{.Size}}GiB Type:{{.Type}} Encrypted:{{.Encrypted}} IOPS:{{.IOPS}}{{end}}
-{{end}}
-`
+	// Use the tags we were given to find names
+	tags.SetNameChain(i.nameTags)
 
-	// Show the template?
-	if i.dumpTemplate {
-		fmt.Printf("%s\n", text)
-		return 0
+	// Update the embedded prices, if we were given a price table
+	if err := pricing.Load(i.pricesPath); err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
 	}
 
-	// If the user specified a template-path then use it
-	if i.templatePath != "" {
-		content, err := os.ReadFile(i.templatePath)
+	// Parse the filter-expression and sort-keys
+	if err := i.parseSelection(); err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	// Parse the fields, if any were specified
+	if i.format != "" {
+		var err error
+		i.fields, err = instances.ParseFields(i.format)
 		if err != nil {
-			fmt.Printf("failed to read %s:%s\n", i.templatePath, err.Error())
+			fmt.Printf("invalid format: %s\n", err)
 			return 1
-
 		}
+	}
 
-		text = string(content)
+	// Find the template we'll use for output
+	text, err := i.templateText()
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	// Show the template?
+	if i.dumpTemplate {
+		fmt.Printf("%s\n", text)
+		return 0
 	}
 
 	// Compile the template
-	tmpl, err := template.New("output").Parse(text)
+	tmpl, err := parseTemplate(text)
 	if err != nil {
 		fmt.Printf("failed to compile template:%s\n", err.Error())
 		return 1
@@ -172,12 +290,57 @@ func (i *instancesCommand) Execute(args []string) int {
 		return 1
 	}
 
+	// Snapshots contain every instance which hasn't been terminated, so
+	// that stopping an instance isn't reported as its removal.
+	if i.savePath != "" {
+		i.states = []string{"pending", "running", "stopping", "stopped"}
+	}
+
 	//
 	// Now invoke our callback - this will call the function
-	// "DumpInstances" once if we're not running with a role-file,
+	// "CollectInstances" once if we're not running with a role-file,
 	// otherwise once for each role.
 	//
-	errs := utils.HandleRoles(session, i.rolesPath, i.DumpInstances, tmpl)
+	errs := utils.HandleRoles(session, i.rolesPath, i.CollectInstances, nil)
+
+	//
+	// Save a snapshot, if we should.
+	//
+	// A partial snapshot would show instances as removed when they
+	// are compared, so we don't save one if there were errors.
+	//
+	if i.savePath != "" {
+		if len(errs) > 0 {
+			errs = append(errs, fmt.Errorf("not saving snapshot to %s, due to errors", i.savePath))
+		} else {
+			err = instances.SaveSnapshot(i.savePath, i.results)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	//
+	// Only running instances are output, even if we found stopped
+	// ones for the snapshot.
+	//
+	running := []instances.InstanceOutput{}
+	for _, obj := range i.results {
+		if obj.InstanceState == "running" || obj.InstanceState == "pending" {
+			running = append(running, obj)
+		}
+	}
+
+	//
+	// Filter, sort, and output what we found.
+	//
+	ret, err := i.applySelection(running)
+	if err == nil {
+		err = i.DumpInstances(ret, tmpl)
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
 
 	if len(errs) > 0 {
 		fmt.Printf("errors running instance dump\n")
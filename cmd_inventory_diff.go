@@ -0,0 +1,134 @@
+// Compare two snapshots of our instances, as saved by "instances -save".
+//
+// Primarily written to produce a weekly change-report.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/skx/aws-utils/instances"
+)
+
+// Structure for our options and state.
+type inventoryDiffCommand struct {
+
+	// Should we export our results in JSON format?
+	jsonOutput bool
+}
+
+// Arguments adds per-command args to the object.
+func (i *inventoryDiffCommand) Arguments(f *flag.FlagSet) {
+	f.BoolVar(&i.jsonOutput, "json", false, "Output the results in JSON.")
+}
+
+// Info returns the name of this subcommand.
+func (i *inventoryDiffCommand) Info() (string, string) {
+	return "inventory-diff", `Show the differences between two inventory snapshots.
+
+Details:
+
+This command compares two snapshots of your instances, as created by the
+'instances' sub-command, and reports upon the instances which have been
+added, removed, or changed between them:
+
+    $ aws-utils instances -roles=./roles -save=old.json
+    ..
+    $ aws-utils instances -roles=./roles -save=new.json
+    $ aws-utils inventory-diff old.json new.json
+    Comparing old.json (2026-10-11 09:00) with new.json (2026-10-18 09:00)
+    Added:
+      + 123456789012 i-0123456789abcdef0 prod-web-3
+    Removed:
+      - 123456789012 i-0fedcba9876543210 prod-web-1
+    Changed:
+      ~ 123456789012 i-0aaaabbbbccccdddd prod-db-1
+          state: running -> stopped
+          type: t3.large -> m5.large
+          tag:Owner: removed "alice"
+
+Changes are reported to the name, state, type, AMI, IP addresses,
+volumes, and tags of each instance.  Snapshots include stopped instances,
+so stopping an instance is reported as a change rather than its removal.
+`
+
+}
+
+// Execute is invoked if the user specifies this subcommand.
+func (i *inventoryDiffCommand) Execute(args []string) int {
+
+	if len(args) != 2 {
+		fmt.Printf("Usage: aws-utils inventory-diff old.json new.json\n")
+		return 1
+	}
+
+	old, err := instances.LoadSnapshot(args[0])
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+	cur, err := instances.LoadSnapshot(args[1])
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	diff := instances.DiffSnapshots(old, cur)
+
+	if i.jsonOutput {
+		b, err := json.Marshal(diff)
+		if err != nil {
+			fmt.Printf("error exporting to JSON %s\n", err)
+			return 1
+		}
+		fmt.Println(string(b))
+		return 0
+	}
+
+	fmt.Printf("Comparing %s (%s) with %s (%s)\n",
+		args[0], old.Created.Format("2006-01-02 15:04"),
+		args[1], cur.Created.Format("2006-01-02 15:04"))
+
+	if len(diff.Added) > 0 {
+		fmt.Printf("Added:\n")
+		for _, obj := range diff.Added {
+			fmt.Printf("  + %s %s %s\n", obj.AWSAccount, obj.InstanceID, obj.InstanceName)
+		}
+	}
+
+	if len(diff.Removed) > 0 {
+		fmt.Printf("Removed:\n")
+		for _, obj := range diff.Removed {
+			fmt.Printf("  - %s %s %s\n", obj.AWSAccount, obj.InstanceID, obj.InstanceName)
+		}
+	}
+
+	if len(diff.Changed) > 0 {
+		fmt.Printf("Changed:\n")
+		for _, change := range diff.Changed {
+			obj := change.Instance
+			fmt.Printf("  ~ %s %s %s\n", obj.AWSAccount, obj.InstanceID, obj.InstanceName)
+			for _, d := range change.Differences {
+				switch {
+				case d.Added:
+					fmt.Printf("      %s: added %q\n", d.Field, d.New)
+				case d.Removed:
+					fmt.Printf("      %s: removed %q\n", d.Field, d.Old)
+				case strings.HasPrefix(d.Field, "tag:"):
+					fmt.Printf("      %s: %q -> %q\n", d.Field, d.Old, d.New)
+				default:
+					fmt.Printf("      %s: %s -> %s\n", d.Field, d.Old, d.New)
+				}
+			}
+		}
+	}
+
+	if len(diff.Added)+len(diff.Removed)+len(diff.Changed) == 0 {
+		fmt.Printf("No changes.\n")
+	}
+
+	return 0
+}
@@ -1,90 +1,206 @@
-// Show private IPv4 address of the named instance.
+// Show the IP address of the named instance.
 //
 // Primarily written to handle tab-completion
 
 package main
 
 import (
-	"fmt"
+	"encoding/json"
 	"flag"
-	"regexp"
+	"fmt"
 
-	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/skx/aws-utils/instances"
 	"github.com/skx/aws-utils/utils"
 )
 
+// ipResult is the structure output for each match in JSON-mode.
+type ipResult struct {
+	Name    string   `json:"name"`
+	ID      string   `json:"id"`
+	Account string   `json:"account"`
+	Private string   `json:"private"`
+	Public  string   `json:"public"`
+	IPv6    []string `json:"ipv6,omitempty"`
+}
+
 // Structure for our options and state.
 type ipCommand struct {
 
+	// Path to a file containing roles
+	rolesPath string
+
 	// Are we verbose?
 	verbose bool
-}
 
+	// Show IPv6 addresses instead of IPv4?
+	ipv6 bool
+
+	// Show public IPv4 addresses instead of private?
+	public bool
+
+	// Show every match, rather than the first?
+	all bool
+
+	// Output JSON?
+	json bool
+
+	// Name-matching options
+	instanceMatcher
+
+	// Filtering and sorting options
+	instanceSelection
+
+	// The instances we've found, across all accounts
+	instanceCollector
+}
 
 // Arguments adds per-command args to the object.
 func (i *ipCommand) Arguments(f *flag.FlagSet) {
+	f.StringVar(&i.rolesPath, "roles", "", "Path to a list of roles to process, one by one")
 	f.BoolVar(&i.verbose, "verbose", false, "Should we show the matching name too?")
+	f.BoolVar(&i.ipv6, "6", false, "Show the IPv6 addresses of the instance, instead of the private IPv4 address")
+	f.BoolVar(&i.public, "public", false, "Show the public IPv4 address of the instance, instead of the private IPv4 address")
+	f.BoolVar(&i.all, "all", false, "Show every matching instance, rather than only the first")
+	f.BoolVar(&i.json, "json", false, "Output the name, ID, account, and addresses of each match as JSON")
+	i.matcherArguments(f)
+	i.selectionArguments(f)
 }
 
 // Info returns the name of this subcommand.
 func (i *ipCommand) Info() (string, string) {
-	return "ip", `Show the private IP of the given instance.
+	return "ip", `Show the IP of the given instance.
 
 Details:
 
-This command simply outputs the first private IP address of the instance
+This command simply outputs the private IP address of the first instance
 which matches the given regular expression.
 
-    $ aws-utils ip *prod*manager
+    $ aws-utils ip 'prod.*manager'
     10.12.43.120
 
-Unlike other commands this explicitly does not support the use of a role-path,
-being limited to the account signed in, and any assumed role only.
+To show every matching instance, rather than only the first, add '-all'.
 
-It is useful for command-line completion, and similar scripting purposes.`
+Names are matched as regular expressions by default, you may add '-glob'
+to match them as shell-style globs, or '-exact' to require an exact match:
 
-}
+    $ aws-utils ip -glob '*prod*manager'
+    10.12.43.120
 
-// DumpInstances looks up the appropriate details and outputs them to the
-// console, via the use of a provided template.
-func (i *ipCommand) OutputInformation(svc *ec2.EC2, acct string, void interface{}) error {
+Matching instances may also be restricted to those with particular tags,
+via '-tag', which may be repeated.  Each is either 'Key=Value', or just
+'Key' to require the tag be present with any value:
 
-	// Get the name we're completing upon
-	name := void.(string)
+    $ aws-utils ip -all -tag Environment=prod -tag Backup 'db'
+    10.12.43.121
+    10.12.43.122
 
-	// Get the instances that are running.
-	ret, err := instances.GetInstances(svc, acct)
+If you'd prefer to see the public IPv4 address of the matching instance
+add '-public', or for its IPv6 addresses add '-6':
+
+    $ aws-utils ip -6 'prod.*manager'
+    2a05:d014:abc:de00::1234
+
+To search across several accounts specify a role-file via '-roles'.  If
+some accounts cannot be examined the matches from the others are still
+shown, followed by the errors, and the exit code is non-zero.
+
+For scripting purposes you may add '-json' to output the name, ID,
+account, and addresses of each matching instance:
+
+    $ aws-utils ip -json -all -roles=./roles 'manager'
+    [
+      {
+        "name": "prod-manager",
+        "id": "i-01234567890abcdef",
+        "account": "123456789012",
+        "private": "10.12.43.120",
+        "public": "3.120.45.67"
+      }
+    ]
+
+It is useful for command-line completion, and similar scripting purposes.
+` + selectionHelp
+
+}
+
+// Matches returns the instances which match the given name, limited to
+// the first unless '-all' was specified.
+func (i *ipCommand) Matches(ret []instances.InstanceOutput, name string) ([]instances.InstanceOutput, error) {
+
+	found, err := i.matchInstances(ret, name)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	if !i.all && len(found) > 1 {
+		found = found[:1]
+	}
+	return found, nil
+}
+
+// OutputInformation shows the addresses of the given instances.
+func (i *ipCommand) OutputInformation(ret []instances.InstanceOutput) {
+
 	// For each one, output the appropriate thing.
 	for _, obj := range ret {
 
-		// match against the name
-		m, err := regexp.MatchString(name,obj.InstanceName)
-		if err != nil {
-			return fmt.Errorf("error running regexp match %s", err)
+		// The addresses we'll show
+		ips := []string{obj.PrivateIPv4}
+		if i.public {
+			ips = []string{obj.PublicIPv4}
+		}
+		if i.ipv6 {
+			ips = obj.IPv6Addresses
 		}
 
-		// if there was a match
-		if m {
-			if ( i.verbose ) {
+		for _, ip := range ips {
+			if ip == "" {
+				continue
+			}
+			if i.verbose {
 				// show IP + name if being verbose
-				fmt.Printf("%s %s\n", obj.PrivateIPv4, obj.InstanceName)
+				fmt.Printf("%s %s\n", ip, obj.InstanceName)
 			} else {
 				// otherwise just the IP.
-				fmt.Printf("%s\n", obj.PrivateIPv4)
+				fmt.Printf("%s\n", ip)
 			}
 		}
 	}
+}
+
+// OutputJSON shows the details of the given instances as JSON.
+func (i *ipCommand) OutputJSON(ret []instances.InstanceOutput) error {
+
+	out := []ipResult{}
+	for _, obj := range ret {
+		out = append(out, ipResult{
+			Name:    obj.InstanceName,
+			ID:      obj.InstanceID,
+			Account: obj.AWSAccount,
+			Private: obj.PrivateIPv4,
+			Public:  obj.PublicIPv4,
+			IPv6:    obj.IPv6Addresses,
+		})
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding JSON %s", err)
+	}
+	fmt.Printf("%s\n", data)
 	return nil
 }
 
 // Execute is invoked if the user specifies this subcommand.
 func (i *ipCommand) Execute(args []string) int {
 
+	// Parse the filter-expression and sort-keys
+	err := i.parseSelection()
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
 	//
 	// Get the connection, using default credentials
 	//
@@ -94,23 +210,51 @@ func (i *ipCommand) Execute(args []string) int {
 		return 1
 	}
 
-	for _, name := range args {
+	//
+	// Now invoke our callback - this will call the function
+	// "CollectInstances" once if we're not running with a role-file,
+	// otherwise once for each role.
+	//
+	errs := utils.HandleRoles(session, i.rolesPath, i.CollectInstances, nil)
 
-		//
-		// Now invoke our callback which allows iteration over
-		// available instances.
-		//
-		// Pass the name, but don't pass a role-path.
-		//
-		errs := utils.HandleRoles(session, "", i.OutputInformation, name)
+	//
+	// Filter and sort the instances, then find the matches
+	// for each name.
+	//
+	// Any matches are shown even if some accounts could not be
+	// examined, the errors are reported afterwards.
+	//
+	matches := []instances.InstanceOutput{}
 
-		if len(errs) > 0 {
-			fmt.Printf("errors encountered running this operation:\n")
-			for _, err := range errs {
-				fmt.Printf("%s\n", err)
+	ret, err := i.applySelection(i.results)
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		for _, name := range args {
+			found, err := i.Matches(ret, name)
+			if err != nil {
+				errs = append(errs, err)
+				break
 			}
-			return 1
+			matches = append(matches, found...)
+		}
+	}
+
+	if i.json {
+		err = i.OutputJSON(matches)
+		if err != nil {
+			errs = append(errs, err)
 		}
+	} else {
+		i.OutputInformation(matches)
+	}
+
+	if len(errs) > 0 {
+		fmt.Printf("errors encountered running this operation:\n")
+		for _, err := range errs {
+			fmt.Printf("%s\n", err)
+		}
+		return 1
 	}
 
 	return 0
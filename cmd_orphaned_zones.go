@@ -8,6 +8,9 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/route53"
 	"github.com/skx/aws-utils/utils"
 	"github.com/skx/subcommands"
@@ -49,11 +52,36 @@ func (i *orphanedZonesCommand) Execute(args []string) int {
 	// Get the service handle
 	svc := route53.New(sess)
 
+	// Find the zones
+	valid, orphan, error, err := findOrphanedZones(svc)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	// show results: valid, orphaned, error
+	for _, entry := range valid {
+		fmt.Printf("VALID  - %s\n", entry)
+	}
+	for _, entry := range orphan {
+		fmt.Printf("ORPHAN - %s\n", entry)
+	}
+	for _, entry := range error {
+		fmt.Printf("ERROR  - %s\n", entry)
+	}
+
+	return 0
+}
+
+// findOrphanedZones examines each hosted zone, and returns the names of
+// those which are valid, those which are orphaned, and those which could
+// not be looked up - each sorted.
+func findOrphanedZones(svc *route53.Route53) ([]string, []string, []string, error) {
+
 	// Get all the results
 	r, err := svc.ListHostedZones(&route53.ListHostedZonesInput{})
 	if err != nil {
-		fmt.Printf("failed to call ListHostedZones: %s\n", err)
-		return 1
+		return nil, nil, nil, fmt.Errorf("failed to call ListHostedZones: %s", err)
 	}
 
 	// Collect orphans, errors, and valid domains in these
@@ -90,19 +118,21 @@ func (i *orphanedZonesCommand) Execute(args []string) int {
 		}
 	}
 
-	// show results: valid, orphaned, error
 	sort.Strings(valid)
-	for _, entry := range valid {
-		fmt.Printf("VALID  - %s\n", entry)
-	}
 	sort.Strings(orphan)
-	for _, entry := range orphan {
-		fmt.Printf("ORPHAN - %s\n", entry)
-	}
 	sort.Strings(error)
-	for _, entry := range error {
-		fmt.Printf("ERROR  - %s\n", entry)
-	}
 
-	return 0
+	return valid, orphan, error, nil
+}
+
+// route53Client returns a Route53 client which uses the same credentials
+// as the given EC2 client, so that assumed roles are respected.
+func route53Client(svc *ec2.EC2) *route53.Route53 {
+
+	// Setup a session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	return route53.New(sess, &aws.Config{Credentials: svc.Config.Credentials})
 }
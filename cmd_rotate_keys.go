@@ -77,38 +77,7 @@ execute this tool for the first time.
 
 func (r *rotateKeysCommand) confirm() error {
 
-	// Warning
-	fmt.Printf("%s", colorRed)
-	fmt.Printf("You already have 2 access keys in-use, we cannot generate more.\n")
-	fmt.Printf("\n")
-	fmt.Printf("Press Ctrl-C to cancel, or enter 'OK' (uppercase) to delete the oldest key.\n")
-	fmt.Printf("\n")
-	fmt.Printf("%s", colorReset)
-
-	// Read a line of input
-	reader := bufio.NewReader(os.Stdin)
-	input, err := reader.ReadString('\n')
-	if err != nil {
-		return fmt.Errorf("failed to read from STDIN %s", err)
-	}
-
-	// Strip CR/newline from string, then leading/trailing spaces
-	input = strings.TrimSuffix(input, "\n")
-	input = strings.TrimSuffix(input, "\r")
-	input = strings.TrimSpace(input)
-
-	// no input
-	if input == "" {
-		return fmt.Errorf("aborting as you hit enter")
-	}
-
-	// not "OK"
-	if input != "OK" {
-		return fmt.Errorf("aborting as you entered '%s', not 'OK'", input)
-	}
-
-	// OK the user confirmed
-	return nil
+	return confirm("You already have 2 access keys in-use, we cannot generate more.\n\nPress Ctrl-C to cancel, or enter 'OK' (uppercase) to delete the oldest key.\n\n")
 }
 
 // setupPath populates the default path to the configuration file.
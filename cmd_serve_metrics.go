@@ -0,0 +1,296 @@
+// Expose details of our inventory as prometheus metrics.
+//
+// Primarily written to allow alerting upon stale AMIs.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/skx/aws-utils/instances"
+	"github.com/skx/aws-utils/utils"
+)
+
+// metric holds the samples of a single prometheus metric.
+type metric struct {
+	// help is the description of the metric.
+	help string
+
+	// samples maps the rendered label-set to the value.
+	samples map[string]float64
+}
+
+// metricSet holds a collection of metrics, which may be rendered in the
+// prometheus text exposition format.
+type metricSet struct {
+	metrics map[string]*metric
+}
+
+// newMetricSet returns an empty set of metrics.
+func newMetricSet() *metricSet {
+	return &metricSet{metrics: make(map[string]*metric)}
+}
+
+// add adds the given value to the sample with the given labels, which
+// are specified as alternating names and values.
+func (m *metricSet) add(name string, help string, value float64, labels ...string) {
+
+	ent, ok := m.metrics[name]
+	if !ok {
+		ent = &metric{help: help, samples: make(map[string]float64)}
+		m.metrics[name] = ent
+	}
+
+	pairs := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], v))
+	}
+
+	ent.samples[strings.Join(pairs, ",")] += value
+}
+
+// String renders the metrics, with all names and samples sorted.
+func (m *metricSet) String() string {
+
+	names := make([]string, 0, len(m.metrics))
+	for name := range m.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out strings.Builder
+	for _, name := range names {
+		ent := m.metrics[name]
+
+		out.WriteString(fmt.Sprintf("# HELP %s %s\n", name, ent.help))
+		out.WriteString(fmt.Sprintf("# TYPE %s gauge\n", name))
+
+		keys := make([]string, 0, len(ent.samples))
+		for key := range ent.samples {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if key == "" {
+				out.WriteString(fmt.Sprintf("%s %g\n", name, ent.samples[key]))
+			} else {
+				out.WriteString(fmt.Sprintf("%s{%s} %g\n", name, key, ent.samples[key]))
+			}
+		}
+	}
+	return out.String()
+}
+
+// Structure for our options and state.
+type serveMetricsCommand struct {
+
+	// Path to a file containing roles
+	rolesPath string
+
+	// Address to listen upon
+	listen string
+
+	// How often to collect the metrics
+	interval time.Duration
+
+	// Lock protects the rendered metrics
+	lock sync.Mutex
+
+	// The most recently rendered metrics
+	output string
+
+	// Have we completed a collection yet?
+	collected bool
+}
+
+// Arguments adds per-command args to the object.
+func (s *serveMetricsCommand) Arguments(f *flag.FlagSet) {
+	f.StringVar(&s.rolesPath, "roles", "", "Path to a list of roles to process, one by one")
+	f.StringVar(&s.listen, "listen", ":9100", "The address to listen upon")
+	f.DurationVar(&s.interval, "interval", 15*time.Minute, "How often to collect the metrics")
+}
+
+// Info returns the name of this subcommand.
+func (s *serveMetricsCommand) Info() (string, string) {
+	return "serve-metrics", `Expose inventory details as prometheus metrics.
+
+Details:
+
+This command launches a HTTP server which exposes metrics upon /metrics,
+in the format expected by prometheus.
+
+The metrics are collected periodically, across all the accounts in the
+role-file if one is specified, and include:
+
+* aws_utils_instances
+  The number of instances, by account, state, and type.
+* aws_utils_instance_ami_age_days
+  The age of the AMI each instance is running, in days.
+* aws_utils_subnets
+  The number of subnets, by account and VPC.
+* aws_utils_stacks
+  The number of cloudformation stacks, by account and status.
+* aws_utils_route53_zones
+  The number of Route53 zones, by account and status (valid, orphan,
+  or error).
+* aws_utils_collection_errors
+  The number of errors encountered in the most recent collection.
+* aws_utils_collection_timestamp_seconds
+  The time at which the most recent collection completed.
+
+Until the first collection has completed requests for /metrics receive
+a "503 Service Unavailable" response.
+
+For example:
+
+    $ aws-utils serve-metrics -roles=./roles -listen=:9100 -interval=15m
+`
+
+}
+
+// Collect is our callback method, which is invoked once for our main
+// account - if no roles-file is specified - or once for each assumed
+// role within that file.
+func (s *serveMetricsCommand) Collect(svc *ec2.EC2, account string, void interface{}) error {
+
+	m := void.(*metricSet)
+
+	// Instances, and their AMI ages
+	objs, err := instances.GetInstancesByState(svc, account, "pending", "running", "stopping", "stopped")
+	if err != nil {
+		return err
+	}
+	for _, obj := range objs {
+		m.add("aws_utils_instances", "The number of instances, by account, state, and type.", 1,
+			"account", account, "state", obj.InstanceState, "type", obj.InstanceType)
+
+		if obj.AMIAge >= 0 {
+			m.add("aws_utils_instance_ami_age_days", "The age of the AMI each instance is running, in days.",
+				float64(obj.AMIAge),
+				"account", account, "instance_id", obj.InstanceID, "name", obj.InstanceName, "ami", obj.InstanceAMI)
+		}
+	}
+
+	// Subnets
+	subnets, err := describeSubnets(svc)
+	if err != nil {
+		return fmt.Errorf("failed to get subnets for account %s: %s", account, err)
+	}
+	for _, subnet := range subnets {
+		m.add("aws_utils_subnets", "The number of subnets, by account and VPC.", 1,
+			"account", account, "vpc", aws.StringValue(subnet.VpcId))
+	}
+
+	// Stacks
+	stacks, err := listStacks(cloudformationClient(svc))
+	if err != nil {
+		return fmt.Errorf("failed to list stacks for account %s: %s", account, err)
+	}
+	for _, stack := range stacks {
+		m.add("aws_utils_stacks", "The number of cloudformation stacks, by account and status.", 1,
+			"account", account, "status", aws.StringValue(stack.StackStatus))
+	}
+
+	// Route53 zones
+	valid, orphan, failed, err := findOrphanedZones(route53Client(svc))
+	if err != nil {
+		return fmt.Errorf("failed to check Route53 zones for account %s: %s", account, err)
+	}
+	help := "The number of Route53 zones, by account and status."
+	m.add("aws_utils_route53_zones", help, float64(len(valid)), "account", account, "status", "valid")
+	m.add("aws_utils_route53_zones", help, float64(len(orphan)), "account", account, "status", "orphan")
+	m.add("aws_utils_route53_zones", help, float64(len(failed)), "account", account, "status", "error")
+
+	return nil
+}
+
+// collect runs a single collection, and saves the rendered metrics.
+func (s *serveMetricsCommand) collect() {
+
+	m := newMetricSet()
+
+	// Get the connection, using default credentials
+	session, err := utils.NewSession()
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		m.add("aws_utils_collection_errors", "The number of errors encountered in the most recent collection.", 1)
+		s.save(m)
+		return
+	}
+
+	// Collect from each account
+	errs := utils.HandleRoles(session, s.rolesPath, s.Collect, m)
+
+	for _, err := range errs {
+		fmt.Printf("%s\n", err)
+	}
+
+	m.add("aws_utils_collection_errors", "The number of errors encountered in the most recent collection.", float64(len(errs)))
+	m.add("aws_utils_collection_timestamp_seconds", "The time at which the most recent collection completed.", float64(time.Now().Unix()))
+	s.save(m)
+}
+
+// save stores the rendered metrics, for serving.
+func (s *serveMetricsCommand) save(m *metricSet) {
+	s.lock.Lock()
+	s.output = m.String()
+	s.collected = true
+	s.lock.Unlock()
+}
+
+// ServeHTTP serves the most recently collected metrics.
+//
+// Until the first collection has completed we return an error, rather
+// than an empty set of metrics, so that prometheus doesn't record a
+// successful scrape without any samples.
+func (s *serveMetricsCommand) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	out, collected := s.output, s.collected
+	s.lock.Unlock()
+
+	if !collected {
+		http.Error(w, "metrics have not been collected yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, out)
+}
+
+// Execute is invoked if the user specifies this subcommand.
+func (s *serveMetricsCommand) Execute(args []string) int {
+
+	if s.interval < time.Minute {
+		fmt.Printf("the collection interval must be at least one minute\n")
+		return 1
+	}
+
+	// Collect the metrics in the background, forever.
+	go func() {
+		for {
+			s.collect()
+			time.Sleep(s.interval)
+		}
+	}()
+
+	http.Handle("/metrics", s)
+
+	fmt.Printf("Serving metrics on http://%s/metrics\n", s.listen)
+	err := http.ListenAndServe(s.listen, nil)
+	if err != nil {
+		fmt.Printf("error running server: %s\n", err)
+		return 1
+	}
+
+	return 0
+}
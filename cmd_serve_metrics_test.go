@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestMetricSet tests rendering metrics in the prometheus format.
+func TestMetricSet(t *testing.T) {
+
+	m := newMetricSet()
+	m.add("b_total", "The b metric.", 1, "account", "2", "state", "running")
+	m.add("a_total", "The a metric.", 3)
+	m.add("b_total", "The b metric.", 1, "account", "1", "state", "running")
+	m.add("b_total", "The b metric.", 1, "account", "2", "state", "running")
+	m.add("c_info", "The c metric.", 1, "name", `say "hi"\now`+"\nbye")
+
+	expected := `# HELP a_total The a metric.
+# TYPE a_total gauge
+a_total 3
+# HELP b_total The b metric.
+# TYPE b_total gauge
+b_total{account="1",state="running"} 1
+b_total{account="2",state="running"} 2
+# HELP c_info The c metric.
+# TYPE c_info gauge
+c_info{name="say \"hi\"\\now\nbye"} 1
+`
+
+	if m.String() != expected {
+		t.Errorf("unexpected output, got:\n%s\nexpected:\n%s", m.String(), expected)
+	}
+
+	if newMetricSet().String() != "" {
+		t.Errorf("empty set rendered output")
+	}
+}
+
+// TestServeMetrics tests serving metrics, before and after collection.
+func TestServeMetrics(t *testing.T) {
+
+	type TestCase struct {
+		Collected bool
+		Status    int
+		Body      string
+	}
+
+	tests := []TestCase{
+		{false, http.StatusServiceUnavailable, "not been collected"},
+		{true, http.StatusOK, "aws_utils_collection_errors 0"},
+	}
+
+	for _, test := range tests {
+
+		s := &serveMetricsCommand{}
+		if test.Collected {
+			m := newMetricSet()
+			m.add("aws_utils_collection_errors", "Errors.", 0)
+			s.save(m)
+		}
+
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+		if rec.Code != test.Status {
+			t.Errorf("expected status %d, got %d", test.Status, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), test.Body) {
+			t.Errorf("expected body to contain %s, got %s", test.Body, rec.Body.String())
+		}
+	}
+}
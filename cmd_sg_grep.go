@@ -112,13 +112,13 @@ func (sg *sgGrepCommand) searchTerm(svc *ec2.EC2, account string, term string) e
 	}
 
 	// Retrieve the security groups
-	result, err := svc.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{})
+	groups, err := describeSecurityGroups(svc)
 	if err != nil {
 		return fmt.Errorf("unable to get security-groups %s", err)
 	}
 
 	// For each security-group we find.
-	for _, group := range result.SecurityGroups {
+	for _, group := range groups {
 
 		// Get the contents as a string.
 		txt := group.String()
@@ -140,3 +140,16 @@ func (sg *sgGrepCommand) searchTerm(svc *ec2.EC2, account string, term string) e
 
 	return nil
 }
+
+// describeSecurityGroups returns all the security-groups within the account.
+func describeSecurityGroups(svc *ec2.EC2) ([]*ec2.SecurityGroup, error) {
+
+	ret := []*ec2.SecurityGroup{}
+
+	err := svc.DescribeSecurityGroupsPages(&ec2.DescribeSecurityGroupsInput{}, func(page *ec2.DescribeSecurityGroupsOutput, lastPage bool) bool {
+		ret = append(ret, page.SecurityGroups...)
+		return true
+	})
+
+	return ret, err
+}
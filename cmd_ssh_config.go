@@ -0,0 +1,296 @@
+// Generate SSH configuration for our running instances.
+//
+// Primarily written to avoid "ssh $(aws-utils ip foo)".
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/skx/aws-utils/instances"
+	"github.com/skx/aws-utils/utils"
+)
+
+// amiOwnerUsers maps the account IDs of well-known AMI publishers to the
+// default login user of their images.
+var amiOwnerUsers = map[string]string{
+	"099720109477": "ubuntu",   // Canonical
+	"136693071363": "admin",    // Debian
+	"137112412989": "ec2-user", // Amazon Linux
+	"125523088429": "centos",   // CentOS
+	"309956199498": "ec2-user", // Red Hat
+	"013907871322": "ec2-user", // SUSE
+}
+
+// sshUser returns the login user for the given instance, based upon the
+// owner of its AMI and its platform.
+func sshUser(obj instances.InstanceOutput, fallback string) string {
+
+	if strings.Contains(strings.ToLower(obj.Platform), "windows") {
+		return "Administrator"
+	}
+	if user, ok := amiOwnerUsers[obj.AMIOwner]; ok {
+		return user
+	}
+	return fallback
+}
+
+// hostAlias returns a name suitable for use as an SSH host alias.
+func hostAlias(name string) string {
+	return regexp.MustCompile(`[\s*?!]+`).ReplaceAllString(name, "-")
+}
+
+// findBastions returns the bastion host of each VPC, keyed by VPC ID.
+//
+// A bastion is an instance with a public IPv4 address, whose name matches
+// the given pattern.  If there are several within a VPC the one with the
+// lowest name is chosen.
+func findBastions(objs []instances.InstanceOutput, pattern string) (map[string]instances.InstanceOutput, error) {
+
+	ret := make(map[string]instances.InstanceOutput)
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return ret, fmt.Errorf("unable to compile regexp %s - %s", pattern, err)
+	}
+
+	for _, obj := range objs {
+		if obj.PublicIPv4 == "" || !re.MatchString(obj.InstanceName) {
+			continue
+		}
+		cur, ok := ret[obj.VPCID]
+		if !ok || obj.InstanceName < cur.InstanceName {
+			ret[obj.VPCID] = obj
+		}
+	}
+	return ret, nil
+}
+
+// Structure for our options and state.
+type sshConfigCommand struct {
+
+	// Path to a file containing roles
+	rolesPath string
+
+	// Use public addresses?
+	public bool
+
+	// Default user, if the AMI isn't recognized
+	user string
+
+	// Format string for the IdentityFile, "%s" is replaced by the key-name
+	identity string
+
+	// Pattern matching bastion hosts, if any
+	bastion string
+
+	// Prefix for each host alias
+	prefix string
+
+	// Path of a file to update, rather than printing to STDOUT
+	writePath string
+
+	// Filtering and sorting options
+	instanceSelection
+
+	// The instances we've found, across all accounts
+	instanceCollector
+}
+
+// Arguments adds per-command args to the object.
+func (s *sshConfigCommand) Arguments(f *flag.FlagSet) {
+	f.StringVar(&s.rolesPath, "roles", "", "Path to a list of roles to process, one by one")
+	f.BoolVar(&s.public, "public", false, "Connect to the public IPv4 address of each instance")
+	f.StringVar(&s.user, "user", "ec2-user", "The user to use when the AMI owner isn't recognized")
+	f.StringVar(&s.identity, "identity", "~/.ssh/%s.pem", "The IdentityFile to use, '%s' is replaced with the instance key-name")
+	f.StringVar(&s.bastion, "bastion", "", "A regular expression matching the names of bastion hosts, used as a ProxyJump within their VPC")
+	f.StringVar(&s.prefix, "prefix", "", "A prefix to add to each host alias")
+	f.StringVar(&s.writePath, "write", "", "Update a managed block within the given file, such as ~/.ssh/config")
+	s.selectionArguments(f)
+}
+
+// Info returns the name of this subcommand.
+func (s *sshConfigCommand) Info() (string, string) {
+	return "ssh-config", `Generate SSH configuration for running instances.
+
+Details:
+
+This command outputs a "Host" stanza for each running instance, allowing
+you to connect to it by name:
+
+    $ aws-utils ssh-config
+    Host prod-web-1
+        HostName 10.12.43.120
+        User ubuntu
+        IdentityFile ~/.ssh/sysadmin.pem
+    ..
+
+The user is chosen by the owner of the AMI the instance is running, so
+Ubuntu instances use "ubuntu", Debian instances use "admin", and so on.
+If the AMI isn't recognized the value of '-user' is used.
+
+If your instances are only reachable via a bastion host you may specify
+a regular expression matching the names of your bastions, via '-bastion'.
+Within each VPC containing a bastion the other hosts will be configured
+to use it as a "ProxyJump", and the bastion itself will be reached via
+its public address.
+
+Rather than printing the configuration you may update a managed block
+within an existing file, which is safe to repeat:
+
+    $ aws-utils ssh-config -roles=./roles -bastion=bastion -write ~/.ssh/config
+` + selectionHelp
+
+}
+
+// Config generates the SSH configuration for the given instances.
+func (s *sshConfigCommand) Config(objs []instances.InstanceOutput) (string, error) {
+
+	bastions := make(map[string]instances.InstanceOutput)
+	if s.bastion != "" {
+		var err error
+		bastions, err = findBastions(objs, s.bastion)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Count the aliases, so duplicates can be made unique.
+	seen := make(map[string]int)
+	for _, obj := range objs {
+		seen[hostAlias(obj.InstanceName)]++
+	}
+	alias := func(obj instances.InstanceOutput) string {
+		name := hostAlias(obj.InstanceName)
+		if seen[name] > 1 {
+			name = name + "-" + obj.InstanceID
+		}
+		return s.prefix + name
+	}
+
+	var out strings.Builder
+	for _, obj := range objs {
+
+		// Choose the address
+		addr := obj.PrivateIPv4
+		if s.public {
+			addr = obj.PublicIPv4
+		}
+
+		// Is there a bastion we should jump via?
+		jump := ""
+		if b, ok := bastions[obj.VPCID]; ok {
+			if b.InstanceID == obj.InstanceID {
+				addr = obj.PublicIPv4
+			} else if !s.public {
+				jump = alias(b)
+			}
+		}
+
+		if addr == "" {
+			continue
+		}
+
+		out.WriteString(fmt.Sprintf("Host %s\n", alias(obj)))
+		out.WriteString(fmt.Sprintf("    HostName %s\n", addr))
+		out.WriteString(fmt.Sprintf("    User %s\n", sshUser(obj, s.user)))
+		if obj.SSHKeyName != "" && s.identity != "" {
+			out.WriteString(fmt.Sprintf("    IdentityFile %s\n", strings.ReplaceAll(s.identity, "%s", obj.SSHKeyName)))
+		}
+		if jump != "" {
+			out.WriteString(fmt.Sprintf("    ProxyJump %s\n", jump))
+		}
+		out.WriteString("\n")
+	}
+
+	return out.String(), nil
+}
+
+// expandHome replaces a leading "~/" with the home-directory of the user.
+func expandHome(path string) (string, error) {
+
+	if !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path, fmt.Errorf("failed to find home directory: %s", err)
+	}
+	return filepath.Join(home, path[2:]), nil
+}
+
+// Execute is invoked if the user specifies this subcommand.
+func (s *sshConfigCommand) Execute(args []string) int {
+
+	// Parse the filter-expression and sort-keys
+	err := s.parseSelection()
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	//
+	// Get the connection, using default credentials
+	//
+	session, err := utils.NewSession()
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+
+	//
+	// Now invoke our callback - this will call the function
+	// "CollectInstances" once if we're not running with a role-file,
+	// otherwise once for each role.
+	//
+	errs := utils.HandleRoles(session, s.rolesPath, s.CollectInstances, nil)
+	if len(errs) > 0 {
+		fmt.Printf("errors running ssh-config\n")
+		for _, err := range errs {
+			fmt.Printf("%s\n", err)
+		}
+		return 1
+	}
+
+	// Sort by name by default, so output is stable.
+	ret := s.results
+	sortByName(ret)
+
+	ret, err = s.applySelection(ret)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	config, err := s.Config(ret)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	// Just print it?
+	if s.writePath == "" {
+		fmt.Print(config)
+		return 0
+	}
+
+	path, err := expandHome(s.writePath)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	err = utils.UpdateManagedBlock(path, "aws-utils ssh-config", config)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/skx/aws-utils/instances"
+)
+
+// TestSSHUser tests choosing the login user of instances.
+func TestSSHUser(t *testing.T) {
+
+	type TestCase struct {
+		Owner    string
+		Platform string
+		Result   string
+	}
+
+	tests := []TestCase{
+		{"099720109477", "Linux/UNIX", "ubuntu"},
+		{"136693071363", "Linux/UNIX", "admin"},
+		{"137112412989", "Linux/UNIX", "ec2-user"},
+		{"125523088429", "Linux/UNIX", "centos"},
+		{"099720109477", "Windows", "Administrator"},
+		{"", "windows", "Administrator"},
+
+		// Unknown owners, including the AWS Marketplace, use the fallback
+		{"679593333241", "Linux/UNIX", "fallback"},
+		{"123456789012", "Linux/UNIX", "fallback"},
+		{"", "", "fallback"},
+	}
+
+	for _, test := range tests {
+
+		obj := instances.InstanceOutput{AMIOwner: test.Owner, Platform: test.Platform}
+		out := sshUser(obj, "fallback")
+		if out != test.Result {
+			t.Errorf("%s/%s: expected %s, got %s", test.Owner, test.Platform, test.Result, out)
+		}
+	}
+}
+
+// TestSSHConfig tests generating the configuration for instances.
+func TestSSHConfig(t *testing.T) {
+
+	objs := []instances.InstanceOutput{
+		{InstanceID: "i-1", InstanceName: "bastion", VPCID: "vpc-1", PrivateIPv4: "10.0.0.1", PublicIPv4: "3.3.3.3", AMIOwner: "099720109477", SSHKeyName: "ops"},
+		{InstanceID: "i-2", InstanceName: "web 1", VPCID: "vpc-1", PrivateIPv4: "10.0.0.2", SSHKeyName: "ops"},
+		{InstanceID: "i-3", InstanceName: "db", VPCID: "vpc-2", PrivateIPv4: "10.1.0.3", PublicIPv4: "4.4.4.4"},
+		{InstanceID: "i-4", InstanceName: "db", VPCID: "vpc-2", PrivateIPv4: "10.1.0.4"},
+	}
+
+	type TestCase struct {
+		Name   string
+		Cmd    sshConfigCommand
+		Result string
+	}
+
+	tests := []TestCase{
+		{"defaults",
+			sshConfigCommand{user: "ec2-user", identity: "~/.ssh/%s.pem"},
+			`Host bastion
+    HostName 10.0.0.1
+    User ubuntu
+    IdentityFile ~/.ssh/ops.pem
+
+Host web-1
+    HostName 10.0.0.2
+    User ec2-user
+    IdentityFile ~/.ssh/ops.pem
+
+Host db-i-3
+    HostName 10.1.0.3
+    User ec2-user
+
+Host db-i-4
+    HostName 10.1.0.4
+    User ec2-user
+
+`},
+		{"public addresses, with a prefix",
+			sshConfigCommand{user: "admin", public: true, prefix: "aws-"},
+			`Host aws-bastion
+    HostName 3.3.3.3
+    User ubuntu
+
+Host aws-db-i-3
+    HostName 4.4.4.4
+    User admin
+
+`},
+		{"bastion",
+			sshConfigCommand{user: "ec2-user", bastion: "^bastion$"},
+			`Host bastion
+    HostName 3.3.3.3
+    User ubuntu
+
+Host web-1
+    HostName 10.0.0.2
+    User ec2-user
+    ProxyJump bastion
+
+Host db-i-3
+    HostName 10.1.0.3
+    User ec2-user
+
+Host db-i-4
+    HostName 10.1.0.4
+    User ec2-user
+
+`},
+		{"bastion with a prefix",
+			sshConfigCommand{user: "ec2-user", bastion: "bastion", prefix: "p-"},
+			`Host p-bastion
+    HostName 3.3.3.3
+    User ubuntu
+
+Host p-web-1
+    HostName 10.0.0.2
+    User ec2-user
+    ProxyJump p-bastion
+
+Host p-db-i-3
+    HostName 10.1.0.3
+    User ec2-user
+
+Host p-db-i-4
+    HostName 10.1.0.4
+    User ec2-user
+
+`},
+	}
+
+	for _, test := range tests {
+
+		out, err := test.Cmd.Config(objs)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.Name, err)
+			continue
+		}
+		if out != test.Result {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.Name, test.Result, out)
+		}
+	}
+
+	// A bogus bastion pattern is an error
+	s := sshConfigCommand{bastion: "[bogus"}
+	if _, err := s.Config(objs); err == nil {
+		t.Errorf("expected error with a bogus bastion pattern")
+	}
+}
@@ -115,17 +115,11 @@ func (sc *stacksCommand) Execute(args []string) int {
 // role within that file.
 func (sc *stacksCommand) DisplayStacks(svc *ec2.EC2, account string, void interface{}) error {
 
-	// Setup a session
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
-
 	// Get the cloudformation service
-	cf := cloudformation.New(sess)
-	input := &cloudformation.ListStacksInput{StackStatusFilter: []*string{}}
+	cf := cloudformationClient(svc)
 
 	// List the stacks
-	resp, err := cf.ListStacks(input)
+	stacks, err := listStacks(cf)
 	if err != nil {
 		return err
 	}
@@ -138,7 +132,7 @@ func (sc *stacksCommand) DisplayStacks(svc *ec2.EC2, account string, void interf
 
 	// Get all the stacks, and save their names/statuses in
 	// a lookup table.
-	for _, ent := range resp.StackSummaries {
+	for _, ent := range stacks {
 
 		// Get the nam/status
 		name := *ent.StackName
@@ -231,3 +225,30 @@ func (sc *stacksCommand) DisplayStacks(svc *ec2.EC2, account string, void interf
 
 	return nil
 }
+
+// cloudformationClient returns a cloudformation client which uses the
+// same credentials as the given EC2 client, so that assumed roles are
+// respected.
+func cloudformationClient(svc *ec2.EC2) *cloudformation.CloudFormation {
+
+	// Setup a session
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	return cloudformation.New(sess, &aws.Config{Credentials: svc.Config.Credentials})
+}
+
+// listStacks returns the summaries of all stacks, including deleted ones.
+func listStacks(cf *cloudformation.CloudFormation) ([]*cloudformation.StackSummary, error) {
+
+	ret := []*cloudformation.StackSummary{}
+
+	input := &cloudformation.ListStacksInput{StackStatusFilter: []*string{}}
+	err := cf.ListStacksPages(input, func(page *cloudformation.ListStacksOutput, lastPage bool) bool {
+		ret = append(ret, page.StackSummaries...)
+		return true
+	})
+
+	return ret, err
+}
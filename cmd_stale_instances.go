@@ -0,0 +1,255 @@
+// Show instances which have been stopped for a long time.
+//
+// Primarily written to find forgotten instances, which still cost money
+// via their volumes and elastic IPs.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skx/aws-utils/instances"
+	"github.com/skx/aws-utils/utils"
+)
+
+// staleInstance is the structure we output for each stopped instance.
+type staleInstance struct {
+	Account     string     `json:"account"`
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Type        string     `json:"type"`
+	StoppedAt   *time.Time `json:"stopped_at,omitempty"`
+	StoppedDays int        `json:"stopped_days"`
+	Reason      string     `json:"reason"`
+	EBSGiB      int        `json:"ebs_gib"`
+	ElasticIPs  []string   `json:"elastic_ips"`
+}
+
+// parseAge parses an age such as "30d" or "2w".
+//
+// A number without a suffix is treated as a number of days.
+func parseAge(input string) (time.Duration, error) {
+
+	day := 24 * time.Hour
+
+	str := strings.TrimSpace(input)
+	if str == "" {
+		return 0, nil
+	}
+
+	mult := day
+	switch {
+	case strings.HasSuffix(str, "d"):
+		str = strings.TrimSuffix(str, "d")
+	case strings.HasSuffix(str, "w"):
+		mult = 7 * day
+		str = strings.TrimSuffix(str, "w")
+	}
+
+	n, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid age '%s', expected a number of days or weeks, e.g. '30d' or '2w'", input)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("age '%s' must not be negative", input)
+	}
+	return time.Duration(n) * mult, nil
+}
+
+// Structure for our options and state.
+type staleInstancesCommand struct {
+
+	// Path to a file containing roles
+	rolesPath string
+
+	// Only show instances stopped for longer than this
+	olderThan string
+
+	// Should we export our results in JSON format?
+	jsonOutput bool
+
+	// The parsed minimum age
+	age time.Duration
+
+	// The instances we've found, across all accounts
+	instanceCollector
+}
+
+// Arguments adds per-command args to the object.
+func (s *staleInstancesCommand) Arguments(f *flag.FlagSet) {
+	f.StringVar(&s.rolesPath, "roles", "", "Path to a list of roles to process, one by one")
+	f.StringVar(&s.olderThan, "older-than", "", "Only show instances stopped for longer than this, e.g. '30d' or '2w'")
+	f.BoolVar(&s.jsonOutput, "json", false, "Output the results in JSON.")
+}
+
+// Info returns the name of this subcommand.
+func (s *staleInstancesCommand) Info() (string, string) {
+	return "stale-instances", `Show instances which have been stopped.
+
+Details:
+
+Stopped instances still cost money, via their EBS volumes and elastic
+IPs.  This command reports the stopped instances, along with how long
+they've been stopped, the total size of their volumes, and any elastic
+IPs associated with them:
+
+    $ aws-utils stale-instances -roles=./roles -older-than=30d
+    Account       ID                   Name          Type      Stopped   EBS GiB  Elastic IPs
+    123456789012  i-01234567890abcdef  old-build-1   m5.large  412 days  500      3.120.45.67
+
+The age given to '-older-than' is a number of days, or weeks, such as
+"30d" or "2w", and a number without a suffix is a number of days.
+
+The time at which an instance was stopped is found within its state
+transition reason.  If that cannot be parsed the instance is always
+reported, with an unknown age, after the others.
+
+Instances are sorted by the length of time they've been stopped, with the
+oldest first, and a summary of the totals is shown after them.
+`
+
+}
+
+// Stale converts the given instances to the structure we report, removing
+// those which haven't been stopped for long enough, and sorting them.
+func (s *staleInstancesCommand) Stale(objs []instances.InstanceOutput, now time.Time) []staleInstance {
+
+	ret := []staleInstance{}
+
+	for _, obj := range objs {
+
+		ent := staleInstance{
+			Account:    obj.AWSAccount,
+			ID:         obj.InstanceID,
+			Name:       obj.InstanceName,
+			Type:       obj.InstanceType,
+			Reason:     obj.StateReason,
+			EBSGiB:     obj.EBSGiB(),
+			ElasticIPs: []string{},
+		}
+
+		for _, eni := range obj.NetworkInterfaces {
+			for _, addr := range eni.Addresses {
+				if addr.AllocationID != "" {
+					ent.ElasticIPs = append(ent.ElasticIPs, addr.Public)
+				}
+			}
+		}
+
+		if !obj.StoppedAt.IsZero() {
+			stopped := obj.StoppedAt
+			ent.StoppedAt = &stopped
+			ent.StoppedDays = int(now.Sub(obj.StoppedAt).Hours() / 24)
+
+			if now.Sub(obj.StoppedAt) < s.age {
+				continue
+			}
+		}
+
+		ret = append(ret, ent)
+	}
+
+	// Oldest first, with unknown ages last.
+	sort.SliceStable(ret, func(i, j int) bool {
+		a, b := ret[i].StoppedAt, ret[j].StoppedAt
+		if (a == nil) != (b == nil) {
+			return a != nil
+		}
+		if a != nil && !a.Equal(*b) {
+			return a.Before(*b)
+		}
+		return ret[i].Name < ret[j].Name
+	})
+
+	return ret
+}
+
+// Report outputs the given stale instances, along with a summary.
+func (s *staleInstancesCommand) Report(ret []staleInstance) error {
+
+	if s.jsonOutput {
+		b, err := json.MarshalIndent(ret, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error exporting to JSON %s", err)
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	if len(ret) == 0 {
+		fmt.Printf("No stale instances found.\n")
+		return nil
+	}
+
+	ebs := 0
+	eips := 0
+
+	fmt.Printf("%-13s %-20s %-30s %-12s %-10s %-8s %s\n", "Account", "ID", "Name", "Type", "Stopped", "EBS GiB", "Elastic IPs")
+	for _, ent := range ret {
+
+		stopped := "unknown"
+		if ent.StoppedAt != nil {
+			stopped = fmt.Sprintf("%d days", ent.StoppedDays)
+		}
+
+		line := fmt.Sprintf("%-13s %-20s %-30s %-12s %-10s %-8d %s", ent.Account, ent.ID, ent.Name, ent.Type, stopped, ent.EBSGiB, strings.Join(ent.ElasticIPs, " "))
+		fmt.Printf("%s\n", strings.TrimRight(line, " "))
+
+		ebs += ent.EBSGiB
+		eips += len(ent.ElasticIPs)
+	}
+
+	fmt.Printf("\n%d stopped instances, with %d GiB of EBS storage and %d elastic IPs.\n", len(ret), ebs, eips)
+	return nil
+}
+
+// Execute is invoked if the user specifies this subcommand.
+func (s *staleInstancesCommand) Execute(args []string) int {
+
+	var err error
+	s.age, err = parseAge(s.olderThan)
+	if err != nil {
+		fmt.Printf("invalid -older-than: %s\n", err)
+		return 1
+	}
+
+	//
+	// Get the connection, using default credentials
+	//
+	session, err := utils.NewSession()
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+
+	// Only stopped instances are stale.
+	s.states = []string{"stopped"}
+
+	//
+	// Now invoke our callback - this will call the function
+	// "CollectInstances" once if we're not running with a role-file,
+	// otherwise once for each role.
+	//
+	errs := utils.HandleRoles(session, s.rolesPath, s.CollectInstances, nil)
+
+	err = s.Report(s.Stale(s.results, time.Now()))
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		fmt.Printf("errors running stale-instances\n")
+		for _, err := range errs {
+			fmt.Printf("%s\n", err)
+		}
+		return 1
+	}
+
+	return 0
+}
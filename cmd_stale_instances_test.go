@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skx/aws-utils/instances"
+)
+
+// TestParseAge tests parsing the ages given to -older-than.
+func TestParseAge(t *testing.T) {
+
+	day := 24 * time.Hour
+
+	type TestCase struct {
+		Input  string
+		Output time.Duration
+		Error  string
+	}
+
+	tests := []TestCase{
+		{"", 0, ""},
+		{"30d", 30 * day, ""},
+		{" 30d ", 30 * day, ""},
+		{"2w", 14 * day, ""},
+		{"7", 7 * day, ""},
+		{"0", 0, ""},
+
+		// Errors echo the input, including its suffix
+		{"xd", 0, "invalid age 'xd'"},
+		{"1.5w", 0, "invalid age '1.5w'"},
+		{"bogus", 0, "invalid age 'bogus'"},
+		{"-3d", 0, "age '-3d' must not be negative"},
+
+		// Only days and weeks are supported
+		{"36h", 0, "invalid age '36h'"},
+		{"90m", 0, "invalid age '90m'"},
+		{"h", 0, "invalid age 'h'"},
+		{"d", 0, "invalid age 'd'"},
+		{"3dd", 0, "invalid age '3dd'"},
+	}
+
+	for _, test := range tests {
+
+		out, err := parseAge(test.Input)
+		if test.Error != "" {
+			if err == nil {
+				t.Errorf("expected error parsing '%s', got none", test.Input)
+			} else if !strings.Contains(err.Error(), test.Error) {
+				t.Errorf("%s: expected error %q, got %q", test.Input, test.Error, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error parsing '%s': %s", test.Input, err)
+			continue
+		}
+		if out != test.Output {
+			t.Errorf("%s: expected %s, got %s", test.Input, test.Output, out)
+		}
+	}
+}
+
+// TestStale tests selecting and sorting stopped instances.
+func TestStale(t *testing.T) {
+
+	now := time.Date(2021, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	stopped := func(id string, reason string) instances.InstanceOutput {
+		at, _ := instances.ParseStateReason(reason)
+		return instances.InstanceOutput{InstanceID: id, InstanceName: id, StateReason: reason, StoppedAt: at}
+	}
+
+	objs := []instances.InstanceOutput{
+		stopped("recent", "User initiated (2021-06-29 12:00:00 GMT)"),
+		stopped("month", "User initiated (2021-05-30 12:00:00 GMT)"),
+		stopped("unknown", "Server.ScheduledStop"),
+		stopped("year", "User initiated (2020-06-30 12:00:00 GMT)"),
+	}
+
+	type TestCase struct {
+		Age    time.Duration
+		Result string
+	}
+
+	tests := []TestCase{
+		{0, "year,month,recent,unknown"},
+		{24 * time.Hour, "year,month,recent,unknown"},
+		{2 * 24 * time.Hour, "year,month,unknown"},
+		{31 * 24 * time.Hour, "year,month,unknown"},
+		{32 * 24 * time.Hour, "year,unknown"},
+		{400 * 24 * time.Hour, "unknown"},
+	}
+
+	for _, test := range tests {
+
+		s := &staleInstancesCommand{age: test.Age}
+		ids := []string{}
+		for _, ent := range s.Stale(objs, now) {
+			ids = append(ids, ent.ID)
+		}
+
+		if strings.Join(ids, ",") != test.Result {
+			t.Errorf("%s: expected %s, got %s", test.Age, test.Result, strings.Join(ids, ","))
+		}
+	}
+}
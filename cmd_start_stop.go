@@ -0,0 +1,341 @@
+// Start, stop, or reboot instances, selected by name or tag.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/skx/aws-utils/instances"
+	"github.com/skx/aws-utils/utils"
+)
+
+// instanceAction describes one of the actions we can perform.
+type instanceAction struct {
+
+	// The states instances must be in for the action to apply
+	from []string
+
+	// Description of the state we wait for, for messages
+	to string
+
+	// Description of the action in progress, for messages
+	doing string
+
+	// Perform the action upon the given instances
+	run func(svc *ec2.EC2, ids []*string) error
+
+	// Wait for the given instances to reach the target state
+	wait func(ctx aws.Context, svc *ec2.EC2, ids []*string, opts ...request.WaiterOption) error
+}
+
+// instanceActions are the actions we support, keyed by sub-command name.
+var instanceActions = map[string]instanceAction{
+	"start": {
+		from:  []string{"stopped"},
+		to:    "running",
+		doing: "starting",
+		run: func(svc *ec2.EC2, ids []*string) error {
+			_, err := svc.StartInstances(&ec2.StartInstancesInput{InstanceIds: ids})
+			return err
+		},
+		wait: func(ctx aws.Context, svc *ec2.EC2, ids []*string, opts ...request.WaiterOption) error {
+			return svc.WaitUntilInstanceRunningWithContext(ctx, &ec2.DescribeInstancesInput{InstanceIds: ids}, opts...)
+		},
+	},
+	"stop": {
+		from:  []string{"running"},
+		to:    "stopped",
+		doing: "stopping",
+		run: func(svc *ec2.EC2, ids []*string) error {
+			_, err := svc.StopInstances(&ec2.StopInstancesInput{InstanceIds: ids})
+			return err
+		},
+		wait: func(ctx aws.Context, svc *ec2.EC2, ids []*string, opts ...request.WaiterOption) error {
+			return svc.WaitUntilInstanceStoppedWithContext(ctx, &ec2.DescribeInstancesInput{InstanceIds: ids}, opts...)
+		},
+	},
+	"reboot": {
+		from:  []string{"running"},
+		to:    "passing their status checks",
+		doing: "rebooting",
+		run: func(svc *ec2.EC2, ids []*string) error {
+			_, err := svc.RebootInstances(&ec2.RebootInstancesInput{InstanceIds: ids})
+			return err
+		},
+		wait: func(ctx aws.Context, svc *ec2.EC2, ids []*string, opts ...request.WaiterOption) error {
+			input := &ec2.DescribeInstanceStatusInput{InstanceIds: ids}
+			err := svc.WaitUntilInstanceStatusOkWithContext(ctx, input, opts...)
+			if err != nil {
+				return err
+			}
+			return svc.WaitUntilSystemStatusOkWithContext(ctx, input, opts...)
+		},
+	},
+}
+
+// Structure for our options and state.
+//
+// The same structure implements the "start", "stop", and "reboot"
+// sub-commands, depending upon the value of action.
+type instanceActionCommand struct {
+
+	// The name of the action, "start", "stop", or "reboot"
+	action string
+
+	// Path to a file containing roles
+	rolesPath string
+
+	// Don't prompt for confirmation?
+	force bool
+
+	// The number of instances to act upon at once
+	batch int
+
+	// Wait for the instances to reach their target state?
+	wait bool
+
+	// How long to wait for each batch
+	timeout time.Duration
+
+	// Name-matching options
+	instanceMatcher
+
+	// Filtering and sorting options
+	instanceSelection
+
+	// The instances we've found, across all accounts
+	instanceCollector
+}
+
+// Arguments adds per-command args to the object.
+func (a *instanceActionCommand) Arguments(f *flag.FlagSet) {
+	f.StringVar(&a.rolesPath, "roles", "", "Path to a list of roles to process, one by one")
+	f.BoolVar(&a.force, "force", false, "Don't prompt for confirmation")
+	f.IntVar(&a.batch, "batch", 10, "The number of instances to "+a.action+" at once")
+	f.BoolVar(&a.wait, "wait", false, "Wait for each batch of instances to reach the target state")
+	f.DurationVar(&a.timeout, "timeout", 10*time.Minute, "How long to wait for each batch, with '-wait'")
+	a.matcherArguments(f)
+	a.selectionArguments(f)
+}
+
+// Info returns the name of this subcommand.
+func (a *instanceActionCommand) Info() (string, string) {
+
+	act := instanceActions[a.action]
+
+	title := strings.ToUpper(a.action[:1]) + a.action[1:]
+
+	return a.action, title + ` instances, selected by name or tag.
+
+Details:
+
+This command finds the instances whose names match the given regular
+expressions, in the same way as the 'ip' command, and/or which have the
+tags given via '-tag', and ` + a.action + `s them:
+
+    $ aws-utils ` + a.action + ` -roles=./roles 'prod-web.*'
+    $ aws-utils ` + a.action + ` -tag Environment=staging -tag Role=worker
+
+Only instances which are ` + strings.Join(act.from, " or ") + ` are considered.
+
+The matching instances are shown, and you must enter 'OK' to continue,
+unless you add '-force'.
+
+Instances are processed in batches of ten, which may be changed via
+'-batch'.  AWS acts upon instances in the background, so by default we
+don't wait for them to finish ` + act.doing + `.  If you add '-wait' the next
+batch isn't processed until the instances of the previous one are
+` + act.to + `.
+` + rebootNote(a.action) + selectionHelp
+
+}
+
+// rebootNote returns a caveat about waiting for rebooted instances, which
+// is only relevant to the reboot action.
+func rebootNote(action string) string {
+	if action != "reboot" {
+		return ""
+	}
+	return `
+A reboot doesn't change the state of an instance, so when waiting the
+status checks may still be passing from before the reboot took effect.
+`
+}
+
+// confirm shows the instances we're going to act upon, and ensures the
+// user wishes to continue.
+func (a *instanceActionCommand) confirm(objs []instances.InstanceOutput) error {
+
+	for _, obj := range objs {
+		fmt.Printf("  %-13s %-20s %-30s %s\n", obj.AWSAccount, obj.InstanceID, obj.InstanceName, obj.InstanceState)
+	}
+	fmt.Printf("\n")
+
+	if a.force {
+		return nil
+	}
+
+	return confirm(fmt.Sprintf("Press Ctrl-C to cancel, or enter 'OK' (uppercase) to %s %d instances.\n", a.action, len(objs)))
+}
+
+// Perform acts upon the given instances, in batches.
+func (a *instanceActionCommand) Perform(objs []instances.InstanceOutput) error {
+
+	act := instanceActions[a.action]
+
+	size := a.batch
+	if size < 1 {
+		size = 1
+	}
+
+	for start := 0; start < len(objs); start += size {
+
+		end := start + size
+		if end > len(objs) {
+			end = len(objs)
+		}
+		batch := objs[start:end]
+
+		// Group the IDs by account, so we use the right client.
+		for acct, list := range a.instanceIDs(batch) {
+			err := act.run(a.clients[acct], list)
+			if err != nil {
+				return fmt.Errorf("failed to %s instances in %s: %s", a.action, acct, err)
+			}
+		}
+
+		fmt.Printf("Batch %d: %s %d instances\n", start/size+1, act.doing, len(batch))
+
+		if a.wait {
+			err := a.Wait(batch, act)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Wait uses the EC2 waiters to wait until the given instances have all
+// reached the target state of the given action, or our timeout expires.
+func (a *instanceActionCommand) Wait(objs []instances.InstanceOutput, act instanceAction) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+
+	// Poll every few seconds, until the timeout expires.
+	delay := 5 * time.Second
+	opts := []request.WaiterOption{
+		request.WithWaiterDelay(request.ConstantWaiterDelay(delay)),
+		request.WithWaiterMaxAttempts(int(a.timeout/delay) + 1),
+	}
+
+	begin := time.Now()
+	fmt.Printf("  waiting for %d instances to be %s\n", len(objs), act.to)
+
+	for acct, ids := range a.instanceIDs(objs) {
+		err := act.wait(ctx, a.clients[acct], ids, opts...)
+		if err != nil {
+			return fmt.Errorf("failed waiting for instances in %s to be %s: %s", acct, act.to, err)
+		}
+	}
+
+	fmt.Printf("  [%s] %d instances %s\n", time.Since(begin).Round(time.Second), len(objs), act.to)
+	return nil
+}
+
+// instanceIDs returns the IDs of the given instances, keyed by account.
+func (a *instanceActionCommand) instanceIDs(objs []instances.InstanceOutput) map[string][]*string {
+
+	ret := make(map[string][]*string)
+	for _, obj := range objs {
+		ret[obj.AWSAccount] = append(ret[obj.AWSAccount], aws.String(obj.InstanceID))
+	}
+	return ret
+}
+
+// Execute is invoked if the user specifies this subcommand.
+func (a *instanceActionCommand) Execute(args []string) int {
+
+	// Refuse to act upon every instance.
+	if len(args) == 0 && len(a.tags) == 0 && a.where == "" {
+		fmt.Printf("Usage: %s [flags] pattern1 pattern2 .. patternN\n", a.action)
+		fmt.Printf("\nAt least one pattern, '-tag', or '-where' is required.\n")
+		return 1
+	}
+
+	// Parse the filter-expression and sort-keys
+	err := a.parseSelection()
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	//
+	// Get the connection, using default credentials
+	//
+	session, err := utils.NewSession()
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+
+	// Only collect the instances our action applies to.
+	a.states = instanceActions[a.action].from
+
+	//
+	// Now invoke our callback - this will call the function
+	// "CollectInstances" once if we're not running with a role-file,
+	// otherwise once for each role.
+	//
+	errs := utils.HandleRoles(session, a.rolesPath, a.CollectInstances, nil)
+	if len(errs) > 0 {
+		fmt.Printf("errors running %s\n", a.action)
+		for _, err := range errs {
+			fmt.Printf("%s\n", err)
+		}
+		return 1
+	}
+
+	// Sort by name by default, so output is stable.
+	ret := a.results
+	sortByName(ret)
+
+	ret, err = a.applySelection(ret)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	ret, err = a.matchAny(ret, args)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	if len(ret) == 0 {
+		fmt.Printf("No matching instances found.\n")
+		return 0
+	}
+
+	err = a.confirm(ret)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	err = a.Perform(ret)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	return 0
+}
@@ -8,7 +8,7 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/skx/aws-utils/tag2name"
+	"github.com/skx/aws-utils/tags"
 	"github.com/skx/aws-utils/utils"
 )
 
@@ -18,6 +18,9 @@ type subnetsCommand struct {
 	// Path to a file containing roles
 	rolesPath string
 
+	// Tags to examine for the name of each resource
+	nameTags string
+
 	// show the header already?
 	header bool
 }
@@ -25,6 +28,7 @@ type subnetsCommand struct {
 // Arguments adds per-command args to the object.
 func (sc *subnetsCommand) Arguments(f *flag.FlagSet) {
 	f.StringVar(&sc.rolesPath, "roles", "", "Path to a list of roles to process, one by one")
+	f.StringVar(&sc.nameTags, "name-tags", "Name", "The tags to examine, in order, for the name of each resource, e.g. 'Name,aws:cloudformation:stack-name'")
 }
 
 // Info returns the name of this subcommand.
@@ -36,6 +40,9 @@ Details:
 This command allows you to list the names of all subnets, and their
 associated CIDR ranges.  All available VPCs will be exported in a
 simple CSV format, complete with header.
+
+Subnets are named by their "Name" tag, falling back to their ID.  You may
+examine other tags, in order, via '-name-tags'.
 `
 
 }
@@ -43,6 +50,9 @@ simple CSV format, complete with header.
 // Execute is invoked if the user specifies this sub-command.
 func (sc *subnetsCommand) Execute(args []string) int {
 
+	// Use the tags we were given to find names
+	tags.SetNameChain(sc.nameTags)
+
 	//
 	// Get the connection, using default credentials
 	//
@@ -76,15 +86,8 @@ func (sc *subnetsCommand) Execute(args []string) int {
 // role within that file.
 func (sc *subnetsCommand) DisplaySubnets(svc *ec2.EC2, account string, void interface{}) error {
 
-	// An empty filter, to get all subnets
-	input := &ec2.DescribeSubnetsInput{
-		Filters: []*ec2.Filter{
-			{},
-		},
-	}
-
 	// describe the subnets
-	result, err := svc.DescribeSubnets(input)
+	subnets, err := describeSubnets(svc)
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
@@ -100,18 +103,31 @@ func (sc *subnetsCommand) DisplaySubnets(svc *ec2.EC2, account string, void inte
 	}
 
 	// For each subnet
-	for i := range result.Subnets {
+	for i := range subnets {
 
 		// Get the name, via tags, if present
-		name := tag2name.Lookup(result.Subnets[i].Tags, "unnamed")
+		name := tags.Name(tags.FromEC2(subnets[i].Tags), *subnets[i].SubnetId)
 
 		// Show the details
 		if !sc.header {
 			fmt.Printf("Account, VPC, Subnet Name, Subnet ID, Cidr\n")
 			sc.header = true
 		}
-		fmt.Printf("%s,%s,%s,%s,%s\n", account, *result.Subnets[i].VpcId, name, *result.Subnets[i].SubnetId, *result.Subnets[i].CidrBlock)
+		fmt.Printf("%s,%s,%s,%s,%s\n", account, *subnets[i].VpcId, name, *subnets[i].SubnetId, *subnets[i].CidrBlock)
 	}
 
 	return nil
 }
+
+// describeSubnets returns all the subnets within the account.
+func describeSubnets(svc *ec2.EC2) ([]*ec2.Subnet, error) {
+
+	ret := []*ec2.Subnet{}
+
+	err := svc.DescribeSubnetsPages(&ec2.DescribeSubnetsInput{}, func(page *ec2.DescribeSubnetsOutput, lastPage bool) bool {
+		ret = append(ret, page.Subnets...)
+		return true
+	})
+
+	return ret, err
+}
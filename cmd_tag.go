@@ -0,0 +1,471 @@
+// Set or delete tags upon instances, and their volumes and interfaces.
+//
+// Primarily written to fix tags across many accounts.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/skx/aws-utils/instances"
+	"github.com/skx/aws-utils/utils"
+)
+
+// tagBatchSize is the number of resources we'll lookup, or change, with
+// a single API call.
+const tagBatchSize = 200
+
+// tagSets allows "Key=Value" pairs to be specified multiple times upon
+// the command-line.
+type tagSets []string
+
+// String is part of the flag.Value interface.
+func (t *tagSets) String() string {
+	return strings.Join(*t, ",")
+}
+
+// Set is part of the flag.Value interface.
+func (t *tagSets) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("tag should be of the form Key=Value, got '%s'", value)
+	}
+	*t = append(*t, value)
+	return nil
+}
+
+// tagKeys allows tag keys to be specified multiple times upon the
+// command-line.
+type tagKeys []string
+
+// String is part of the flag.Value interface.
+func (t *tagKeys) String() string {
+	return strings.Join(*t, ",")
+}
+
+// Set is part of the flag.Value interface.
+func (t *tagKeys) Set(value string) error {
+	if value == "" {
+		return fmt.Errorf("tag key cannot be empty")
+	}
+	*t = append(*t, value)
+	return nil
+}
+
+// tagResource is a resource whose tags we might change.
+type tagResource struct {
+
+	// Account the resource belongs to
+	Account string
+
+	// ID of the resource
+	ID string
+
+	// Description, for the preview
+	Description string
+
+	// Current tags
+	Before map[string]string
+
+	// Tags once our changes are applied
+	After map[string]string
+
+	// Will our changes alter the tags?
+	Changed bool
+}
+
+// Structure for our options and state.
+type tagCommand struct {
+
+	// Path to a file containing roles
+	rolesPath string
+
+	// Tags to set
+	set tagSets
+
+	// Tags to delete
+	delete tagKeys
+
+	// Include the volumes of the instances?
+	volumes bool
+
+	// Include the network interfaces of the instances?
+	enis bool
+
+	// Only show the changes?
+	dryRun bool
+
+	// Don't prompt for confirmation?
+	force bool
+
+	// Name-matching options
+	instanceMatcher
+
+	// Filtering and sorting options
+	instanceSelection
+
+	// The instances we've found, across all accounts
+	instanceCollector
+}
+
+// Arguments adds per-command args to the object.
+func (t *tagCommand) Arguments(f *flag.FlagSet) {
+	f.StringVar(&t.rolesPath, "roles", "", "Path to a list of roles to process, one by one")
+	f.Var(&t.set, "set", "A tag to set, as 'Key=Value', may be repeated")
+	f.Var(&t.delete, "delete", "The key of a tag to delete, may be repeated")
+	f.BoolVar(&t.volumes, "volumes", false, "Change the tags of the volumes attached to the instances too")
+	f.BoolVar(&t.enis, "enis", false, "Change the tags of the network interfaces attached to the instances too")
+	f.BoolVar(&t.dryRun, "dry-run", false, "Show the changes which would be made, without making them")
+	f.BoolVar(&t.force, "force", false, "Don't prompt for confirmation")
+	t.matcherArguments(f)
+	t.selectionArguments(f)
+}
+
+// Info returns the name of this subcommand.
+func (t *tagCommand) Info() (string, string) {
+	return "tag", `Set or delete the tags of instances.
+
+Details:
+
+This command finds the instances whose names match the given regular
+expressions, in the same way as the 'ip' command, and/or which have the
+tags given via '-tag', and changes their tags:
+
+    $ aws-utils tag -roles=./roles -set Environment=production 'prod-.*'
+    $ aws-utils tag -tag Team=old -set Team=new -delete Owner
+
+Add '-volumes' and '-enis' to change the tags of the attached volumes
+and network interfaces too.
+
+The tags of each resource are shown before and after the changes, and
+you must enter 'OK' to continue, unless you add '-force'.  If you add
+'-dry-run' the changes are shown, but not made.
+` + selectionHelp
+
+}
+
+// Resources returns the resources associated with the given instances,
+// whose tags we might change.
+func (t *tagCommand) Resources(objs []instances.InstanceOutput) []tagResource {
+
+	ret := []tagResource{}
+
+	for _, obj := range objs {
+		ret = append(ret, tagResource{Account: obj.AWSAccount, ID: obj.InstanceID, Description: obj.InstanceName})
+
+		if t.volumes {
+			for _, vol := range obj.Volumes {
+				ret = append(ret, tagResource{Account: obj.AWSAccount, ID: vol.ID, Description: obj.InstanceName + " " + vol.Device})
+			}
+		}
+		if t.enis {
+			for _, eni := range obj.NetworkInterfaces {
+				ret = append(ret, tagResource{Account: obj.AWSAccount, ID: eni.ID, Description: fmt.Sprintf("%s eth%d", obj.InstanceName, eni.DeviceIndex)})
+			}
+		}
+	}
+	return ret
+}
+
+// Describe finds the current tags of the given resources, in each account,
+// and the tags they'll have after our changes.
+func (t *tagCommand) Describe(res []tagResource) error {
+
+	ids := make(map[string][]string)
+	for _, r := range res {
+		ids[r.Account] = append(ids[r.Account], r.ID)
+	}
+
+	current := make(map[string]map[string]string)
+	for acct, list := range ids {
+		err := describeTags(t.clients[acct], list, current)
+		if err != nil {
+			return err
+		}
+	}
+
+	t.compare(res, current)
+	return nil
+}
+
+// compare updates the given resources with their current tags, keyed by
+// resource ID, and the tags they'll have after our changes.
+func (t *tagCommand) compare(res []tagResource, current map[string]map[string]string) {
+
+	for i := range res {
+		res[i].Before = current[res[i].ID]
+		if res[i].Before == nil {
+			res[i].Before = make(map[string]string)
+		}
+		res[i].After = t.apply(res[i].Before)
+		res[i].Changed = !reflect.DeepEqual(res[i].Before, res[i].After)
+	}
+}
+
+// apply returns a copy of the given tags, with our changes made.
+func (t *tagCommand) apply(tags map[string]string) map[string]string {
+
+	ret := make(map[string]string)
+	for k, v := range tags {
+		ret[k] = v
+	}
+	for _, key := range t.delete {
+		delete(ret, key)
+	}
+	for _, kv := range t.set {
+		parts := strings.SplitN(kv, "=", 2)
+		ret[parts[0]] = parts[1]
+	}
+	return ret
+}
+
+// describeTags adds the tags of the given resources to the given map,
+// keyed by resource ID.
+func describeTags(svc *ec2.EC2, ids []string, ret map[string]map[string]string) error {
+
+	for start := 0; start < len(ids); start += tagBatchSize {
+
+		end := start + tagBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		input := &ec2.DescribeTagsInput{
+			Filters: []*ec2.Filter{
+				{
+					Name:   aws.String("resource-id"),
+					Values: aws.StringSlice(ids[start:end]),
+				},
+			},
+		}
+
+		err := svc.DescribeTagsPages(input, func(page *ec2.DescribeTagsOutput, lastPage bool) bool {
+			for _, tag := range page.Tags {
+				id := aws.StringValue(tag.ResourceId)
+				if ret[id] == nil {
+					ret[id] = make(map[string]string)
+				}
+				ret[id][aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+			}
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("DescribeTags failed: %s", err)
+		}
+	}
+	return nil
+}
+
+// Preview returns a description of the changes which will be made, along
+// with the number of resources which will change.
+func (t *tagCommand) Preview(res []tagResource) (string, int) {
+
+	changed := 0
+	var out strings.Builder
+
+	for _, r := range res {
+
+		if !r.Changed {
+			continue
+		}
+
+		keys := make(map[string]bool)
+		for k := range r.Before {
+			keys[k] = true
+		}
+		for k := range r.After {
+			keys[k] = true
+		}
+		sorted := []string{}
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+
+		lines := []string{}
+		for _, k := range sorted {
+			old, hadOld := r.Before[k]
+			cur, hasCur := r.After[k]
+			if hadOld == hasCur && old == cur {
+				continue
+			}
+			if !hadOld {
+				old = "(unset)"
+			}
+			if !hasCur {
+				cur = "(deleted)"
+			}
+			lines = append(lines, fmt.Sprintf("    %s: %s -> %s", k, old, cur))
+		}
+
+		changed++
+
+		out.WriteString(fmt.Sprintf("%s %s [%s]\n", r.ID, r.Description, r.Account))
+		out.WriteString(fmt.Sprintf("%s\n", strings.Join(lines, "\n")))
+	}
+	return out.String(), changed
+}
+
+// Apply makes our changes to the given resources.
+func (t *tagCommand) Apply(res []tagResource) error {
+
+	// The resources to change, by account.
+	ids := make(map[string][]*string)
+	for _, r := range res {
+		if r.Changed {
+			ids[r.Account] = append(ids[r.Account], aws.String(r.ID))
+		}
+	}
+
+	// The tags to set and delete.
+	set := []*ec2.Tag{}
+	for _, kv := range t.set {
+		parts := strings.SplitN(kv, "=", 2)
+		set = append(set, &ec2.Tag{Key: aws.String(parts[0]), Value: aws.String(parts[1])})
+	}
+	del := []*ec2.Tag{}
+	for _, key := range t.delete {
+		del = append(del, &ec2.Tag{Key: aws.String(key)})
+	}
+
+	for acct, list := range ids {
+
+		svc := t.clients[acct]
+
+		for start := 0; start < len(list); start += tagBatchSize {
+
+			end := start + tagBatchSize
+			if end > len(list) {
+				end = len(list)
+			}
+
+			if len(del) > 0 {
+				_, err := svc.DeleteTags(&ec2.DeleteTagsInput{Resources: list[start:end], Tags: del})
+				if err != nil {
+					return fmt.Errorf("DeleteTags failed in %s: %s", acct, err)
+				}
+			}
+			if len(set) > 0 {
+				_, err := svc.CreateTags(&ec2.CreateTagsInput{Resources: list[start:end], Tags: set})
+				if err != nil {
+					return fmt.Errorf("CreateTags failed in %s: %s", acct, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Execute is invoked if the user specifies this subcommand.
+func (t *tagCommand) Execute(args []string) int {
+
+	if len(t.set) == 0 && len(t.delete) == 0 {
+		fmt.Printf("Please specify the tags to change, via '-set' or '-delete'.\n")
+		return 1
+	}
+
+	// Refuse to act upon every instance.
+	if len(args) == 0 && len(t.tags) == 0 && t.where == "" {
+		fmt.Printf("Usage: tag [flags] pattern1 pattern2 .. patternN\n")
+		fmt.Printf("\nAt least one pattern, '-tag', or '-where' is required.\n")
+		return 1
+	}
+
+	// Parse the filter-expression and sort-keys
+	err := t.parseSelection()
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	//
+	// Get the connection, using default credentials
+	//
+	session, err := utils.NewSession()
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 1
+	}
+
+	// Tags may be changed whether instances are running or not.
+	t.states = []string{"pending", "running", "stopping", "stopped"}
+
+	//
+	// Now invoke our callback - this will call the function
+	// "CollectInstances" once if we're not running with a role-file,
+	// otherwise once for each role.
+	//
+	errs := utils.HandleRoles(session, t.rolesPath, t.CollectInstances, nil)
+	if len(errs) > 0 {
+		fmt.Printf("errors running tag\n")
+		for _, err := range errs {
+			fmt.Printf("%s\n", err)
+		}
+		return 1
+	}
+
+	// Sort by name by default, so output is stable.
+	ret := t.results
+	sortByName(ret)
+
+	ret, err = t.applySelection(ret)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	matched, err := t.matchAny(ret, args)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	res := t.Resources(matched)
+	err = t.Describe(res)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	return t.Change(res)
+}
+
+// Change shows the changes to the given resources, and then makes them,
+// unless this is a dry-run.
+func (t *tagCommand) Change(res []tagResource) int {
+
+	preview, count := t.Preview(res)
+	fmt.Printf("%s", preview)
+
+	if count == 0 {
+		fmt.Printf("No changes to make.\n")
+		return 0
+	}
+	if t.dryRun {
+		fmt.Printf("\nDry-run: %d resources would be changed.\n", count)
+		return 0
+	}
+
+	if !t.force {
+		err := confirm(fmt.Sprintf("\nPress Ctrl-C to cancel, or enter 'OK' (uppercase) to change the tags of %d resources.\n", count))
+		if err != nil {
+			fmt.Printf("%s\n", err)
+			return 1
+		}
+	}
+
+	err := t.Apply(res)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+
+	fmt.Printf("Changed the tags of %d resources.\n", count)
+	return 0
+}
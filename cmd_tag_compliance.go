@@ -0,0 +1,376 @@
+// Check that our resources are tagged according to a policy.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/skx/aws-utils/instances"
+	"github.com/skx/aws-utils/tags"
+	"github.com/skx/aws-utils/utils"
+	"gopkg.in/yaml.v2"
+)
+
+// tagPolicyTypes are the resource types a policy may refer to, "all"
+// applies to every type.
+var tagPolicyTypes = []string{"all", "instance", "volume", "security-group", "subnet", "vpc"}
+
+// tagRule is a single required tag, with an optional pattern its value
+// must match.
+type tagRule struct {
+	Key     string
+	Pattern *regexp.Regexp
+}
+
+// tagPolicy contains the rules for each resource type.
+type tagPolicy map[string][]tagRule
+
+// loadTagPolicy reads a policy from the given YAML file, which contains
+// the required tags of each resource type, along with an optional
+// regular expression which their values must match:
+//
+//	instance:
+//	  Environment: "^(production|staging)$"
+//	  Owner: ""
+func loadTagPolicy(path string) (tagPolicy, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+
+	raw := make(map[string]map[string]string)
+	err = yaml.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+
+	ret := make(tagPolicy)
+	for typ, tags := range raw {
+
+		valid := false
+		for _, t := range tagPolicyTypes {
+			if t == typ {
+				valid = true
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown resource type '%s' in %s, valid types are: %s", typ, path, strings.Join(tagPolicyTypes, ", "))
+		}
+
+		for key, pattern := range tags {
+			rule := tagRule{Key: key}
+			if pattern != "" {
+				rule.Pattern, err = regexp.Compile(pattern)
+				if err != nil {
+					return nil, fmt.Errorf("invalid pattern for %s tag %s: %s", typ, key, err)
+				}
+			}
+			ret[typ] = append(ret[typ], rule)
+		}
+		sort.Slice(ret[typ], func(i, j int) bool {
+			return ret[typ][i].Key < ret[typ][j].Key
+		})
+	}
+	return ret, nil
+}
+
+// Rules returns the rules of the given resource type, along with those
+// of "all".  If both require the same tag the rule of the type is used,
+// so each tag is only checked once.
+func (p tagPolicy) Rules(typ string) []tagRule {
+
+	own := make(map[string]bool)
+	for _, rule := range p[typ] {
+		own[rule.Key] = true
+	}
+
+	ret := []tagRule{}
+	for _, rule := range p["all"] {
+		if !own[rule.Key] {
+			ret = append(ret, rule)
+		}
+	}
+	if typ != "all" {
+		ret = append(ret, p[typ]...)
+	}
+	return ret
+}
+
+// Check returns the violations of the given resource.
+func (p tagPolicy) Check(typ string, tags map[string]string) []string {
+
+	ret := []string{}
+	for _, rule := range p.Rules(typ) {
+		{
+			val, ok := tags[rule.Key]
+			if !ok {
+				ret = append(ret, fmt.Sprintf("missing tag %s", rule.Key))
+				continue
+			}
+			if rule.Pattern != nil && !rule.Pattern.MatchString(val) {
+				ret = append(ret, fmt.Sprintf("tag %s=%s does not match %s", rule.Key, val, rule.Pattern))
+			}
+		}
+	}
+	return ret
+}
+
+// tagViolation describes a resource which doesn't satisfy the policy.
+type tagViolation struct {
+	Account    string   `json:"account"`
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Violations []string `json:"violations"`
+}
+
+// Structure for our options and state.
+type tagComplianceCommand struct {
+
+	// Path to a file containing roles
+	rolesPath string
+
+	// Path to the policy
+	policyPath string
+
+	// Tags to examine for the name of each resource
+	nameTags string
+
+	// Should we export our results in JSON format?
+	jsonOutput bool
+
+	// The parsed policy
+	policy tagPolicy
+
+	// The number of resources we've checked
+	checked int
+
+	// The violations we've found, across all accounts
+	results []tagViolation
+}
+
+// Arguments adds per-command args to the object.
+func (t *tagComplianceCommand) Arguments(f *flag.FlagSet) {
+	f.StringVar(&t.rolesPath, "roles", "", "Path to a list of roles to process, one by one")
+	f.StringVar(&t.policyPath, "policy", "tags.yaml", "Path to the YAML policy describing the required tags")
+	f.StringVar(&t.nameTags, "name-tags", "Name", "The tags to examine, in order, for the name of each resource, e.g. 'Name,aws:cloudformation:stack-name'")
+	f.BoolVar(&t.jsonOutput, "json", false, "Output the results in JSON.")
+}
+
+// Info returns the name of this subcommand.
+func (t *tagComplianceCommand) Info() (string, string) {
+	return "tag-compliance", `Check resources are tagged according to a policy.
+
+Details:
+
+This command checks that instances, volumes, security-groups, subnets,
+and VPCs have the tags described by a YAML policy.  The policy lists the
+required tags of each resource type, along with an optional regular
+expression which their values must match:
+
+    all:
+      Owner: ""
+    instance:
+      Environment: "^(production|staging|development)$"
+    volume:
+      Backup: "^(daily|weekly|none)$"
+
+The valid resource types are "instance", "volume", "security-group",
+"subnet", and "vpc", and the rules of "all" apply to every type.
+
+Violations are reported grouped by account:
+
+    $ aws-utils tag-compliance -roles=./roles -policy=tags.yaml
+    123456789012
+      instance i-01234567890abcdef prod-web-1: missing tag Owner
+
+Resources are named by their "Name" tag in the report, you may examine
+other tags, in order, via '-name-tags'.
+
+The exit code of the command reflects the result, so it may be used to
+gate pipelines, or from cron:
+
+    0 - All resources satisfy the policy.
+    1 - At least one resource violates the policy.
+    3 - An error prevented the check from completing.
+`
+
+}
+
+// check records the violations of the given resource, if any.
+func (t *tagComplianceCommand) check(acct string, typ string, id string, resourceTags map[string]string) {
+
+	t.checked++
+
+	v := t.policy.Check(typ, resourceTags)
+	if len(v) == 0 {
+		return
+	}
+
+	t.results = append(t.results, tagViolation{
+		Account:    acct,
+		Type:       typ,
+		ID:         id,
+		Name:       tags.Name(resourceTags, ""),
+		Violations: v,
+	})
+}
+
+// CheckAccount checks the resources within the given account.
+func (t *tagComplianceCommand) CheckAccount(svc *ec2.EC2, acct string, void interface{}) error {
+
+	// Instances
+	objs, err := instances.GetInstancesByState(svc, acct, "pending", "running", "stopping", "stopped")
+	if err != nil {
+		return err
+	}
+	for _, obj := range objs {
+		t.check(acct, "instance", obj.InstanceID, obj.Tags)
+	}
+
+	// Volumes
+	err = svc.DescribeVolumesPages(&ec2.DescribeVolumesInput{}, func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		for _, vol := range page.Volumes {
+			t.check(acct, "volume", aws.StringValue(vol.VolumeId), tags.FromEC2(vol.Tags))
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("DescribeVolumes failed: %s", err)
+	}
+
+	// Security groups
+	groups, err := describeSecurityGroups(svc)
+	if err != nil {
+		return fmt.Errorf("DescribeSecurityGroups failed: %s", err)
+	}
+	for _, sg := range groups {
+		t.check(acct, "security-group", aws.StringValue(sg.GroupId), tags.FromEC2(sg.Tags))
+	}
+
+	// Subnets
+	subnets, err := describeSubnets(svc)
+	if err != nil {
+		return fmt.Errorf("DescribeSubnets failed: %s", err)
+	}
+	for _, subnet := range subnets {
+		t.check(acct, "subnet", aws.StringValue(subnet.SubnetId), tags.FromEC2(subnet.Tags))
+	}
+
+	// VPCs
+	err = svc.DescribeVpcsPages(&ec2.DescribeVpcsInput{}, func(page *ec2.DescribeVpcsOutput, lastPage bool) bool {
+		for _, vpc := range page.Vpcs {
+			t.check(acct, "vpc", aws.StringValue(vpc.VpcId), tags.FromEC2(vpc.Tags))
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("DescribeVpcs failed: %s", err)
+	}
+
+	return nil
+}
+
+// Report outputs the violations we've found, grouped by account.
+func (t *tagComplianceCommand) Report() error {
+
+	sort.SliceStable(t.results, func(i, j int) bool {
+		a, b := t.results[i], t.results[j]
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.ID < b.ID
+	})
+
+	if t.jsonOutput {
+		b, err := json.MarshalIndent(t.results, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error exporting to JSON %s", err)
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	acct := ""
+	for _, v := range t.results {
+		if v.Account != acct {
+			if acct != "" {
+				fmt.Printf("\n")
+			}
+			acct = v.Account
+			fmt.Printf("%s\n", acct)
+		}
+
+		desc := v.ID
+		if v.Name != "" {
+			desc += " " + v.Name
+		}
+		fmt.Printf("  %s %s: %s\n", v.Type, desc, strings.Join(v.Violations, ", "))
+	}
+
+	if len(t.results) > 0 {
+		fmt.Printf("\n")
+	}
+	fmt.Printf("%d of %d resources violate the policy.\n", len(t.results), t.checked)
+	return nil
+}
+
+// Execute is invoked if the user specifies this subcommand.
+func (t *tagComplianceCommand) Execute(args []string) int {
+
+	// Use the tags we were given to find names
+	tags.SetNameChain(t.nameTags)
+
+	var err error
+	t.policy, err = loadTagPolicy(t.policyPath)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 3
+	}
+
+	//
+	// Get the connection, using default credentials
+	//
+	session, err := utils.NewSession()
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return 3
+	}
+
+	//
+	// Now invoke our callback - this will call the function
+	// "CheckAccount" once if we're not running with a role-file,
+	// otherwise once for each role.
+	//
+	errs := utils.HandleRoles(session, t.rolesPath, t.CheckAccount, nil)
+	if len(errs) > 0 {
+		fmt.Printf("errors running tag-compliance\n")
+		for _, err := range errs {
+			fmt.Printf("%s\n", err)
+		}
+		return 3
+	}
+
+	err = t.Report()
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return 3
+	}
+
+	if len(t.results) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePolicy writes the given policy to a temporary file, and returns
+// its path.
+func writePolicy(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "tags.yaml")
+	err := os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("failed to write policy: %s", err)
+	}
+	return path
+}
+
+// TestLoadTagPolicy tests that policies are parsed, and bogus ones are
+// rejected.
+func TestLoadTagPolicy(t *testing.T) {
+
+	type TestCase struct {
+		Input string
+		Keys  map[string]string
+		Error string
+	}
+
+	tests := []TestCase{
+		{Input: "",
+			Keys: map[string]string{}},
+		{Input: "all:\n  Owner: \"\"\ninstance:\n  Role: \"\"\n  Environment: \"^prod$\"\n",
+			Keys: map[string]string{"all": "Owner", "instance": "Environment,Role"}},
+		{Input: "vpc:\n  Name: \"\"\nsubnet:\n  Name: \"\"\nvolume:\n  Backup: \"\"\nsecurity-group:\n  Owner: \"\"\n",
+			Keys: map[string]string{"vpc": "Name", "subnet": "Name", "volume": "Backup", "security-group": "Owner"}},
+		{Input: "bucket:\n  Owner: \"\"\n",
+			Error: "unknown resource type 'bucket'"},
+		{Input: "instance:\n  Owner: \"[\"\n",
+			Error: "invalid pattern for instance tag Owner"},
+		{Input: "instance: [1, 2\n",
+			Error: "failed to parse"},
+	}
+
+	for _, test := range tests {
+
+		policy, err := loadTagPolicy(writePolicy(t, test.Input))
+		if test.Error != "" {
+			if err == nil {
+				t.Errorf("expected error loading %q, got none", test.Input)
+			} else if !strings.Contains(err.Error(), test.Error) {
+				t.Errorf("expected error %q, got %q", test.Error, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error loading %q: %s", test.Input, err)
+			continue
+		}
+
+		if len(policy) != len(test.Keys) {
+			t.Errorf("%q: expected %d types, got %d", test.Input, len(test.Keys), len(policy))
+		}
+		for typ, keys := range test.Keys {
+			found := []string{}
+			for _, rule := range policy[typ] {
+				found = append(found, rule.Key)
+			}
+			if strings.Join(found, ",") != keys {
+				t.Errorf("%q: expected %s rules %s, got %s", test.Input, typ, keys, strings.Join(found, ","))
+			}
+		}
+	}
+
+	// A missing file is an error too
+	_, err := loadTagPolicy(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "failed to read") {
+		t.Errorf("expected error reading missing policy, got %v", err)
+	}
+}
+
+// TestTagPolicyCheck tests finding the violations of resources.
+func TestTagPolicyCheck(t *testing.T) {
+
+	policy, err := loadTagPolicy(writePolicy(t, `
+all:
+  Owner: ""
+  Backup: ""
+instance:
+  Environment: "^(production|staging)$"
+  Backup: "^(daily|none)$"
+`))
+	if err != nil {
+		t.Fatalf("failed to load policy: %s", err)
+	}
+
+	type TestCase struct {
+		Type   string
+		Tags   map[string]string
+		Result []string
+	}
+
+	tests := []TestCase{
+		{"instance", map[string]string{"Owner": "steve", "Environment": "production", "Backup": "none"}, nil},
+		{"instance", map[string]string{"Owner": "", "Environment": "staging", "Backup": "daily"}, nil},
+		{"instance", map[string]string{"Environment": "production", "Backup": "none"}, []string{"missing tag Owner"}},
+		{"instance", map[string]string{"Owner": "steve", "Environment": "dev", "Backup": "none"},
+			[]string{"tag Environment=dev does not match ^(production|staging)$"}},
+		{"instance", map[string]string{}, []string{"missing tag Owner", "missing tag Backup", "missing tag Environment"}},
+		{"instance", nil, []string{"missing tag Owner", "missing tag Backup", "missing tag Environment"}},
+		{"volume", map[string]string{"Owner": "steve", "Backup": "weekly"}, nil},
+		{"volume", map[string]string{"owner": "steve", "Backup": "x"}, []string{"missing tag Owner"}},
+
+		// A tag required by "all" and the type is only checked once,
+		// against the pattern of the type.
+		{"instance", map[string]string{"Owner": "steve", "Environment": "production", "Backup": "weekly"},
+			[]string{"tag Backup=weekly does not match ^(daily|none)$"}},
+		{"volume", map[string]string{"Owner": "steve"}, []string{"missing tag Backup"}},
+	}
+
+	for _, test := range tests {
+
+		out := policy.Check(test.Type, test.Tags)
+		if strings.Join(out, "|") != strings.Join(test.Result, "|") {
+			t.Errorf("%s %v: expected %v, got %v", test.Type, test.Tags, test.Result, out)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/skx/aws-utils/instances"
+)
+
+// tagString converts tags to a sorted string, for comparison.
+func tagString(tags map[string]string) string {
+	ret := []string{}
+	for k, v := range tags {
+		ret = append(ret, k+"="+v)
+	}
+	sort.Strings(ret)
+	return strings.Join(ret, ",")
+}
+
+// TestTagApply tests that tags are set and deleted.
+func TestTagApply(t *testing.T) {
+
+	type TestCase struct {
+		Set    tagSets
+		Delete tagKeys
+		Input  map[string]string
+		Output string
+	}
+
+	tests := []TestCase{
+		{tagSets{"Env=prod"}, nil, map[string]string{}, "Env=prod"},
+		{tagSets{"Env=prod"}, nil, map[string]string{"Env": "dev", "Team": "ops"}, "Env=prod,Team=ops"},
+		{tagSets{"Query=a=b"}, nil, nil, "Query=a=b"},
+		{tagSets{"Empty="}, nil, nil, "Empty="},
+		{nil, tagKeys{"Owner"}, map[string]string{"Owner": "me", "Team": "ops"}, "Team=ops"},
+		{nil, tagKeys{"Missing"}, map[string]string{"Team": "ops"}, "Team=ops"},
+
+		// Setting wins over deleting the same key
+		{tagSets{"Team=dev"}, tagKeys{"Team"}, map[string]string{"Team": "ops"}, "Team=dev"},
+	}
+
+	for _, test := range tests {
+
+		tc := &tagCommand{set: test.Set, delete: test.Delete}
+
+		before := tagString(test.Input)
+		out := tagString(tc.apply(test.Input))
+		if out != test.Output {
+			t.Errorf("%v %v: expected %s, got %s", test.Set, test.Delete, test.Output, out)
+		}
+		if tagString(test.Input) != before {
+			t.Errorf("%v %v: input was modified", test.Set, test.Delete)
+		}
+	}
+}
+
+// TestTagResources tests finding the resources of instances.
+func TestTagResources(t *testing.T) {
+
+	objs := []instances.InstanceOutput{
+		{AWSAccount: "123", InstanceID: "i-1", InstanceName: "web",
+			Volumes:           []instances.Volume{{ID: "vol-1", Device: "/dev/sda1"}, {ID: "vol-2", Device: "/dev/sdb"}},
+			NetworkInterfaces: []instances.NetworkInterface{{ID: "eni-1", DeviceIndex: 0}}},
+		{AWSAccount: "456", InstanceID: "i-2", InstanceName: "db"},
+	}
+
+	type TestCase struct {
+		Volumes bool
+		ENIs    bool
+		Result  string
+	}
+
+	tests := []TestCase{
+		{false, false, "i-1 web,i-2 db"},
+		{true, false, "i-1 web,vol-1 web /dev/sda1,vol-2 web /dev/sdb,i-2 db"},
+		{false, true, "i-1 web,eni-1 web eth0,i-2 db"},
+		{true, true, "i-1 web,vol-1 web /dev/sda1,vol-2 web /dev/sdb,eni-1 web eth0,i-2 db"},
+	}
+
+	for _, test := range tests {
+
+		tc := &tagCommand{volumes: test.Volumes, enis: test.ENIs}
+
+		out := []string{}
+		for _, r := range tc.Resources(objs) {
+			out = append(out, r.ID+" "+r.Description)
+		}
+		if strings.Join(out, ",") != test.Result {
+			t.Errorf("volumes:%t enis:%t: expected %s, got %s", test.Volumes, test.ENIs, test.Result, strings.Join(out, ","))
+		}
+	}
+}
+
+// TestTagPreview tests the preview of set and deleted tags.
+func TestTagPreview(t *testing.T) {
+
+	current := map[string]map[string]string{
+		"i-1":   {"Env": "dev", "Owner": "me"},
+		"i-2":   {"Env": "prod"},
+		"vol-1": {"Owner": "you"},
+	}
+
+	type TestCase struct {
+		Set     tagSets
+		Delete  tagKeys
+		Count   int
+		Preview string
+	}
+
+	tests := []TestCase{
+		{tagSets{"Env=prod"}, nil, 2,
+			"i-1 web [123]\n    Env: dev -> prod\nvol-1 web /dev/sda1 [123]\n    Env: (unset) -> prod\n"},
+		{nil, tagKeys{"Owner"}, 2,
+			"i-1 web [123]\n    Owner: me -> (deleted)\nvol-1 web /dev/sda1 [123]\n    Owner: you -> (deleted)\n"},
+		{tagSets{"Env=prod"}, tagKeys{"Owner"}, 2,
+			"i-1 web [123]\n    Env: dev -> prod\n    Owner: me -> (deleted)\nvol-1 web /dev/sda1 [123]\n    Env: (unset) -> prod\n    Owner: you -> (deleted)\n"},
+		{nil, tagKeys{"Missing"}, 0, ""},
+	}
+
+	for _, test := range tests {
+
+		tc := &tagCommand{set: test.Set, delete: test.Delete}
+
+		// i-2 already has the tag we set, so it never changes.
+		res := []tagResource{
+			{Account: "123", ID: "i-1", Description: "web"},
+			{Account: "123", ID: "vol-1", Description: "web /dev/sda1"},
+			{Account: "456", ID: "i-2", Description: "db"},
+		}
+		tc.compare(res, current)
+
+		preview, count := tc.Preview(res)
+		if count != test.Count {
+			t.Errorf("%v %v: expected %d changes, got %d", test.Set, test.Delete, test.Count, count)
+		}
+		if preview != test.Preview {
+			t.Errorf("%v %v: unexpected preview, got:\n%s\nexpected:\n%s", test.Set, test.Delete, preview, test.Preview)
+		}
+	}
+
+	// Comparing didn't change the current tags.
+	if tagString(current["i-1"]) != "Env=dev,Owner=me" {
+		t.Errorf("current tags were modified: %v", current["i-1"])
+	}
+}
+
+// TestTagDryRun tests that a dry-run makes no changes.
+func TestTagDryRun(t *testing.T) {
+
+	type TestCase struct {
+		DryRun bool
+		Set    tagSets
+	}
+
+	// There are no AWS clients, so making any changes would fail.
+	tests := []TestCase{
+		{true, tagSets{"Env=prod"}},
+
+		// Nothing to change, so nothing is made
+		{false, tagSets{"Env=dev"}},
+	}
+
+	for _, test := range tests {
+
+		tc := &tagCommand{set: test.Set, dryRun: test.DryRun, force: true}
+
+		res := []tagResource{{Account: "123", ID: "i-1", Description: "web"}}
+		tc.compare(res, map[string]map[string]string{"i-1": {"Env": "dev"}})
+
+		if ret := tc.Change(res); ret != 0 {
+			t.Errorf("%v: expected success, got %d", test.Set, ret)
+		}
+	}
+}
@@ -78,12 +78,13 @@ func getAccountID(svc stsiface.STSAPI) (id string) {
 	return id
 }
 
-func getAccountAlias(svc iamiface.IAMAPI) (alias string) {
+func getAccountAlias(svc iamiface.IAMAPI) (alias string, err error) {
 
 	getAliasOutput, err := svc.ListAccountAliases(&iam.ListAccountAliasesInput{})
 	if err != nil {
-		fmt.Println("Missing \"iam:ListAccountAliases\" permission so unable to retrieve alias")
-	} else if len(getAliasOutput.AccountAliases) > 0 {
+		return "", err
+	}
+	if len(getAliasOutput.AccountAliases) > 0 {
 		alias = *getAliasOutput.AccountAliases[0]
 	}
 	return
@@ -105,7 +106,10 @@ func (i *whoamiCommand) Execute(args []string) int {
 
 	// Find the account and alias (optional)
 	accountID := getAccountID(stsSvc)
-	accountAlias := getAccountAlias(svc)
+	accountAlias, err := getAccountAlias(svc)
+	if err != nil {
+		fmt.Println("Missing \"iam:ListAccountAliases\" permission so unable to retrieve alias")
+	}
 
 	// Prefer the alias to the account
 	if accountAlias != "" {
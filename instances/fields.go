@@ -0,0 +1,296 @@
+package instances
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skx/aws-utils/pricing"
+)
+
+// Field describes a single attribute of an instance, which may be
+// exported in CSV, JSON, or template output.
+type Field struct {
+	// Key is the name used to select the field, via "-format".
+	Key string
+
+	// Header is the human-readable title, used for CSV headers.
+	Header string
+
+	// Description is used to generate help-text.
+	Description string
+
+	// Value returns the value of this field for the given instance.
+	Value func(obj InstanceOutput) interface{}
+}
+
+// registry holds all the fields we know about.
+var registry = []Field{
+	{"account", "Account ID", "The AWS account-number.",
+		func(obj InstanceOutput) interface{} { return obj.AWSAccount }},
+	{"all-ips", "All IPs", "Every IPv4 and IPv6 address associated with the instance.",
+		func(obj InstanceOutput) interface{} { return obj.AllIPs() }},
+	{"ami", "AMI ID", "The AMI name of the running instance.",
+		func(obj InstanceOutput) interface{} { return obj.InstanceAMI }},
+	{"amiage", "AMI Age", "The age of the AMI in days.",
+		func(obj InstanceOutput) interface{} { return obj.AMIAge }},
+	{"amiowner", "AMI Owner", "The ID of the account which owns the AMI.",
+		func(obj InstanceOutput) interface{} { return obj.AMIOwner }},
+	{"arch", "Architecture", "The CPU architecture of the instance.",
+		func(obj InstanceOutput) interface{} { return obj.Architecture }},
+	{"az", "Availability Zone", "The availability zone within which the instance is running.",
+		func(obj InstanceOutput) interface{} { return obj.AvailabilityZone }},
+	{"cost", "Monthly Cost", "The estimated monthly on-demand cost of the instance and its volumes, empty if any type has no known price.",
+		func(obj InstanceOutput) interface{} {
+			cost, ok := obj.MonthlyCost()
+			if !ok {
+				return nil
+			}
+			return cost
+		}},
+	{"ebs-optimized", "EBS Optimized", "Whether the instance is EBS-optimized.",
+		func(obj InstanceOutput) interface{} { return obj.EBSOptimized }},
+	{"iam-profile", "IAM Instance Profile", "The ARN of the IAM instance profile.",
+		func(obj InstanceOutput) interface{} { return obj.IAMInstanceProfile }},
+	{"id", "Instance ID", "The instance ID.",
+		func(obj InstanceOutput) interface{} { return obj.InstanceID }},
+	{"imds-tokens", "IMDS Tokens", "The IMDS token setting (\"required\" for IMDSv2 only).",
+		func(obj InstanceOutput) interface{} { return obj.MetadataHTTPTokens }},
+	{"ipv6", "IPv6", "The IPv6 addresses associated with the instance.",
+		func(obj InstanceOutput) interface{} { return obj.IPv6Addresses }},
+	{"launchtime", "Launch Time", "The time at which the instance was launched.",
+		func(obj InstanceOutput) interface{} { return obj.LaunchTime }},
+	{"lifecycle", "Lifecycle", "The instance lifecycle (spot, on-demand, etc).",
+		func(obj InstanceOutput) interface{} { return obj.Lifecycle }},
+	{"monitoring", "Monitoring", "The state of detailed monitoring.",
+		func(obj InstanceOutput) interface{} { return obj.Monitoring }},
+	{"name", "Name", "The instance name, as set via tags.",
+		func(obj InstanceOutput) interface{} { return obj.InstanceName }},
+	{"platform", "Platform", "The platform of the instance (Linux/UNIX, Windows, etc).",
+		func(obj InstanceOutput) interface{} { return obj.Platform }},
+	{"privateipv4", "PrivateIPv4", "The (private) IPv4 address associated with the instance.",
+		func(obj InstanceOutput) interface{} { return obj.PrivateIPv4 }},
+	{"publicipv4", "PublicIPv4", "The (public) IPv4 address associated with the instance.",
+		func(obj InstanceOutput) interface{} { return obj.PublicIPv4 }},
+	{"security-groups", "Security Groups", "The IDs of the attached security-groups.",
+		func(obj InstanceOutput) interface{} {
+			ids := []string{}
+			for _, sg := range obj.SecurityGroups {
+				ids = append(ids, sg.ID)
+			}
+			return ids
+		}},
+	{"ssh-key", "SSH Key", "The SSH key setup for this instance.",
+		func(obj InstanceOutput) interface{} { return obj.SSHKeyName }},
+	{"state", "Instance State", "The instance state (running, pending, etc).",
+		func(obj InstanceOutput) interface{} { return obj.InstanceState }},
+	{"subnet", "Subnet", "The name of the subnet within which the instance is running.",
+		func(obj InstanceOutput) interface{} { return obj.SubnetName }},
+	{"subnetid", "Subnet ID", "The ID of the subnet within which the instance is running.",
+		func(obj InstanceOutput) interface{} { return obj.SubnetID }},
+	{"tenancy", "Tenancy", "The instance tenancy (default, dedicated, etc).",
+		func(obj InstanceOutput) interface{} { return obj.Tenancy }},
+	{"total-ebs-gib", "Total EBS GiB", "The total size of all the attached volumes, in GiB.",
+		func(obj InstanceOutput) interface{} { return obj.EBSGiB() }},
+	{"type", "Instance Type", "The instance type (t2.small, t3.large, etc).",
+		func(obj InstanceOutput) interface{} { return obj.InstanceType }},
+	{"uptime", "Uptime", "The number of days since the instance was launched.",
+		func(obj InstanceOutput) interface{} { return obj.Uptime }},
+	{"vcpus", "vCPUs", "The number of virtual CPUs of the instance.",
+		func(obj InstanceOutput) interface{} { return obj.VCPUs }},
+	{"vpc", "VPC", "The name of the VPC within which the instance is running.",
+		func(obj InstanceOutput) interface{} { return obj.VPCName }},
+	{"vpcid", "VPC ID", "The ID of the VPC within which the instance is running.",
+		func(obj InstanceOutput) interface{} { return obj.VPCID }},
+}
+
+// volumeRegistry holds the fields which describe a single volume, these
+// are only available when instances have been expanded via PerVolume.
+var volumeRegistry = []Field{
+	{"vol-cost", "Volume Cost", "The estimated monthly cost of the volume, empty if its type has no known price.",
+		func(obj InstanceOutput) interface{} {
+			cost, ok := pricing.Prices.Volume(volume(obj).Type, atoi(volume(obj).Size))
+			if !ok {
+				return nil
+			}
+			return pricing.Round(cost)
+		}},
+	{"vol-device", "Volume Device", "The device name of the volume.",
+		func(obj InstanceOutput) interface{} { return volume(obj).Device }},
+	{"vol-encrypted", "Volume Encrypted", "Whether the volume is encrypted.",
+		func(obj InstanceOutput) interface{} { return volume(obj).Encrypted == "true" }},
+	{"vol-id", "Volume ID", "The ID of the volume.",
+		func(obj InstanceOutput) interface{} { return volume(obj).ID }},
+	{"vol-iops", "Volume IOPS", "The provisioned IOPS of the volume.",
+		func(obj InstanceOutput) interface{} { return atoi(volume(obj).IOPS) }},
+	{"vol-size", "Volume Size", "The size of the volume, in GiB.",
+		func(obj InstanceOutput) interface{} { return atoi(volume(obj).Size) }},
+	{"vol-type", "Volume Type", "The type of the volume (gp2, gp3, io1, etc).",
+		func(obj InstanceOutput) interface{} { return volume(obj).Type }},
+}
+
+// volume returns the volume the given entry describes, if any.
+func volume(obj InstanceOutput) Volume {
+	if obj.volume == nil {
+		return Volume{}
+	}
+	return *obj.volume
+}
+
+// atoi converts the given string to a number, returning zero on error.
+func atoi(str string) int {
+	n, _ := strconv.Atoi(str)
+	return n
+}
+
+// Fields returns all the known fields, sorted by key.
+func Fields() []Field {
+
+	ret := make([]Field, len(registry))
+	copy(ret, registry)
+
+	sort.Slice(ret, func(a, b int) bool {
+		return ret[a].Key < ret[b].Key
+	})
+	return ret
+}
+
+// LookupField returns the field with the given key.
+func LookupField(key string) (Field, bool) {
+
+	for _, f := range registry {
+		if f.Key == key {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// ParseFields converts a comma-separated list of field keys into the
+// corresponding fields, returning an error if any are unknown.
+func ParseFields(format string) ([]Field, error) {
+	return parseFields(format, false)
+}
+
+// ParseVolumeFields is like ParseFields, but also allows the fields which
+// describe a single volume, for use with PerVolume.
+func ParseVolumeFields(format string) ([]Field, error) {
+	return parseFields(format, true)
+}
+
+// parseFields converts a comma-separated list of field keys into the
+// corresponding fields, optionally allowing the volume fields.
+func parseFields(format string, volumes bool) ([]Field, error) {
+
+	ret := []Field{}
+
+	for _, key := range strings.Split(format, ",") {
+
+		// Ensure all fields are lower-cased and stripped of spaces
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == "" {
+			continue
+		}
+
+		f, ok := LookupField(key)
+		if !ok && volumes {
+			f, ok = lookupVolumeField(key)
+		}
+		if !ok {
+			if strings.HasPrefix(key, "vol-") && !volumes {
+				return nil, fmt.Errorf("field '%s' is only available per-volume", key)
+			}
+			return nil, fmt.Errorf("unknown field '%s'", key)
+		}
+		ret = append(ret, f)
+	}
+
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no fields specified")
+	}
+	return ret, nil
+}
+
+// lookupVolumeField returns the volume field with the given key.
+func lookupVolumeField(key string) (Field, bool) {
+
+	for _, f := range volumeRegistry {
+		if f.Key == key {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// FieldHelp returns a description of every field, suitable for
+// inclusion in help-text.
+func FieldHelp() string {
+	return fieldHelp(Fields())
+}
+
+// VolumeFieldHelp returns a description of every volume field, suitable
+// for inclusion in help-text.
+func VolumeFieldHelp() string {
+	return fieldHelp(volumeRegistry)
+}
+
+// fieldHelp describes the given fields.
+func fieldHelp(fields []Field) string {
+
+	var out strings.Builder
+	for _, f := range fields {
+		out.WriteString(fmt.Sprintf("* \"%s\" - %s\n", f.Key, f.Description))
+	}
+	return out.String()
+}
+
+// String returns the value of this field for the given instance, as a
+// string.
+//
+// Lists are joined with spaces, amounts are shown to two decimal places,
+// times are shown in RFC3339 format, and unknown values are empty.
+func (f Field) String(obj InstanceOutput) string {
+
+	switch v := f.Value(obj).(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []string:
+		return strings.Join(v, " ")
+	case float64:
+		return fmt.Sprintf("%.2f", v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// Record returns the values of the given fields for the given instance,
+// keyed by the field key.
+//
+// This is used for JSON output.
+func Record(obj InstanceOutput, fields []Field) map[string]interface{} {
+
+	ret := make(map[string]interface{})
+	for _, f := range fields {
+		ret[f.Key] = f.Value(obj)
+	}
+	return ret
+}
+
+// Field returns the value of the field with the given key, as a string.
+//
+// This allows templates to use the same fields as CSV output, for
+// example `{{.Field "subnet"}}`.
+func (i InstanceOutput) Field(key string) (string, error) {
+
+	f, ok := LookupField(key)
+	if !ok {
+		return "", fmt.Errorf("unknown field '%s'", key)
+	}
+	return f.String(i), nil
+}
@@ -0,0 +1,99 @@
+package instances
+
+import (
+	"sort"
+	"strings"
+)
+
+// Group holds the aggregated details of a set of instances which share
+// the same values for a number of fields.
+type Group struct {
+	// Values holds the values of the fields the group was created from,
+	// in the same order as the fields.
+	Values []string
+
+	// Count is the number of instances in the group.
+	Count int
+
+	// VCPUs is the total number of virtual CPUs in the group.
+	VCPUs int
+
+	// EBSGiB is the total size of all volumes in the group.
+	EBSGiB int
+
+	// MinAMIAge is the age of the newest AMI in the group, in days.
+	//
+	// This is -1 if no AMI ages were known.
+	MinAMIAge int
+
+	// MaxAMIAge is the age of the oldest AMI in the group, in days.
+	//
+	// This is -1 if no AMI ages were known.
+	MaxAMIAge int
+}
+
+// GroupBy aggregates the given instances by the values of the given
+// fields, returning one group for each distinct set of values, sorted
+// by those values.
+func GroupBy(objs []InstanceOutput, fields []Field) []Group {
+
+	// Groups, keyed by their joined values.
+	groups := make(map[string]*Group)
+	keys := []string{}
+
+	for _, obj := range objs {
+
+		values := []string{}
+		for _, f := range fields {
+			values = append(values, f.String(obj))
+		}
+		key := strings.Join(values, "\x00")
+
+		g, ok := groups[key]
+		if !ok {
+			g = &Group{Values: values, MinAMIAge: -1, MaxAMIAge: -1}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+
+		g.Count++
+		g.VCPUs += obj.VCPUs
+		g.EBSGiB += obj.EBSGiB()
+
+		// Negative ages mean the AMI wasn't found, so skip them.
+		if obj.AMIAge >= 0 {
+			if g.MinAMIAge < 0 || obj.AMIAge < g.MinAMIAge {
+				g.MinAMIAge = obj.AMIAge
+			}
+			if obj.AMIAge > g.MaxAMIAge {
+				g.MaxAMIAge = obj.AMIAge
+			}
+		}
+	}
+
+	sort.Strings(keys)
+
+	ret := []Group{}
+	for _, key := range keys {
+		ret = append(ret, *groups[key])
+	}
+	return ret
+}
+
+// Record returns the details of the group, keyed by field key, in the
+// same way as the Record function does for instances.
+//
+// This is used for JSON output.
+func (g Group) Record(fields []Field) map[string]interface{} {
+
+	ret := make(map[string]interface{})
+	for i, f := range fields {
+		ret[f.Key] = g.Values[i]
+	}
+	ret["count"] = g.Count
+	ret["vcpus"] = g.VCPUs
+	ret["ebs-gib"] = g.EBSGiB
+	ret["min-amiage"] = g.MinAMIAge
+	ret["max-amiage"] = g.MaxAMIAge
+	return ret
+}
@@ -0,0 +1,72 @@
+package instances
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestGroupBy tests aggregating instances by fields.
+func TestGroupBy(t *testing.T) {
+
+	objs := []InstanceOutput{
+		{AWSAccount: "2", InstanceType: "t3.small", VCPUs: 2, AMIAge: 10, Volumes: []Volume{{Size: "8"}, {Size: "100"}}},
+		{AWSAccount: "1", InstanceType: "m5.large", VCPUs: 2, AMIAge: 90, Volumes: []Volume{{Size: "20"}}},
+		{AWSAccount: "1", InstanceType: "m5.large", VCPUs: 2, AMIAge: 30},
+		{AWSAccount: "1", InstanceType: "t3.small", VCPUs: 2, AMIAge: -1, Volumes: []Volume{{Size: "bogus"}}},
+	}
+
+	type TestCase struct {
+		Fields string
+		Result []Group
+	}
+
+	tests := []TestCase{
+		{"account", []Group{
+			{Values: []string{"1"}, Count: 3, VCPUs: 6, EBSGiB: 20, MinAMIAge: 30, MaxAMIAge: 90},
+			{Values: []string{"2"}, Count: 1, VCPUs: 2, EBSGiB: 108, MinAMIAge: 10, MaxAMIAge: 10},
+		}},
+		{"account,type", []Group{
+			{Values: []string{"1", "m5.large"}, Count: 2, VCPUs: 4, EBSGiB: 20, MinAMIAge: 30, MaxAMIAge: 90},
+			{Values: []string{"1", "t3.small"}, Count: 1, VCPUs: 2, EBSGiB: 0, MinAMIAge: -1, MaxAMIAge: -1},
+			{Values: []string{"2", "t3.small"}, Count: 1, VCPUs: 2, EBSGiB: 108, MinAMIAge: 10, MaxAMIAge: 10},
+		}},
+		{"type", []Group{
+			{Values: []string{"m5.large"}, Count: 2, VCPUs: 4, EBSGiB: 20, MinAMIAge: 30, MaxAMIAge: 90},
+			{Values: []string{"t3.small"}, Count: 2, VCPUs: 4, EBSGiB: 108, MinAMIAge: 10, MaxAMIAge: 10},
+		}},
+	}
+
+	for _, test := range tests {
+
+		fields, err := ParseFields(test.Fields)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %s: %s", test.Fields, err)
+		}
+
+		out := GroupBy(objs, fields)
+		if !reflect.DeepEqual(out, test.Result) {
+			t.Errorf("%s: expected %+v, got %+v", test.Fields, test.Result, out)
+		}
+	}
+
+	// No instances, no groups.
+	fields, _ := ParseFields("account")
+	if out := GroupBy(nil, fields); len(out) != 0 {
+		t.Errorf("expected no groups, got %+v", out)
+	}
+}
+
+// TestGroupRecord tests the JSON representation of a group.
+func TestGroupRecord(t *testing.T) {
+
+	fields, _ := ParseFields("account,type")
+	g := Group{Values: []string{"1", "m5.large"}, Count: 2, VCPUs: 4, EBSGiB: 20, MinAMIAge: 30, MaxAMIAge: 90}
+
+	expected := map[string]interface{}{
+		"account": "1", "type": "m5.large", "count": 2, "vcpus": 4,
+		"ebs-gib": 20, "min-amiage": 30, "max-amiage": 90,
+	}
+	if out := g.Record(fields); !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %v, got %v", expected, out)
+	}
+}
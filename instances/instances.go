@@ -3,13 +3,18 @@
 package instances
 
 import (
-	"errors"
 	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/skx/aws-utils/amiage"
-	"github.com/skx/aws-utils/tag2name"
+	"github.com/skx/aws-utils/pricing"
+	"github.com/skx/aws-utils/tags"
 )
 
 // Volume holds detailed regarding an instances volumes.
@@ -34,6 +39,74 @@ type Volume struct {
 
 	// IOPS holds the speed of the device.
 	IOPS string
+
+	// Throughput holds the provisioned throughput of the device, in MiB/s.
+	Throughput string
+
+	// KMSKeyID holds the ID of the KMS key used to encrypt the volume.
+	KMSKeyID string
+
+	// SnapshotID holds the ID of the snapshot the volume was created from.
+	SnapshotID string
+
+	// State holds the volume state (in-use, available, etc).
+	State string
+
+	// DeleteOnTermination shows whether the volume will be removed when
+	// the instance is terminated.
+	DeleteOnTermination string
+
+	// Tags holds the tags of the volume.
+	Tags map[string]string
+}
+
+// SecurityGroup holds details of a security-group attached to an instance.
+type SecurityGroup struct {
+	// ID is the ID of the security-group.
+	ID string
+
+	// Name is the name of the security-group.
+	Name string
+}
+
+// IPAddress holds details of a single private IPv4 address assigned to a
+// network interface, along with any public address associated with it.
+type IPAddress struct {
+	// Private is the private IPv4 address.
+	Private string
+
+	// Primary is true if this is the primary address of the interface.
+	Primary bool
+
+	// Public is the public IPv4 address associated with this address, if any.
+	Public string
+
+	// AllocationID is the allocation ID of the elastic IP, if the public
+	// address is an elastic IP.
+	AllocationID string
+}
+
+// NetworkInterface holds details of a network interface (ENI) attached to
+// an instance.
+type NetworkInterface struct {
+	// ID is the ID of the network interface.
+	ID string
+
+	// DeviceIndex is the index of the interface upon the instance, the
+	// primary interface has index zero.
+	DeviceIndex int
+
+	// SubnetID is the ID of the subnet the interface is attached to.
+	SubnetID string
+
+	// Addresses holds the IPv4 addresses assigned to the interface.
+	Addresses []IPAddress
+
+	// IPv6Addresses holds the IPv6 addresses assigned to the interface.
+	IPv6Addresses []string
+
+	// SecurityGroups holds the security-groups attached to the interface.
+	SecurityGroups []SecurityGroup
 }
 
 // InstanceOutput is the structure used to populate our templated output
@@ -54,188 +127,606 @@ type InstanceOutput struct {
 	// InstanceName holds the AWS instance name, if set
 	InstanceName string
 
+	// Tags holds all the tags of the instance.
+	Tags map[string]string
+
 	// InstanceAMI holds the AMI name
 	InstanceAMI string
 
 	// AMIAge contains the age of the AMI in days.
 	AMIAge int
 
+	// AMIOwner contains the ID of the account which owns the AMI.
+	AMIOwner string
+
 	// InstanceState holds the instance state (stopped, running, etc)
 	InstanceState string
 
 	// InstanceType holds the instance type "t2.tiny", etc.
 	InstanceType string
 
+	// VCPUs holds the number of virtual CPUs of the instance.
+	VCPUs int
+
 	// Keypair setup for access.
 	SSHKeyName string
 
 	// SubnetID is the ID of the subnet the instance is running within.
 	SubnetID string
 
+	// SubnetName is the name of the subnet the instance is running within.
+	SubnetName string
+
 	// PublicIPv4 has the public IPv4 address
 	PublicIPv4 string
 
 	// PrivateIPv4 has the private IPv4 address
 	PrivateIPv4 string
 
+	// NetworkInterfaces holds all attached network interfaces.
+	NetworkInterfaces []NetworkInterface
+
+	// IPv6Addresses holds the IPv6 addresses of all network interfaces.
+	IPv6Addresses []string
+
 	// Volumes holds all known volumes
 	Volumes []Volume
 
+	// LaunchTime holds the time at which the instance was launched.
+	LaunchTime time.Time
+
+	// Uptime contains the number of days since the instance was launched.
+	Uptime int
+
+	// IAMInstanceProfile holds the ARN of the instance profile, if any.
+	IAMInstanceProfile string
+
+	// SecurityGroups holds the security-groups attached to the instance.
+	SecurityGroups []SecurityGroup
+
+	// Platform holds the platform details ("Linux/UNIX", "Windows", etc).
+	Platform string
+
+	// Architecture holds the CPU architecture ("x86_64", "arm64", etc).
+	Architecture string
+
+	// Lifecycle is "spot", "scheduled" or "on-demand".
+	Lifecycle string
+
+	// Tenancy holds the instance tenancy ("default", "dedicated", etc).
+	Tenancy string
+
+	// EBSOptimized is true if the instance is optimized for EBS I/O.
+	EBSOptimized bool
+
+	// Monitoring holds the state of detailed monitoring.
+	Monitoring string
+
+	// MetadataHTTPTokens is "required" if IMDSv2 is enforced, otherwise
+	// "optional".
+	MetadataHTTPTokens string
+
 	// VPCID is the ID of the VPC the instance is running within.
 	VPCID string
+
+	// VPCName is the name of the VPC the instance is running within.
+	VPCName string
+
+	// StateReason is the reason for the most recent state transition,
+	// for example "User initiated (2021-06-01 12:00:00 GMT)".
+	StateReason string
+
+	// StoppedAt is the time the instance was stopped, as parsed from
+	// StateReason, if known.
+	StoppedAt time.Time
+
+	// volume is the single volume this entry describes, when instances
+	// have been expanded via PerVolume.
+	volume *Volume
+}
+
+// volumeBatchSize is the number of volume IDs we'll lookup with a single
+// call to DescribeVolumes.
+const volumeBatchSize = 200
+
+// stoppedRegexp matches the time within a StateTransitionReason.
+var stoppedRegexp = regexp.MustCompile(`\((\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) GMT\)`)
+
+// ParseStateReason returns the time contained within the given
+// StateTransitionReason, such as "User initiated (2021-06-01 12:00:00 GMT)".
+func ParseStateReason(reason string) (time.Time, bool) {
+
+	m := stoppedRegexp.FindStringSubmatch(reason)
+	if m == nil {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse("2006-01-02 15:04:05", m[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
 }
 
 // GetInstances returns details about our running instances.
 func GetInstances(svc *ec2.EC2, acct string) ([]InstanceOutput, error) {
+	return GetInstancesByState(svc, acct, "running", "pending")
+}
+
+// GetInstancesByState returns details about our instances which are in
+// any of the given states.
+//
+// The instances are retrieved first, and then the volumes attached to all
+// of them are looked up in a small number of batched calls, rather than
+// once per instance.
+func GetInstancesByState(svc *ec2.EC2, acct string, states ...string) ([]InstanceOutput, error) {
 
 	// Our return value
 	ret := []InstanceOutput{}
 
-	// Get the instances which are running/pending
+	// Get the instances which are in the given states
 	params := &ec2.DescribeInstancesInput{
 		Filters: []*ec2.Filter{
 			{
 				Name:   aws.String("instance-state-name"),
-				Values: []*string{aws.String("running"), aws.String("pending")},
+				Values: aws.StringSlice(states),
 			},
 		},
 	}
 
-	// Create new EC2 client
-	result, err := svc.DescribeInstances(params)
+	// The instances we've found, across all pages.
+	found := []*ec2.Instance{}
+
+	err := svc.DescribeInstancesPages(params, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, reservation := range page.Reservations {
+			found = append(found, reservation.Instances...)
+		}
+		return true
+	})
 	if err != nil {
 		return ret, fmt.Errorf("DescribeInstances failed: %s", err)
 	}
 
+	// Collect the IDs of every EBS volume attached to the instances
+	volIDs := []string{}
+	for _, instance := range found {
+		for _, bd := range instance.BlockDeviceMappings {
+			if bd.Ebs != nil && bd.Ebs.VolumeId != nil {
+				volIDs = append(volIDs, *bd.Ebs.VolumeId)
+			}
+		}
+	}
+
+	// Resolve them all at once
+	volumes, err := describeVolumes(svc, volIDs)
+	if err != nil {
+		return ret, fmt.Errorf("failed to read devices %s", err)
+	}
+
+	// Find the elastic IPs, so we can report their allocation IDs.
+	//
+	// This, and the names of subnets and VPCs, are optional details so
+	// roles which lack permission to look them up may still be used.
+	eips, err := describeAddresses(svc)
+	if err != nil && !permissionDenied(err) {
+		return ret, fmt.Errorf("DescribeAddresses failed: %s", err)
+	}
+
+	// Find the names of the subnets and VPCs.
+	subnets, err := subnetNames(svc)
+	if err != nil && !permissionDenied(err) {
+		return ret, fmt.Errorf("DescribeSubnets failed: %s", err)
+	}
+	vpcs, err := vpcNames(svc)
+	if err != nil && !permissionDenied(err) {
+		return ret, fmt.Errorf("DescribeVpcs failed: %s", err)
+	}
+
 	// For each instance build up an object to describe it
-	for _, reservation := range result.Reservations {
+	for _, instance := range found {
 
 		// The structure to output for this instance
 		var out InstanceOutput
 
-		for _, instance := range reservation.Instances {
-
-			// We have a running EC2 instance, we'll populate
-			// the InstanceOutput structure with details.
-
-			// Values which are always present.
-			out.AWSAccount = acct
-			out.AvailabilityZone = *instance.Placement.AvailabilityZone
-			out.SubnetID = *instance.SubnetId
-			out.VPCID = *instance.VpcId
-			out.InstanceID = *instance.InstanceId
-			out.InstanceName = *instance.InstanceId
-			out.InstanceState = *instance.State.Name
-			out.InstanceType = *instance.InstanceType
-			out.InstanceAMI = *instance.ImageId
-
-			// Get the AMI age, in days.
-			out.AMIAge, err = amiage.AMIAge(svc, out.InstanceAMI)
-			if err != nil {
-				if !errors.Is(err, amiage.NotFound) {
-					return ret, fmt.Errorf("error getting AMI age for %s: %s", out.InstanceAMI, err)
-				}
-			}
+		// We have a running EC2 instance, we'll populate
+		// the InstanceOutput structure with details.
+
+		// Values which are always present.
+		out.AWSAccount = acct
+		out.AvailabilityZone = *instance.Placement.AvailabilityZone
+		out.SubnetID = *instance.SubnetId
+		out.VPCID = *instance.VpcId
+		out.SubnetName = subnets[out.SubnetID]
+		if out.SubnetName == "" {
+			out.SubnetName = out.SubnetID
+		}
+		out.VPCName = vpcs[out.VPCID]
+		if out.VPCName == "" {
+			out.VPCName = out.VPCID
+		}
+		out.InstanceID = *instance.InstanceId
+		out.InstanceName = *instance.InstanceId
+		out.InstanceState = *instance.State.Name
+		out.StateReason = aws.StringValue(instance.StateTransitionReason)
+		if out.InstanceState == "stopped" {
+			out.StoppedAt, _ = ParseStateReason(out.StateReason)
+		}
+		out.InstanceType = *instance.InstanceType
+		out.InstanceAMI = *instance.ImageId
 
-			// Look for the name, which is set via a Tag.
-			//
-			// Default back to the InstanceID if no name was set.
-			out.InstanceName = tag2name.Lookup(instance.Tags, *instance.InstanceId)
+		// The number of vCPUs is the number of cores multiplied by
+		// the number of threads per core.
+		if instance.CpuOptions != nil {
+			out.VCPUs = int(aws.Int64Value(instance.CpuOptions.CoreCount) * aws.Int64Value(instance.CpuOptions.ThreadsPerCore))
+		}
 
-			// Optional values
-			if instance.KeyName != nil {
-				out.SSHKeyName = *instance.KeyName
-			}
-			if instance.PublicIpAddress != nil {
-				out.PublicIPv4 = *instance.PublicIpAddress
-			}
-			if instance.PrivateIpAddress != nil {
-				out.PrivateIPv4 = *instance.PrivateIpAddress
-			}
+		// Get the AMI age, in days.
+		//
+		// The age is unknown, -1, if the AMI no longer exists or
+		// can't be looked up, perhaps due to missing permissions.
+		// Callers which must know, such as ami-check, look up the
+		// AMIs themselves to report such failures.
+		out.AMIAge, err = amiage.AMIAge(svc, out.InstanceAMI)
+		if err != nil {
+			out.AMIAge = -1
+		}
 
-			// Now the storage associated with the instance
-			vols, err := readBlockDevicesFromInstance(instance, svc)
-			if err == nil {
-				for _, x := range vols["ebs"].([]map[string]interface{}) {
-
-					out.Volumes = append(out.Volumes, Volume{
-						Device:    fmt.Sprintf("%s", x["device_name"]),
-						ID:        fmt.Sprintf("%s", x["id"]),
-						Size:      fmt.Sprintf("%d", x["volume_size"]),
-						Type:      fmt.Sprintf("%s", x["volume_type"]),
-						Encrypted: fmt.Sprintf("%t", x["encrypted"]),
-						IOPS:      fmt.Sprintf("%d", x["iops"])})
-				}
-			} else {
-				return ret, fmt.Errorf("failed to read devices %s", err)
-			}
+		// The owner is cached along with the age, so this is cheap.
+		out.AMIOwner, _ = amiage.AMIOwner(svc, out.InstanceAMI)
+
+		// Save all the tags.
+		out.Tags = tags.FromEC2(instance.Tags)
 
-			ret = append(ret, out)
+		// Look for the name, which is set via a Tag.
+		//
+		// Default back to the InstanceID if no name was set.
+		out.InstanceName = tags.Name(out.Tags, *instance.InstanceId)
+
+		// Optional values
+		if instance.KeyName != nil {
+			out.SSHKeyName = *instance.KeyName
+		}
+		if instance.PublicIpAddress != nil {
+			out.PublicIPv4 = *instance.PublicIpAddress
+		}
+		if instance.PrivateIpAddress != nil {
+			out.PrivateIPv4 = *instance.PrivateIpAddress
+		}
+
+		// Launch time, and the uptime derived from it.
+		if instance.LaunchTime != nil {
+			out.LaunchTime = *instance.LaunchTime
+			out.Uptime = int(time.Since(out.LaunchTime).Hours() / 24)
+		}
+
+		// Attributes which are useful for auditing.
+		if instance.IamInstanceProfile != nil {
+			out.IAMInstanceProfile = aws.StringValue(instance.IamInstanceProfile.Arn)
+		}
+		for _, sg := range instance.SecurityGroups {
+			out.SecurityGroups = append(out.SecurityGroups, SecurityGroup{
+				ID:   aws.StringValue(sg.GroupId),
+				Name: aws.StringValue(sg.GroupName),
+			})
 		}
+		out.Platform = aws.StringValue(instance.PlatformDetails)
+		if out.Platform == "" {
+			out.Platform = aws.StringValue(instance.Platform)
+		}
+		out.Architecture = aws.StringValue(instance.Architecture)
+		out.Lifecycle = aws.StringValue(instance.InstanceLifecycle)
+		if out.Lifecycle == "" {
+			out.Lifecycle = "on-demand"
+		}
+		out.Tenancy = aws.StringValue(instance.Placement.Tenancy)
+		out.EBSOptimized = aws.BoolValue(instance.EbsOptimized)
+		if instance.Monitoring != nil {
+			out.Monitoring = aws.StringValue(instance.Monitoring.State)
+		}
+		if instance.MetadataOptions != nil {
+			out.MetadataHTTPTokens = aws.StringValue(instance.MetadataOptions.HttpTokens)
+		}
+
+		// The network interfaces associated with the instance
+		out.NetworkInterfaces = instanceNetworkInterfaces(instance, eips)
+		for _, eni := range out.NetworkInterfaces {
+			out.IPv6Addresses = append(out.IPv6Addresses, eni.IPv6Addresses...)
+		}
+
+		// Now the storage associated with the instance
+		out.Volumes = instanceVolumes(instance, volumes)
+
+		ret = append(ret, out)
 	}
 
 	return ret, nil
 }
 
-func readBlockDevicesFromInstance(instance *ec2.Instance, conn *ec2.EC2) (map[string]interface{}, error) {
-	blockDevices := make(map[string]interface{})
-	blockDevices["ebs"] = make([]map[string]interface{}, 0)
+// AllIPs returns every address associated with the instance, private IPv4
+// addresses first, then public IPv4 addresses, and finally IPv6 addresses.
+func (i InstanceOutput) AllIPs() []string {
 
-	instanceBlockDevices := make(map[string]*ec2.InstanceBlockDeviceMapping)
-	for _, bd := range instance.BlockDeviceMappings {
-		if bd.Ebs != nil {
-			instanceBlockDevices[*bd.Ebs.VolumeId] = bd
+	private := []string{}
+	public := []string{}
+
+	for _, eni := range i.NetworkInterfaces {
+		for _, addr := range eni.Addresses {
+			private = append(private, addr.Private)
+			if addr.Public != "" {
+				public = append(public, addr.Public)
+			}
 		}
 	}
 
-	if len(instanceBlockDevices) == 0 {
-		return nil, nil
+	ret := append(private, public...)
+	return append(ret, i.IPv6Addresses...)
+}
+
+// EBSGiB returns the total size of all the volumes attached to the
+// instance, in GiB.
+func (i InstanceOutput) EBSGiB() int {
+
+	total := 0
+	for _, vol := range i.Volumes {
+		size, err := strconv.Atoi(vol.Size)
+		if err == nil {
+			total += size
+		}
 	}
+	return total
+}
 
-	volIDs := make([]*string, 0, len(instanceBlockDevices))
-	for volID := range instanceBlockDevices {
-		volIDs = append(volIDs, aws.String(volID))
+// ComputeCost returns the estimated monthly on-demand cost of the
+// instance itself, which is zero if it is stopped, and whether its type
+// has a known price.
+func (i InstanceOutput) ComputeCost() (float64, bool) {
+	if i.InstanceState == "stopped" || i.InstanceState == "stopping" {
+		return 0, true
 	}
+	return pricing.Prices.Instance(i.InstanceType)
+}
+
+// StorageCost returns the estimated monthly cost of all the volumes
+// attached to the instance, and whether every volume type has a known
+// price.
+func (i InstanceOutput) StorageCost() (float64, bool) {
+
+	total := 0.0
+	known := true
+	for _, vol := range i.Volumes {
+		cost, ok := pricing.Prices.Volume(vol.Type, atoi(vol.Size))
+		total += cost
+		known = known && ok
+	}
+	return total, known
+}
+
+// MonthlyCost returns the estimated monthly cost of the instance, and its
+// volumes, rounded to the nearest cent.
+//
+// The cost is only known if the instance type, and the type of each
+// volume, have known prices.
+func (i InstanceOutput) MonthlyCost() (float64, bool) {
+	compute, ok1 := i.ComputeCost()
+	storage, ok2 := i.StorageCost()
+	return pricing.Round(compute + storage), ok1 && ok2
+}
 
-	// Need to call DescribeVolumes to get volume_size and volume_type for each
-	// EBS block device
-	volResp, err := conn.DescribeVolumes(&ec2.DescribeVolumesInput{
-		VolumeIds: volIDs,
+// Cost returns the estimated monthly cost of the instance, and its
+// volumes, for use in templates.
+//
+// "unknown" is returned if any part of the cost has no known price.
+func (i InstanceOutput) Cost() string {
+	cost, ok := i.MonthlyCost()
+	if !ok {
+		return "unknown"
+	}
+	return fmt.Sprintf("%.2f", cost)
+}
+
+// PerVolume expands the given instances into one entry for each of their
+// volumes, allowing the "vol-" fields to be used.
+//
+// Instances without volumes are omitted.
+func PerVolume(objs []InstanceOutput) []InstanceOutput {
+
+	ret := []InstanceOutput{}
+	for _, obj := range objs {
+		for n := range obj.Volumes {
+			cur := obj
+			cur.volume = &obj.Volumes[n]
+			ret = append(ret, cur)
+		}
+	}
+	return ret
+}
+
+// subnetNames returns a map of subnet IDs to their names.
+func subnetNames(svc *ec2.EC2) (map[string]string, error) {
+
+	ret := make(map[string]string)
+
+	err := svc.DescribeSubnetsPages(&ec2.DescribeSubnetsInput{}, func(page *ec2.DescribeSubnetsOutput, lastPage bool) bool {
+		for _, subnet := range page.Subnets {
+			// Get the name, via tags, if present
+			ret[*subnet.SubnetId] = tags.Name(tags.FromEC2(subnet.Tags), *subnet.SubnetId)
+		}
+		return true
 	})
+
+	return ret, err
+}
+
+// vpcNames returns a map of VPC IDs to their names.
+func vpcNames(svc *ec2.EC2) (map[string]string, error) {
+
+	ret := make(map[string]string)
+
+	err := svc.DescribeVpcsPages(&ec2.DescribeVpcsInput{}, func(page *ec2.DescribeVpcsOutput, lastPage bool) bool {
+		for _, vpc := range page.Vpcs {
+			// Get the name, via tags, if present
+			ret[*vpc.VpcId] = tags.Name(tags.FromEC2(vpc.Tags), *vpc.VpcId)
+		}
+		return true
+	})
+
+	return ret, err
+}
+
+// permissionDenied returns true if the given error was caused by a lack
+// of permission, rather than some other failure.
+func permissionDenied(err error) bool {
+
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "UnauthorizedOperation", "AccessDenied", "AccessDeniedException":
+			return true
+		}
+	}
+	return false
+}
+
+// describeAddresses returns a map of elastic IP addresses to their
+// allocation IDs.
+func describeAddresses(svc *ec2.EC2) (map[string]string, error) {
+
+	ret := make(map[string]string)
+
+	out, err := svc.DescribeAddresses(&ec2.DescribeAddressesInput{})
 	if err != nil {
-		return nil, err
+		return ret, err
 	}
 
-	for _, vol := range volResp.Volumes {
-		instanceBd := instanceBlockDevices[*vol.VolumeId]
-		bd := make(map[string]interface{})
+	for _, addr := range out.Addresses {
+		if addr.PublicIp != nil && addr.AllocationId != nil {
+			ret[*addr.PublicIp] = *addr.AllocationId
+		}
+	}
 
-		bd["id"] = *vol.VolumeId
-		if instanceBd.Ebs != nil && instanceBd.Ebs.DeleteOnTermination != nil {
-			bd["delete_on_termination"] = *instanceBd.Ebs.DeleteOnTermination
+	return ret, nil
+}
+
+// instanceNetworkInterfaces returns the network interfaces attached to the
+// given instance, ordered by their device index.
+func instanceNetworkInterfaces(instance *ec2.Instance, eips map[string]string) []NetworkInterface {
+
+	ret := []NetworkInterface{}
+
+	for _, ni := range instance.NetworkInterfaces {
+
+		eni := NetworkInterface{
+			ID:       aws.StringValue(ni.NetworkInterfaceId),
+			SubnetID: aws.StringValue(ni.SubnetId),
 		}
-		if vol.Size != nil {
-			bd["volume_size"] = *vol.Size
+		if ni.Attachment != nil {
+			eni.DeviceIndex = int(aws.Int64Value(ni.Attachment.DeviceIndex))
 		}
-		if vol.VolumeType != nil {
-			bd["volume_type"] = *vol.VolumeType
+
+		for _, addr := range ni.PrivateIpAddresses {
+			ip := IPAddress{
+				Private: aws.StringValue(addr.PrivateIpAddress),
+				Primary: aws.BoolValue(addr.Primary),
+			}
+			if addr.Association != nil {
+				ip.Public = aws.StringValue(addr.Association.PublicIp)
+				ip.AllocationID = eips[ip.Public]
+			}
+			eni.Addresses = append(eni.Addresses, ip)
 		}
-		if vol.Iops != nil {
-			bd["iops"] = *vol.Iops
+
+		for _, addr := range ni.Ipv6Addresses {
+			eni.IPv6Addresses = append(eni.IPv6Addresses, aws.StringValue(addr.Ipv6Address))
 		}
 
-		if instanceBd.DeviceName != nil {
-			bd["device_name"] = *instanceBd.DeviceName
+		for _, sg := range ni.Groups {
+			eni.SecurityGroups = append(eni.SecurityGroups, SecurityGroup{
+				ID:   aws.StringValue(sg.GroupId),
+				Name: aws.StringValue(sg.GroupName),
+			})
 		}
-		if vol.Encrypted != nil {
-			bd["encrypted"] = *vol.Encrypted
+
+		ret = append(ret, eni)
+	}
+
+	sort.Slice(ret, func(a, b int) bool {
+		return ret[a].DeviceIndex < ret[b].DeviceIndex
+	})
+
+	return ret
+}
+
+// describeVolumes looks up the given volume IDs, in batches, and returns
+// a map of volume ID to the volume details.
+//
+// The IDs are given as a filter, rather than via VolumeIds, so that a
+// volume which has been deleted is omitted rather than failing the whole
+// batch with InvalidVolume.NotFound.
+func describeVolumes(svc *ec2.EC2, ids []string) (map[string]*ec2.Volume, error) {
+
+	ret := make(map[string]*ec2.Volume)
+
+	for len(ids) > 0 {
+
+		// Take the next batch of IDs
+		n := volumeBatchSize
+		if n > len(ids) {
+			n = len(ids)
+		}
+		batch := aws.StringSlice(ids[:n])
+		ids = ids[n:]
+
+		err := svc.DescribeVolumesPages(&ec2.DescribeVolumesInput{
+			Filters: []*ec2.Filter{
+				{
+					Name:   aws.String("volume-id"),
+					Values: batch,
+				},
+			},
+		}, func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+			for _, vol := range page.Volumes {
+				ret[*vol.VolumeId] = vol
+			}
+			return true
+		})
+		if err != nil {
+			return ret, err
 		}
-		if vol.SnapshotId != nil {
-			bd["snapshot_id"] = *vol.SnapshotId
+	}
+
+	return ret, nil
+}
+
+// instanceVolumes returns the volumes attached to the given instance,
+// using the details previously retrieved via describeVolumes.
+func instanceVolumes(instance *ec2.Instance, volumes map[string]*ec2.Volume) []Volume {
+
+	ret := []Volume{}
+
+	for _, bd := range instance.BlockDeviceMappings {
+		if bd.Ebs == nil || bd.Ebs.VolumeId == nil {
+			continue
+		}
+
+		vol, ok := volumes[*bd.Ebs.VolumeId]
+		if !ok {
+			continue
 		}
 
-		blockDevices["ebs"] = append(blockDevices["ebs"].([]map[string]interface{}), bd)
+		ret = append(ret, Volume{
+			Device:              aws.StringValue(bd.DeviceName),
+			ID:                  aws.StringValue(vol.VolumeId),
+			Size:                fmt.Sprintf("%d", aws.Int64Value(vol.Size)),
+			Type:                aws.StringValue(vol.VolumeType),
+			Encrypted:           fmt.Sprintf("%t", aws.BoolValue(vol.Encrypted)),
+			IOPS:                fmt.Sprintf("%d", aws.Int64Value(vol.Iops)),
+			Throughput:          fmt.Sprintf("%d", aws.Int64Value(vol.Throughput)),
+			KMSKeyID:            aws.StringValue(vol.KmsKeyId),
+			SnapshotID:          aws.StringValue(vol.SnapshotId),
+			State:               aws.StringValue(vol.State),
+			DeleteOnTermination: fmt.Sprintf("%t", aws.BoolValue(bd.Ebs.DeleteOnTermination)),
+			Tags:                tags.FromEC2(vol.Tags),
+		})
 	}
 
-	return blockDevices, nil
+	return ret
 }
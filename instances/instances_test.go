@@ -0,0 +1,340 @@
+package instances
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/skx/aws-utils/pricing"
+)
+
+// TestPermissionDenied tests that permission errors are recognized.
+func TestPermissionDenied(t *testing.T) {
+
+	type TestCase struct {
+		Err    error
+		Result bool
+	}
+
+	tests := []TestCase{
+		{awserr.New("UnauthorizedOperation", "You are not authorized", nil), true},
+		{awserr.New("AccessDenied", "Access denied", nil), true},
+		{awserr.New("AccessDeniedException", "Access denied", nil), true},
+		{awserr.New("RequestLimitExceeded", "Slow down", nil), false},
+		{fmt.Errorf("network unreachable"), false},
+	}
+
+	for _, test := range tests {
+		if permissionDenied(test.Err) != test.Result {
+			t.Errorf("%s: expected %t", test.Err, test.Result)
+		}
+	}
+}
+
+// TestParseStateReason tests finding the time an instance was stopped.
+func TestParseStateReason(t *testing.T) {
+
+	type TestCase struct {
+		Input  string
+		Output time.Time
+		OK     bool
+	}
+
+	tests := []TestCase{
+		{"User initiated (2021-06-01 12:34:56 GMT)", time.Date(2021, 6, 1, 12, 34, 56, 0, time.UTC), true},
+		{"Service initiated (2019-12-31 23:59:59 GMT)", time.Date(2019, 12, 31, 23, 59, 59, 0, time.UTC), true},
+		{"(2021-06-01 12:34:56 GMT) and (2022-01-01 00:00:00 GMT)", time.Date(2021, 6, 1, 12, 34, 56, 0, time.UTC), true},
+		{"", time.Time{}, false},
+		{"Server.ScheduledStop", time.Time{}, false},
+		{"User initiated (2021-06-01 12:34:56 UTC)", time.Time{}, false},
+		{"User initiated (2021-13-45 12:34:56 GMT)", time.Time{}, false},
+		{"User initiated (2021-06-01)", time.Time{}, false},
+	}
+
+	for _, test := range tests {
+
+		out, ok := ParseStateReason(test.Input)
+		if ok != test.OK {
+			t.Errorf("%q: expected ok=%t, got %t", test.Input, test.OK, ok)
+			continue
+		}
+		if !out.Equal(test.Output) {
+			t.Errorf("%q: expected %s, got %s", test.Input, test.Output, out)
+		}
+	}
+}
+
+// TestCost tests estimating the monthly cost of instances.
+func TestCost(t *testing.T) {
+
+	// Use a known table, restoring the real one once we're done.
+	orig := pricing.Prices
+	defer func() {
+		pricing.Prices = orig
+	}()
+
+	var err error
+	pricing.Prices, err = pricing.Parse([]byte(`{"instances": {"t3.micro": 0.01}, "volumes": {"gp2": 0.1, "gp3": 0.08}}`))
+	if err != nil {
+		t.Fatalf("failed to parse prices: %s", err)
+	}
+
+	vols := []Volume{{Size: "50", Type: "gp2"}, {Size: "100", Type: "gp3"}, {Size: "bogus", Type: "gp2"}}
+	unpriced := append([]Volume{{Size: "10", Type: "io9"}}, vols...)
+
+	type TestCase struct {
+		State   string
+		Type    string
+		Volumes []Volume
+		Cost    float64
+		Known   bool
+		Output  string
+	}
+
+	tests := []TestCase{
+		{"running", "t3.micro", nil, 7.3, true, "7.30"},
+		{"pending", "t3.micro", nil, 7.3, true, "7.30"},
+		{"running", "x9.bogus", nil, 0, false, "unknown"},
+		{"stopped", "t3.micro", nil, 0, true, "0.00"},
+		{"stopping", "x9.bogus", nil, 0, true, "0.00"},
+		{"running", "t3.micro", vols, 20.3, true, "20.30"},
+		{"stopped", "t3.micro", vols, 13, true, "13.00"},
+		{"running", "x9.bogus", vols, 13, false, "unknown"},
+		{"running", "t3.micro", unpriced, 20.3, false, "unknown"},
+	}
+
+	cost, _ := LookupField("cost")
+
+	for _, test := range tests {
+
+		obj := InstanceOutput{InstanceState: test.State, InstanceType: test.Type, Volumes: test.Volumes}
+
+		c, known := obj.MonthlyCost()
+		if c != test.Cost || known != test.Known {
+			t.Errorf("%s %s: expected cost %f/%t, got %f/%t", test.State, test.Type, test.Cost, test.Known, c, known)
+		}
+		if out := obj.Cost(); out != test.Output {
+			t.Errorf("%s %s: expected %s, got %s", test.State, test.Type, test.Output, out)
+		}
+
+		// The field is empty, rather than zero, if the cost is unknown.
+		field := cost.String(obj)
+		if !test.Known && field != "" {
+			t.Errorf("%s %s: expected empty cost field, got %s", test.State, test.Type, field)
+		}
+		if test.Known && field != test.Output {
+			t.Errorf("%s %s: expected cost field %s, got %s", test.State, test.Type, test.Output, field)
+		}
+	}
+}
+
+// fakeEC2 returns an EC2 client whose requests are answered by the given
+// function, rather than AWS.
+func fakeEC2(handler func(r *request.Request)) *ec2.EC2 {
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("eu-west-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+
+	svc := ec2.New(sess)
+	svc.Handlers.Send.Clear()
+	svc.Handlers.Unmarshal.Clear()
+	svc.Handlers.UnmarshalMeta.Clear()
+	svc.Handlers.ValidateResponse.Clear()
+	svc.Handlers.Send.PushBack(handler)
+	return svc
+}
+
+// TestDescribeVolumes tests looking up volumes in batches, ignoring those
+// which no longer exist.
+func TestDescribeVolumes(t *testing.T) {
+
+	ids := []string{}
+	for i := 0; i < 450; i++ {
+		ids = append(ids, fmt.Sprintf("vol-%d", i))
+	}
+
+	batches := []int{}
+	svc := fakeEC2(func(r *request.Request) {
+		in := r.Params.(*ec2.DescribeVolumesInput)
+		out := r.Data.(*ec2.DescribeVolumesOutput)
+
+		if len(in.VolumeIds) != 0 || len(in.Filters) != 1 || aws.StringValue(in.Filters[0].Name) != "volume-id" {
+			t.Errorf("volumes weren't looked up via a filter: %v", in)
+		}
+
+		values := in.Filters[0].Values
+		batches = append(batches, len(values))
+
+		// Every tenth volume has been deleted
+		for n, id := range values {
+			if n%10 != 0 {
+				out.Volumes = append(out.Volumes, &ec2.Volume{VolumeId: id, Size: aws.Int64(8)})
+			}
+		}
+	})
+
+	vols, err := describeVolumes(svc, ids)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fmt.Sprintf("%v", batches) != "[200 200 50]" {
+		t.Errorf("unexpected batches %v", batches)
+	}
+	if len(vols) != 405 {
+		t.Errorf("expected 405 volumes, got %d", len(vols))
+	}
+	if _, ok := vols["vol-0"]; ok {
+		t.Errorf("deleted volume was found")
+	}
+	if aws.Int64Value(vols["vol-449"].Size) != 8 {
+		t.Errorf("volume details are missing")
+	}
+
+	// Errors are returned.
+	svc = fakeEC2(func(r *request.Request) {
+		r.Error = awserr.New("UnauthorizedOperation", "You are not authorized", nil)
+	})
+	svc.Handlers.Retry.Clear()
+	if _, err = describeVolumes(svc, ids); err == nil {
+		t.Errorf("expected error, got none")
+	}
+}
+
+// TestInstanceVolumes tests finding the volumes attached to an instance.
+func TestInstanceVolumes(t *testing.T) {
+
+	instance := &ec2.Instance{
+		BlockDeviceMappings: []*ec2.InstanceBlockDeviceMapping{
+			{DeviceName: aws.String("/dev/sda1"), Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-1"), DeleteOnTermination: aws.Bool(true)}},
+			{DeviceName: aws.String("/dev/sdb"), Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-deleted")}},
+			{DeviceName: aws.String("/dev/sdc")},
+			{DeviceName: aws.String("/dev/sdd"), Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-2")}},
+		},
+	}
+
+	volumes := map[string]*ec2.Volume{
+		"vol-1": {VolumeId: aws.String("vol-1"), Size: aws.Int64(16), VolumeType: aws.String("gp3"),
+			Encrypted: aws.Bool(true), Iops: aws.Int64(3000), Throughput: aws.Int64(125),
+			KmsKeyId: aws.String("key"), SnapshotId: aws.String("snap-1"), State: aws.String("in-use"),
+			Tags: []*ec2.Tag{{Key: aws.String("Backup"), Value: aws.String("daily")}}},
+		"vol-2": {VolumeId: aws.String("vol-2"), Size: aws.Int64(100), VolumeType: aws.String("st1")},
+	}
+
+	out := instanceVolumes(instance, volumes)
+	if len(out) != 2 {
+		t.Fatalf("expected 2 volumes, got %d", len(out))
+	}
+
+	v := out[0]
+	got := strings.Join([]string{v.Device, v.ID, v.Size, v.Type, v.Encrypted, v.IOPS, v.Throughput,
+		v.KMSKeyID, v.SnapshotID, v.State, v.DeleteOnTermination}, " ")
+	if got != "/dev/sda1 vol-1 16 gp3 true 3000 125 key snap-1 in-use true" {
+		t.Errorf("unexpected volume %+v", v)
+	}
+	if !reflect.DeepEqual(v.Tags, map[string]string{"Backup": "daily"}) {
+		t.Errorf("unexpected volume tags %v", v.Tags)
+	}
+
+	// Missing values are zero, rather than causing a panic.
+	if out[1].Device != "/dev/sdd" || out[1].IOPS != "0" || out[1].Encrypted != "false" || out[1].DeleteOnTermination != "false" {
+		t.Errorf("unexpected volume %+v", out[1])
+	}
+}
+
+// TestInstanceNetworkInterfaces tests finding the interfaces, and addresses,
+// of an instance.
+func TestInstanceNetworkInterfaces(t *testing.T) {
+
+	instance := &ec2.Instance{
+		NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+			{
+				NetworkInterfaceId: aws.String("eni-2"),
+				SubnetId:           aws.String("subnet-2"),
+				Attachment:         &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(1)},
+				PrivateIpAddresses: []*ec2.InstancePrivateIpAddress{
+					{PrivateIpAddress: aws.String("10.0.1.5"), Primary: aws.Bool(true)},
+				},
+			},
+			{
+				NetworkInterfaceId: aws.String("eni-1"),
+				SubnetId:           aws.String("subnet-1"),
+				Attachment:         &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(0)},
+				PrivateIpAddresses: []*ec2.InstancePrivateIpAddress{
+					{PrivateIpAddress: aws.String("10.0.0.5"), Primary: aws.Bool(true),
+						Association: &ec2.InstanceNetworkInterfaceAssociation{PublicIp: aws.String("3.1.1.1")}},
+					{PrivateIpAddress: aws.String("10.0.0.6"),
+						Association: &ec2.InstanceNetworkInterfaceAssociation{PublicIp: aws.String("3.2.2.2")}},
+				},
+				Ipv6Addresses: []*ec2.InstanceIpv6Address{{Ipv6Address: aws.String("2a05::1")}},
+				Groups:        []*ec2.GroupIdentifier{{GroupId: aws.String("sg-1"), GroupName: aws.String("web")}},
+			},
+		},
+	}
+
+	// Only 3.2.2.2 is an elastic IP.
+	eips := map[string]string{"3.2.2.2": "eipalloc-1"}
+
+	out := instanceNetworkInterfaces(instance, eips)
+
+	expected := []NetworkInterface{
+		{
+			ID:          "eni-1",
+			DeviceIndex: 0,
+			SubnetID:    "subnet-1",
+			Addresses: []IPAddress{
+				{Private: "10.0.0.5", Primary: true, Public: "3.1.1.1"},
+				{Private: "10.0.0.6", Public: "3.2.2.2", AllocationID: "eipalloc-1"},
+			},
+			IPv6Addresses:  []string{"2a05::1"},
+			SecurityGroups: []SecurityGroup{{ID: "sg-1", Name: "web"}},
+		},
+		{
+			ID:          "eni-2",
+			DeviceIndex: 1,
+			SubnetID:    "subnet-2",
+			Addresses:   []IPAddress{{Private: "10.0.1.5", Primary: true}},
+		},
+	}
+
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("unexpected interfaces:\n%+v\nexpected:\n%+v", out, expected)
+	}
+}
+
+// TestAllIPs tests listing every address of an instance.
+func TestAllIPs(t *testing.T) {
+
+	type TestCase struct {
+		Instance InstanceOutput
+		Result   string
+	}
+
+	tests := []TestCase{
+		{InstanceOutput{}, ""},
+		{InstanceOutput{
+			NetworkInterfaces: []NetworkInterface{
+				{Addresses: []IPAddress{{Private: "10.0.0.5", Public: "3.1.1.1"}, {Private: "10.0.0.6"}}},
+				{Addresses: []IPAddress{{Private: "10.0.1.5", Public: "3.2.2.2"}}},
+			},
+			IPv6Addresses: []string{"2a05::1", "2a05::2"},
+		}, "10.0.0.5 10.0.0.6 10.0.1.5 3.1.1.1 3.2.2.2 2a05::1 2a05::2"},
+		{InstanceOutput{IPv6Addresses: []string{"2a05::1"}}, "2a05::1"},
+	}
+
+	for _, test := range tests {
+		out := strings.Join(test.Instance.AllIPs(), " ")
+		if out != test.Result {
+			t.Errorf("expected %s, got %s", test.Result, out)
+		}
+	}
+}
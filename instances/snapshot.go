@@ -0,0 +1,208 @@
+package instances
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshot holds the details of all instances, at a particular time.
+//
+// Snapshots are saved as JSON, so that they may be compared later.
+type Snapshot struct {
+	// Created is the time at which the snapshot was taken.
+	Created time.Time
+
+	// Instances holds the instances which had not been terminated.
+	Instances []InstanceOutput
+}
+
+// Difference describes a single attribute which has changed.
+type Difference struct {
+	// Field is the name of the attribute which has changed.
+	Field string
+
+	// Old holds the previous value.
+	Old string
+
+	// New holds the current value.
+	New string
+
+	// Added is true if the attribute was not previously present, such
+	// as a tag which has been created.
+	Added bool `json:",omitempty"`
+
+	// Removed is true if the attribute is no longer present, such as a
+	// tag which has been deleted.
+	Removed bool `json:",omitempty"`
+}
+
+// Change describes an instance which is present in two snapshots, but
+// with different attributes.
+type Change struct {
+	// Instance is the instance, as it is now.
+	Instance InstanceOutput
+
+	// Differences holds the attributes which have changed.
+	Differences []Difference
+}
+
+// SnapshotDiff holds the differences between two snapshots.
+type SnapshotDiff struct {
+	// Added holds instances which are only present in the new snapshot.
+	Added []InstanceOutput
+
+	// Removed holds instances which are only present in the old snapshot.
+	Removed []InstanceOutput
+
+	// Changed holds instances present in both, which have changed.
+	Changed []Change
+}
+
+// SaveSnapshot writes the given instances to the named file.
+func SaveSnapshot(path string, objs []InstanceOutput) error {
+
+	snap := Snapshot{Created: time.Now(), Instances: objs}
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error exporting to JSON %s", err)
+	}
+
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", path, err)
+	}
+	return nil
+}
+
+// LoadSnapshot reads a snapshot previously written by SaveSnapshot.
+func LoadSnapshot(path string) (Snapshot, error) {
+
+	var snap Snapshot
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return snap, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+
+	err = json.Unmarshal(data, &snap)
+	if err != nil {
+		return snap, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	return snap, nil
+}
+
+// snapshotKey returns the key used to identify an instance in a snapshot.
+func snapshotKey(obj InstanceOutput) string {
+	return obj.AWSAccount + "/" + obj.InstanceID
+}
+
+// DiffSnapshots compares two snapshots, reporting the instances which have
+// been added, removed, or changed.
+func DiffSnapshots(old, cur Snapshot) SnapshotDiff {
+
+	ret := SnapshotDiff{}
+
+	before := make(map[string]InstanceOutput)
+	for _, obj := range old.Instances {
+		before[snapshotKey(obj)] = obj
+	}
+	after := make(map[string]InstanceOutput)
+	for _, obj := range cur.Instances {
+		after[snapshotKey(obj)] = obj
+	}
+
+	for key, obj := range after {
+		prev, ok := before[key]
+		if !ok {
+			ret.Added = append(ret.Added, obj)
+			continue
+		}
+
+		diffs := diffInstances(prev, obj)
+		if len(diffs) > 0 {
+			ret.Changed = append(ret.Changed, Change{Instance: obj, Differences: diffs})
+		}
+	}
+
+	for key, obj := range before {
+		if _, ok := after[key]; !ok {
+			ret.Removed = append(ret.Removed, obj)
+		}
+	}
+
+	// Sort everything, so output is stable.
+	byKey := func(objs []InstanceOutput) {
+		sort.Slice(objs, func(a, b int) bool {
+			return snapshotKey(objs[a]) < snapshotKey(objs[b])
+		})
+	}
+	byKey(ret.Added)
+	byKey(ret.Removed)
+	sort.Slice(ret.Changed, func(a, b int) bool {
+		return snapshotKey(ret.Changed[a].Instance) < snapshotKey(ret.Changed[b].Instance)
+	})
+
+	return ret
+}
+
+// diffInstances returns the attributes which differ between two versions
+// of the same instance.
+func diffInstances(old, cur InstanceOutput) []Difference {
+
+	ret := []Difference{}
+
+	compare := func(field, a, b string) {
+		if a != b {
+			ret = append(ret, Difference{Field: field, Old: a, New: b})
+		}
+	}
+
+	compare("name", old.InstanceName, cur.InstanceName)
+	compare("state", old.InstanceState, cur.InstanceState)
+	compare("type", old.InstanceType, cur.InstanceType)
+	compare("ami", old.InstanceAMI, cur.InstanceAMI)
+	compare("privateipv4", old.PrivateIPv4, cur.PrivateIPv4)
+	compare("publicipv4", old.PublicIPv4, cur.PublicIPv4)
+	compare("all-ips", strings.Join(old.AllIPs(), " "), strings.Join(cur.AllIPs(), " "))
+	compare("volumes", describeVolumeSet(old.Volumes), describeVolumeSet(cur.Volumes))
+
+	// Tags are compared one by one
+	keys := make(map[string]bool)
+	for k := range old.Tags {
+		keys[k] = true
+	}
+	for k := range cur.Tags {
+		keys[k] = true
+	}
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		a, inOld := old.Tags[k]
+		b, inCur := cur.Tags[k]
+		if inOld != inCur || a != b {
+			ret = append(ret, Difference{Field: "tag:" + k, Old: a, New: b, Added: !inOld, Removed: !inCur})
+		}
+	}
+
+	return ret
+}
+
+// describeVolumeSet returns a string describing a set of volumes, which
+// is used to detect changes.
+func describeVolumeSet(vols []Volume) string {
+
+	out := []string{}
+	for _, v := range vols {
+		out = append(out, fmt.Sprintf("%s:%s:%sGiB:%s", v.Device, v.ID, v.Size, v.Type))
+	}
+	sort.Strings(out)
+	return strings.Join(out, " ")
+}
@@ -0,0 +1,149 @@
+package instances
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestDiffSnapshots tests that instance changes are detected.
+func TestDiffSnapshots(t *testing.T) {
+
+	web := InstanceOutput{AWSAccount: "1", InstanceID: "i-1", InstanceName: "web", InstanceState: "running", InstanceType: "t3.small", Tags: map[string]string{"Owner": "alice", "Team": ""}}
+	db := InstanceOutput{AWSAccount: "1", InstanceID: "i-2", InstanceName: "db", InstanceState: "running"}
+	other := InstanceOutput{AWSAccount: "2", InstanceID: "i-2", InstanceName: "db", InstanceState: "running"}
+
+	type TestCase struct {
+		Name    string
+		Old     []InstanceOutput
+		New     []InstanceOutput
+		Added   []string
+		Removed []string
+		Changes []Difference
+	}
+
+	// modify returns a copy of the web instance, with changes.
+	modify := func(fn func(obj *InstanceOutput)) InstanceOutput {
+		obj := web
+		obj.Tags = map[string]string{}
+		for k, v := range web.Tags {
+			obj.Tags[k] = v
+		}
+		fn(&obj)
+		return obj
+	}
+
+	tests := []TestCase{
+		{Name: "unchanged",
+			Old: []InstanceOutput{web, db},
+			New: []InstanceOutput{db, web}},
+		{Name: "added and removed",
+			Old:     []InstanceOutput{web, db},
+			New:     []InstanceOutput{web, other},
+			Added:   []string{"2/i-2"},
+			Removed: []string{"1/i-2"}},
+		{Name: "stopped",
+			Old:     []InstanceOutput{web},
+			New:     []InstanceOutput{modify(func(o *InstanceOutput) { o.InstanceState = "stopped" })},
+			Changes: []Difference{{Field: "state", Old: "running", New: "stopped"}}},
+		{Name: "type and name",
+			Old: []InstanceOutput{web},
+			New: []InstanceOutput{modify(func(o *InstanceOutput) { o.InstanceType = "m5.large"; o.InstanceName = "www" })},
+			Changes: []Difference{
+				{Field: "name", Old: "web", New: "www"},
+				{Field: "type", Old: "t3.small", New: "m5.large"}}},
+		{Name: "tag removed",
+			Old:     []InstanceOutput{web},
+			New:     []InstanceOutput{modify(func(o *InstanceOutput) { delete(o.Tags, "Owner") })},
+			Changes: []Difference{{Field: "tag:Owner", Old: "alice", Removed: true}}},
+		{Name: "empty tag removed",
+			Old:     []InstanceOutput{web},
+			New:     []InstanceOutput{modify(func(o *InstanceOutput) { delete(o.Tags, "Team") })},
+			Changes: []Difference{{Field: "tag:Team", Removed: true}}},
+		{Name: "tag emptied",
+			Old:     []InstanceOutput{web},
+			New:     []InstanceOutput{modify(func(o *InstanceOutput) { o.Tags["Owner"] = "" })},
+			Changes: []Difference{{Field: "tag:Owner", Old: "alice"}}},
+		{Name: "tag added",
+			Old:     []InstanceOutput{web},
+			New:     []InstanceOutput{modify(func(o *InstanceOutput) { o.Tags["Env"] = "" })},
+			Changes: []Difference{{Field: "tag:Env", Added: true}}},
+		{Name: "volume",
+			Old: []InstanceOutput{web},
+			New: []InstanceOutput{modify(func(o *InstanceOutput) {
+				o.Volumes = []Volume{{Device: "/dev/xvda", ID: "vol-1", Size: "8", Type: "gp3"}}
+			})},
+			Changes: []Difference{{Field: "volumes", New: "/dev/xvda:vol-1:8GiB:gp3"}}},
+	}
+
+	for _, test := range tests {
+
+		diff := DiffSnapshots(Snapshot{Instances: test.Old}, Snapshot{Instances: test.New})
+
+		keys := func(objs []InstanceOutput) []string {
+			ret := []string{}
+			for _, obj := range objs {
+				ret = append(ret, snapshotKey(obj))
+			}
+			return ret
+		}
+		if test.Added == nil {
+			test.Added = []string{}
+		}
+		if test.Removed == nil {
+			test.Removed = []string{}
+		}
+		if !reflect.DeepEqual(keys(diff.Added), test.Added) {
+			t.Errorf("%s: added %v, expected %v", test.Name, keys(diff.Added), test.Added)
+		}
+		if !reflect.DeepEqual(keys(diff.Removed), test.Removed) {
+			t.Errorf("%s: removed %v, expected %v", test.Name, keys(diff.Removed), test.Removed)
+		}
+
+		changes := []Difference{}
+		for _, c := range diff.Changed {
+			changes = append(changes, c.Differences...)
+		}
+		if test.Changes == nil {
+			test.Changes = []Difference{}
+		}
+		if !reflect.DeepEqual(changes, test.Changes) {
+			t.Errorf("%s: changes %+v, expected %+v", test.Name, changes, test.Changes)
+		}
+	}
+}
+
+// TestSnapshotRoundTrip tests that snapshots may be saved and loaded.
+func TestSnapshotRoundTrip(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	objs := []InstanceOutput{
+		{AWSAccount: "1", InstanceID: "i-1", InstanceState: "stopped", Tags: map[string]string{"Team": ""}},
+	}
+
+	if err := SaveSnapshot(path, objs); err != nil {
+		t.Fatalf("failed to save: %s", err)
+	}
+
+	snap, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+
+	diff := DiffSnapshots(Snapshot{Instances: objs}, snap)
+	if len(diff.Added)+len(diff.Removed)+len(diff.Changed) != 0 {
+		t.Errorf("snapshot changed when saved: %+v", diff)
+	}
+
+	// Bogus files
+	if _, err := LoadSnapshot(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("expected error loading missing file")
+	}
+	bogus := filepath.Join(t.TempDir(), "bogus.json")
+	os.WriteFile(bogus, []byte("not json"), 0644)
+	if _, err := LoadSnapshot(bogus); err == nil {
+		t.Errorf("expected error loading bogus file")
+	}
+}
@@ -0,0 +1,132 @@
+package instances
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SortKey describes a field to sort upon, and the direction of the sort.
+type SortKey struct {
+	// Field is the field to sort by.
+	Field Field
+
+	// Descending is true if the sort should be reversed.
+	Descending bool
+}
+
+// ParseSort parses a comma-separated list of "field[:desc]" values into
+// the corresponding sort-keys.
+func ParseSort(spec string) ([]SortKey, error) {
+
+	ret := []SortKey{}
+
+	for _, part := range strings.Split(spec, ",") {
+
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+
+		key := SortKey{}
+
+		// Look for a direction
+		name := part
+		if idx := strings.Index(part, ":"); idx >= 0 {
+			name = part[:idx]
+			switch part[idx+1:] {
+			case "asc":
+			case "desc":
+				key.Descending = true
+			default:
+				return nil, fmt.Errorf("unknown sort direction '%s'", part[idx+1:])
+			}
+		}
+
+		f, ok := LookupField(name)
+		if !ok {
+			if _, vol := lookupVolumeField(name); vol {
+				return nil, fmt.Errorf("field '%s' is only available per-volume, and can't be used for sorting", name)
+			}
+			return nil, fmt.Errorf("unknown sort field '%s'", name)
+		}
+		key.Field = f
+		ret = append(ret, key)
+	}
+
+	return ret, nil
+}
+
+// Sort sorts the given instances, in-place, by the given keys.
+//
+// Numeric and time fields are sorted by value, all others are sorted
+// as strings.
+func Sort(objs []InstanceOutput, keys []SortKey) {
+
+	if len(keys) == 0 {
+		return
+	}
+
+	sort.SliceStable(objs, func(a, b int) bool {
+		for _, key := range keys {
+			c := compareFields(key.Field, objs[a], objs[b])
+			if c == 0 {
+				continue
+			}
+			if key.Descending {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+// compareFields compares the value of a field in two instances.
+//
+// Unknown values sort before all others.
+func compareFields(f Field, a, b InstanceOutput) int {
+
+	va, vb := f.Value(a), f.Value(b)
+	switch {
+	case va == nil && vb == nil:
+		return 0
+	case va == nil:
+		return -1
+	case vb == nil:
+		return 1
+	}
+
+	switch x := va.(type) {
+	case int:
+		y := vb.(int)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case float64:
+		y := vb.(float64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case time.Time:
+		y := vb.(time.Time)
+		switch {
+		case x.Before(y):
+			return -1
+		case x.After(y):
+			return 1
+		}
+		return 0
+	}
+
+	return strings.Compare(f.String(a), f.String(b))
+}
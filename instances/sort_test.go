@@ -0,0 +1,113 @@
+package instances
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestSort tests sorting instances by one or more fields.
+func TestSort(t *testing.T) {
+
+	objs := []InstanceOutput{
+		{InstanceID: "i-1", InstanceName: "web", AMIAge: 9, AWSAccount: "2", InstanceType: "t3.small", Volumes: []Volume{{Size: "8", Type: "gp3"}}},
+		{InstanceID: "i-2", InstanceName: "db", AMIAge: 100, AWSAccount: "1", InstanceType: "m5.large"},
+		{InstanceID: "i-3", InstanceName: "app", AMIAge: 30, AWSAccount: "2", InstanceType: "t3.micro"},
+		{InstanceID: "i-4", InstanceName: "cache", AMIAge: 30, AWSAccount: "1", InstanceType: "t3.nano"},
+	}
+
+	type TestCase struct {
+		Spec   string
+		Result string
+	}
+
+	tests := []TestCase{
+		{"", "i-1,i-2,i-3,i-4"},
+		{"name", "i-3,i-4,i-2,i-1"},
+		{"name:desc", "i-1,i-2,i-4,i-3"},
+		{"NAME:ASC", "i-3,i-4,i-2,i-1"},
+
+		// Numeric, rather than string, comparison
+		{"amiage", "i-1,i-3,i-4,i-2"},
+		{"amiage:desc", "i-2,i-3,i-4,i-1"},
+
+		// Multiple keys, and stability
+		{"account,amiage:desc", "i-2,i-4,i-3,i-1"},
+		{"amiage,name", "i-1,i-3,i-4,i-2"},
+
+		// Float fields
+		{"cost:desc", "i-2,i-1,i-3,i-4"},
+	}
+
+	for _, test := range tests {
+
+		keys, err := ParseSort(test.Spec)
+		if err != nil {
+			t.Errorf("unexpected error parsing %s: %s", test.Spec, err)
+			continue
+		}
+
+		sorted := make([]InstanceOutput, len(objs))
+		copy(sorted, objs)
+		Sort(sorted, keys)
+
+		ids := []string{}
+		for _, obj := range sorted {
+			ids = append(ids, obj.InstanceID)
+		}
+		if strings.Join(ids, ",") != test.Result {
+			t.Errorf("%s: expected %s, got %s", test.Spec, test.Result, strings.Join(ids, ","))
+		}
+	}
+}
+
+// TestSortUnknown tests that unknown values sort before all others.
+func TestSortUnknown(t *testing.T) {
+
+	objs := []InstanceOutput{
+		{InstanceID: "i-1", InstanceType: "t3.small"},
+		{InstanceID: "i-2", InstanceType: "x9.bogus"},
+		{InstanceID: "i-3", InstanceType: "t3.nano"},
+		{InstanceID: "i-4", InstanceType: "x9.other"},
+	}
+
+	type TestCase struct {
+		Spec   string
+		Result string
+	}
+
+	tests := []TestCase{
+		{"cost", "i-2,i-4,i-3,i-1"},
+		{"cost:desc", "i-1,i-3,i-2,i-4"},
+	}
+
+	for _, test := range tests {
+
+		keys, err := ParseSort(test.Spec)
+		if err != nil {
+			t.Errorf("unexpected error parsing %s: %s", test.Spec, err)
+			continue
+		}
+
+		sorted := make([]InstanceOutput, len(objs))
+		copy(sorted, objs)
+		Sort(sorted, keys)
+
+		ids := []string{}
+		for _, obj := range sorted {
+			ids = append(ids, obj.InstanceID)
+		}
+		if strings.Join(ids, ",") != test.Result {
+			t.Errorf("%s: expected %s, got %s", test.Spec, test.Result, strings.Join(ids, ","))
+		}
+	}
+}
+
+// TestParseSortErrors tests that bogus sort-keys are rejected.
+func TestParseSortErrors(t *testing.T) {
+
+	for _, spec := range []string{"bogus", "name:sideways", "name,bogus:desc", "vol-size"} {
+		if _, err := ParseSort(spec); err == nil {
+			t.Errorf("expected error parsing '%s', got none", spec)
+		}
+	}
+}
@@ -0,0 +1,478 @@
+package instances
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Where holds a parsed filter-expression, which may be used to select
+// instances by the values of their fields.
+//
+// Expressions are made up of comparisons against the fields known to the
+// registry, which may be combined via "&&", "||", "!", and parenthesis:
+//
+//	amiage > 90 && type =~ "^m5"
+//	state == running || name in ("bastion", "vpn")
+//
+// The supported operators are "==", "!=", "<", "<=", ">", ">=", "=~"
+// (regular expression match), "!~" (regular expression non-match), and
+// "in" (membership of a list of values).
+type Where struct {
+	root node
+}
+
+// node is a single element of a parsed expression.
+type node interface {
+	eval(obj InstanceOutput) (bool, error)
+}
+
+// andNode is true if both children are true.
+type andNode struct {
+	left, right node
+}
+
+// orNode is true if either child is true.
+type orNode struct {
+	left, right node
+}
+
+// notNode inverts the result of its child.
+type notNode struct {
+	child node
+}
+
+// compareNode compares the value of a field with one or more values.
+type compareNode struct {
+	field  Field
+	op     string
+	values []string
+	re     *regexp.Regexp
+}
+
+// ParseWhere parses the given expression, returning an error if it is
+// malformed or refers to unknown fields.
+func ParseWhere(input string) (*Where, error) {
+
+	toks, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected '%s' in expression", p.toks[p.pos].text)
+	}
+	return &Where{root: root}, nil
+}
+
+// Match returns true if the given instance matches the expression.
+func (w *Where) Match(obj InstanceOutput) (bool, error) {
+	return w.root.eval(obj)
+}
+
+// Filter returns the instances which match the expression.
+func (w *Where) Filter(objs []InstanceOutput) ([]InstanceOutput, error) {
+
+	ret := []InstanceOutput{}
+	for _, obj := range objs {
+		ok, err := w.Match(obj)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ret = append(ret, obj)
+		}
+	}
+	return ret, nil
+}
+
+func (n *andNode) eval(obj InstanceOutput) (bool, error) {
+	l, err := n.left.eval(obj)
+	if err != nil || !l {
+		return false, err
+	}
+	return n.right.eval(obj)
+}
+
+func (n *orNode) eval(obj InstanceOutput) (bool, error) {
+	l, err := n.left.eval(obj)
+	if err != nil || l {
+		return l, err
+	}
+	return n.right.eval(obj)
+}
+
+func (n *notNode) eval(obj InstanceOutput) (bool, error) {
+	r, err := n.child.eval(obj)
+	return !r, err
+}
+
+func (n *compareNode) eval(obj InstanceOutput) (bool, error) {
+
+	// Lists match if any of their members match, so everything
+	// is handled as a list here.
+	//
+	// Unknown values are empty lists, which no positive operator
+	// matches.
+	var have []string
+	switch v := n.field.Value(obj).(type) {
+	case nil:
+		have = []string{}
+	case []string:
+		have = v
+	default:
+		have = []string{n.field.String(obj)}
+	}
+
+	// The negated operators are true only if no member matches, so
+	// they're evaluated as the inverse of the positive operator.
+	op := n.op
+	switch op {
+	case "!=":
+		op = "=="
+	case "!~":
+		op = "=~"
+	}
+
+	found := false
+	for _, h := range have {
+		ok, err := n.compare(op, h)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			found = true
+			break
+		}
+	}
+
+	if op != n.op {
+		return !found, nil
+	}
+	return found, nil
+}
+
+// compare tests a single value against our operand(s), using the given
+// operator.
+func (n *compareNode) compare(op string, have string) (bool, error) {
+
+	switch op {
+	case "=~":
+		return n.re.MatchString(have), nil
+	case "in":
+		for _, v := range n.values {
+			if c, _ := compareValues(have, v); c == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	c, err := compareValues(have, n.values[0])
+	if err != nil {
+		return false, fmt.Errorf("field %s: %s", n.field.Key, err)
+	}
+
+	switch op {
+	case "==":
+		return c == 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+	return false, fmt.Errorf("unknown operator %s", op)
+}
+
+// compareValues compares two values, numerically if both are numbers,
+// chronologically if both are times, and otherwise as strings.
+//
+// The return value is negative, zero, or positive, like strings.Compare.
+func compareValues(a, b string) (int, error) {
+
+	// Numbers
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	// Times
+	t1, errA := parseTime(a)
+	t2, errB := parseTime(b)
+	if errA == nil && errB == nil {
+		switch {
+		case t1.Before(t2):
+			return -1, nil
+		case t1.After(t2):
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	return strings.Compare(a, b), nil
+}
+
+// parseTime parses a time in either RFC3339 or YYYY-MM-DD format.
+func parseTime(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// token is a single lexical element of an expression.
+type token struct {
+	// text holds the literal text of the token.
+	text string
+
+	// quoted is true for string literals, which are never operators.
+	quoted bool
+}
+
+// tokenize splits an expression into tokens.
+func tokenize(input string) ([]token, error) {
+
+	toks := []token{}
+	r := []rune(input)
+	i := 0
+
+	for i < len(r) {
+		c := r[i]
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '"' || c == '\'':
+			// Quoted string, with backslash escapes.
+			var sb strings.Builder
+			i++
+			for i < len(r) && r[i] != c {
+				if r[i] == '\\' && i+1 < len(r) {
+					i++
+				}
+				sb.WriteRune(r[i])
+				i++
+			}
+			if i >= len(r) {
+				return nil, fmt.Errorf("unterminated string in expression")
+			}
+			i++
+			toks = append(toks, token{text: sb.String(), quoted: true})
+
+		case c == '(' || c == ')' || c == ',':
+			toks = append(toks, token{text: string(c)})
+			i++
+
+		case strings.ContainsRune("=!<>&|~", c):
+			// Operators of one or two characters.
+			if i+1 < len(r) {
+				two := string(r[i : i+2])
+				switch two {
+				case "==", "!=", "<=", ">=", "=~", "!~", "&&", "||":
+					toks = append(toks, token{text: two})
+					i += 2
+					continue
+				}
+			}
+			switch c {
+			case '<', '>', '!':
+				toks = append(toks, token{text: string(c)})
+				i++
+			default:
+				return nil, fmt.Errorf("unexpected '%c' in expression", c)
+			}
+
+		default:
+			// Bare words; field names, numbers, and unquoted values.
+			start := i
+			for i < len(r) && !unicode.IsSpace(r[i]) && !strings.ContainsRune("()=!<>&|~,\"'", r[i]) {
+				i++
+			}
+			toks = append(toks, token{text: string(r[start:i])})
+		}
+	}
+
+	return toks, nil
+}
+
+// parser is a simple recursive-descent parser for expressions.
+type parser struct {
+	toks []token
+	pos  int
+}
+
+// peek returns the text of the next token, if it isn't a string literal.
+func (p *parser) peek() string {
+	if p.pos >= len(p.toks) || p.toks[p.pos].quoted {
+		return ""
+	}
+	return p.toks[p.pos].text
+}
+
+// next consumes and returns the next token.
+func (p *parser) next() (token, error) {
+	if p.pos >= len(p.toks) {
+		return token{}, fmt.Errorf("unexpected end of expression")
+	}
+	t := p.toks[p.pos]
+	p.pos++
+	return t, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+
+	switch p.peek() {
+	case "!":
+		p.pos++
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{child: child}, nil
+
+	case "(":
+		p.pos++
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing ')' in expression")
+		}
+		p.pos++
+		return n, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+
+	// The field name
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	field, ok := LookupField(strings.ToLower(t.text))
+	if t.quoted || !ok {
+		if _, vol := lookupVolumeField(strings.ToLower(t.text)); vol && !t.quoted {
+			return nil, fmt.Errorf("field '%s' is only available per-volume, and can't be used in expressions", t.text)
+		}
+		return nil, fmt.Errorf("unknown field '%s' in expression", t.text)
+	}
+
+	// The operator
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if op.quoted {
+		return nil, fmt.Errorf("expected operator after '%s'", field.Key)
+	}
+
+	n := &compareNode{field: field, op: op.text}
+
+	switch op.text {
+	case "in":
+		// A parenthesised list of values.
+		if p.peek() != "(" {
+			return nil, fmt.Errorf("expected '(' after 'in'")
+		}
+		p.pos++
+
+		// Values are separated by commas, and there must be at
+		// least one.
+		for {
+			v, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+			if !v.quoted && (v.text == ")" || v.text == "," || v.text == "(") {
+				return nil, fmt.Errorf("expected value in list, got '%s'", v.text)
+			}
+			n.values = append(n.values, v.text)
+
+			sep, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+			if !sep.quoted && sep.text == ")" {
+				break
+			}
+			if sep.quoted || sep.text != "," {
+				return nil, fmt.Errorf("expected ',' or ')' in list, got '%s'", sep.text)
+			}
+		}
+		return n, nil
+
+	case "==", "!=", "<", "<=", ">", ">=", "=~", "!~":
+		v, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		n.values = []string{v.text}
+
+		if op.text == "=~" || op.text == "!~" {
+			n.re, err = regexp.Compile(v.text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression %s: %s", v.text, err)
+			}
+		}
+		return n, nil
+	}
+
+	return nil, fmt.Errorf("unknown operator '%s' in expression", op.text)
+}
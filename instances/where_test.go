@@ -0,0 +1,171 @@
+package instances
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// whereInstances returns the instances our expression tests run against.
+func whereInstances() []InstanceOutput {
+	return []InstanceOutput{
+		{InstanceID: "i-1", InstanceName: "prod-web-1", InstanceType: "m5.large", InstanceState: "running",
+			AMIAge: 120, AvailabilityZone: "eu-central-1a",
+			IPv6Addresses:  []string{"2a05::1", "2a05::2"},
+			SecurityGroups: []SecurityGroup{{ID: "sg-web"}, {ID: "sg-ssh"}},
+			LaunchTime:     time.Date(2021, 1, 10, 0, 0, 0, 0, time.UTC)},
+		{InstanceID: "i-2", InstanceName: "prod-db-1", InstanceType: "r5.xlarge", InstanceState: "running",
+			AMIAge: 30, AvailabilityZone: "eu-central-1b",
+			IPv6Addresses:  []string{"2a05::3"},
+			SecurityGroups: []SecurityGroup{{ID: "sg-db"}},
+			LaunchTime:     time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{InstanceID: "i-3", InstanceName: "staging-web-1", InstanceType: "t3.small", InstanceState: "stopped",
+			AMIAge: 9, AvailabilityZone: "eu-west-1a",
+			LaunchTime: time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+}
+
+// TestWhere tests that expressions select the expected instances.
+func TestWhere(t *testing.T) {
+
+	type TestCase struct {
+		Expr   string
+		Result string
+	}
+
+	tests := []TestCase{
+		{`amiage > 90`, "i-1"},
+		{`amiage >= 30`, "i-1,i-2"},
+		{`amiage < 10`, "i-3"},
+		{`amiage <= 9`, "i-3"},
+		{`amiage == 30`, "i-2"},
+		{`amiage != 30`, "i-1,i-3"},
+		{`state == running`, "i-1,i-2"},
+		{`STATE == "running"`, "i-1,i-2"},
+		{`type =~ "^m5"`, "i-1"},
+		{`type !~ '^m5'`, "i-2,i-3"},
+		{`name =~ web && state == running`, "i-1"},
+		{`name =~ db || amiage < 10`, "i-2,i-3"},
+		{`!(state == running)`, "i-3"},
+		{`! state == running`, "i-3"},
+		{`state == running && (amiage > 100 || type =~ r5)`, "i-1,i-2"},
+		{`az in (eu-central-1a, eu-central-1b)`, "i-1,i-2"},
+		{`az in ("eu-west-1a")`, "i-3"},
+		{`launchtime < 2022-01-01`, "i-1"},
+		{`launchtime >= "2022-06-01T00:00:00Z"`, "i-2,i-3"},
+
+		// Lists match if any member matches
+		{`ipv6 == "2a05::2"`, "i-1"},
+		{`ipv6 =~ "^2a05"`, "i-1,i-2"},
+		{`security-groups in (sg-db, sg-ssh)`, "i-1,i-2"},
+
+		// Negated operators match only if no member matches
+		{`ipv6 != "2a05::2"`, "i-2,i-3"},
+		{`ipv6 != "2a05::9"`, "i-1,i-2,i-3"},
+		{`ipv6 !~ "::[12]$"`, "i-2,i-3"},
+		{`security-groups != sg-web`, "i-2,i-3"},
+
+		// Floating-point fields
+		{`cost > 100`, "i-2"},
+		{`cost == 0`, "i-3"},
+	}
+
+	for _, test := range tests {
+
+		w, err := ParseWhere(test.Expr)
+		if err != nil {
+			t.Errorf("unexpected error parsing %s: %s", test.Expr, err)
+			continue
+		}
+
+		out, err := w.Filter(whereInstances())
+		if err != nil {
+			t.Errorf("unexpected error filtering %s: %s", test.Expr, err)
+			continue
+		}
+
+		ids := []string{}
+		for _, obj := range out {
+			ids = append(ids, obj.InstanceID)
+		}
+		if strings.Join(ids, ",") != test.Result {
+			t.Errorf("%s: expected %s, got %s", test.Expr, test.Result, strings.Join(ids, ","))
+		}
+	}
+}
+
+// TestWhereUnknown tests that unknown values match no positive operator.
+func TestWhereUnknown(t *testing.T) {
+
+	obj := InstanceOutput{InstanceID: "i-1", InstanceType: "x9.bogus", InstanceState: "running"}
+
+	type TestCase struct {
+		Expr  string
+		Match bool
+	}
+
+	tests := []TestCase{
+		{`cost < 1000`, false},
+		{`cost >= 0`, false},
+		{`cost == ""`, false},
+		{`cost in (0, 1)`, false},
+		{`cost != 0`, true},
+		{`cost !~ "."`, true},
+		{`!(cost > 0)`, true},
+	}
+
+	for _, test := range tests {
+
+		w, err := ParseWhere(test.Expr)
+		if err != nil {
+			t.Errorf("unexpected error parsing %s: %s", test.Expr, err)
+			continue
+		}
+
+		ok, err := w.Match(obj)
+		if err != nil {
+			t.Errorf("unexpected error matching %s: %s", test.Expr, err)
+			continue
+		}
+		if ok != test.Match {
+			t.Errorf("%s: expected %t, got %t", test.Expr, test.Match, ok)
+		}
+	}
+}
+
+// TestWhereErrors tests that bogus expressions are rejected.
+func TestWhereErrors(t *testing.T) {
+
+	tests := []string{
+		``,
+		`amiage`,
+		`amiage >`,
+		`bogus == 3`,
+		`"name" == web`,
+		`name ~~ web`,
+		`name =~ "("`,
+		`name == web &&`,
+		`(name == web`,
+		`name == web)`,
+		`name == "web`,
+		`az in eu-west-1a`,
+		`az in ()`,
+		`az in (a b)`,
+		`az in (a, b c)`,
+		`az in (a,, b)`,
+		`az in (a, )`,
+		`az in (a, b`,
+		`vol-size > 10`,
+	}
+
+	for _, expr := range tests {
+		if _, err := ParseWhere(expr); err == nil {
+			t.Errorf("expected error parsing '%s', got none", expr)
+		}
+	}
+
+	_, err := ParseWhere("vol-size > 10")
+	if err == nil || !strings.Contains(err.Error(), "per-volume") {
+		t.Errorf("expected per-volume error, got %v", err)
+	}
+}
@@ -34,14 +34,31 @@ func main() {
 	//
 	// Register each of our subcommands.
 	//
+	subcommands.Register(&amiCheckCommand{})
+	subcommands.Register(&ansibleInventoryCommand{})
+	subcommands.Register(&completionCommand{})
+	subcommands.Register(&connectCommand{})
+	subcommands.Register(&costSummaryCommand{})
 	subcommands.Register(&csvInstancesCommand{})
+	subcommands.Register(&exportSQLiteCommand{})
+	subcommands.Register(&hostsCommand{})
+	subcommands.Register(&instanceEventsCommand{})
 	subcommands.Register(&instancesCommand{})
+	subcommands.Register(&inventoryDiffCommand{})
 	subcommands.Register(&ipCommand{})
 	subcommands.Register(&orphanedZonesCommand{})
+	subcommands.Register(&instanceActionCommand{action: "reboot"})
 	subcommands.Register(&rotateKeysCommand{})
+	subcommands.Register(&serveMetricsCommand{})
 	subcommands.Register(&sgGrepCommand{})
+	subcommands.Register(&sshConfigCommand{})
+	subcommands.Register(&staleInstancesCommand{})
+	subcommands.Register(&instanceActionCommand{action: "start"})
+	subcommands.Register(&instanceActionCommand{action: "stop"})
 	subcommands.Register(&stacksCommand{})
 	subcommands.Register(&subnetsCommand{})
+	subcommands.Register(&tagCommand{})
+	subcommands.Register(&tagComplianceCommand{})
 	subcommands.Register(&whitelistSelfCommand{})
 	subcommands.Register(&versionCommand{})
 	subcommands.Register(&whoamiCommand{})
@@ -0,0 +1,119 @@
+// Package pricing contains a table of on-demand EC2 and EBS prices, which
+// is used to estimate the monthly cost of instances.
+//
+// The table is embedded within the binary, so it will become out of date
+// over time.  Prices may be added, or updated, from a local JSON file in
+// the same format via Load.
+package pricing
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+// HoursPerMonth is the number of hours we assume each month contains.
+const HoursPerMonth = 730
+
+//go:embed prices.json
+var embedded []byte
+
+// Table holds the prices we know about.
+type Table struct {
+	// Region is the region the prices apply to.
+	Region string `json:"region"`
+
+	// Currency is the currency the prices are expressed in.
+	Currency string `json:"currency"`
+
+	// Instances holds the hourly on-demand price of each instance type.
+	Instances map[string]float64 `json:"instances"`
+
+	// Volumes holds the monthly price, per GiB, of each volume type.
+	Volumes map[string]float64 `json:"volumes"`
+}
+
+// Prices is the table which is used to estimate costs, it defaults to
+// the embedded table.
+var Prices = mustParse(embedded)
+
+// mustParse parses the given table, panicking on error.
+func mustParse(data []byte) *Table {
+	t, err := Parse(data)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Parse parses a price table from the given JSON.
+func Parse(data []byte) (*Table, error) {
+
+	t := &Table{}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, err
+	}
+	if t.Instances == nil {
+		t.Instances = make(map[string]float64)
+	}
+	if t.Volumes == nil {
+		t.Volumes = make(map[string]float64)
+	}
+	return t, nil
+}
+
+// Load reads a price table from the given file, and merges it into
+// Prices, replacing any existing entries.
+//
+// An empty path leaves Prices unchanged.
+func Load(path string) error {
+
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %s", path, err)
+	}
+
+	t, err := Parse(data)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %s", path, err)
+	}
+
+	if t.Region != "" {
+		Prices.Region = t.Region
+	}
+	if t.Currency != "" {
+		Prices.Currency = t.Currency
+	}
+	for k, v := range t.Instances {
+		Prices.Instances[k] = v
+	}
+	for k, v := range t.Volumes {
+		Prices.Volumes[k] = v
+	}
+	return nil
+}
+
+// Instance returns the estimated monthly cost of running an instance of
+// the given type, and whether the type has a known price.
+func (t *Table) Instance(instanceType string) (float64, bool) {
+	hourly, ok := t.Instances[instanceType]
+	return hourly * HoursPerMonth, ok
+}
+
+// Volume returns the estimated monthly cost of a volume of the given type
+// and size, in GiB, and whether the type has a known price.
+func (t *Table) Volume(volumeType string, gib int) (float64, bool) {
+	price, ok := t.Volumes[volumeType]
+	return price * float64(gib), ok
+}
+
+// Round rounds the given amount to the nearest cent.
+func Round(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,156 @@
+package pricing
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestEmbedded tests that the embedded table is valid.
+func TestEmbedded(t *testing.T) {
+
+	if Prices.Region == "" || Prices.Currency == "" {
+		t.Errorf("embedded table has no region or currency")
+	}
+	if len(Prices.Instances) == 0 || len(Prices.Volumes) == 0 {
+		t.Errorf("embedded table has no prices")
+	}
+	for k, v := range Prices.Instances {
+		if v <= 0 {
+			t.Errorf("instance type %s has invalid price %f", k, v)
+		}
+	}
+	for k, v := range Prices.Volumes {
+		if v <= 0 {
+			t.Errorf("volume type %s has invalid price %f", k, v)
+		}
+	}
+}
+
+// TestLoad tests merging local price tables into the embedded one.
+func TestLoad(t *testing.T) {
+
+	// Restore the embedded prices once we're done.
+	defer func() {
+		Prices = mustParse(embedded)
+	}()
+
+	type TestCase struct {
+		Input     string
+		Region    string
+		Instances map[string]float64
+		Volumes   map[string]float64
+		Error     string
+	}
+
+	tests := []TestCase{
+		{Input: `{}`,
+			Region: "us-east-1"},
+		{Input: `{"region": "eu-central-1", "currency": "EUR"}`,
+			Region: "eu-central-1"},
+		{Input: `{"instances": {"t2.micro": 1.5, "x9.bogus": 2}}`,
+			Region:    "eu-central-1",
+			Instances: map[string]float64{"t2.micro": 1.5, "x9.bogus": 2, "c5.large": 0.085}},
+		{Input: `{"volumes": {"gp3": 0.5}}`,
+			Region:  "eu-central-1",
+			Volumes: map[string]float64{"gp3": 0.5}},
+		{Input: `{"instances": [1, 2]}`,
+			Error: "error parsing"},
+		{Input: `not json`,
+			Error: "error parsing"},
+	}
+
+	for _, test := range tests {
+
+		path := filepath.Join(t.TempDir(), "prices.json")
+		err := os.WriteFile(path, []byte(test.Input), 0644)
+		if err != nil {
+			t.Fatalf("failed to write prices: %s", err)
+		}
+
+		err = Load(path)
+		if test.Error != "" {
+			if err == nil || !strings.Contains(err.Error(), test.Error) {
+				t.Errorf("%s: expected error %q, got %v", test.Input, test.Error, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.Input, err)
+			continue
+		}
+
+		if Prices.Region != test.Region {
+			t.Errorf("%s: expected region %s, got %s", test.Input, test.Region, Prices.Region)
+		}
+		for k, v := range test.Instances {
+			if Prices.Instances[k] != v {
+				t.Errorf("%s: expected %s to cost %f, got %f", test.Input, k, v, Prices.Instances[k])
+			}
+		}
+		for k, v := range test.Volumes {
+			if Prices.Volumes[k] != v {
+				t.Errorf("%s: expected %s to cost %f, got %f", test.Input, k, v, Prices.Volumes[k])
+			}
+		}
+	}
+
+	// An empty path leaves the prices alone, a missing one is an error.
+	if err := Load(""); err != nil {
+		t.Errorf("unexpected error loading empty path: %s", err)
+	}
+	err := Load(filepath.Join(t.TempDir(), "missing.json"))
+	if err == nil || !strings.Contains(err.Error(), "error reading") {
+		t.Errorf("expected error reading missing file, got %v", err)
+	}
+}
+
+// TestCosts tests the monthly costs of instances and volumes.
+func TestCosts(t *testing.T) {
+
+	table, err := Parse([]byte(`{"instances": {"t3.micro": 0.01}, "volumes": {"gp2": 0.1}}`))
+	if err != nil {
+		t.Fatalf("failed to parse table: %s", err)
+	}
+
+	cost, ok := table.Instance("t3.micro")
+	if !ok || Round(cost) != 7.3 {
+		t.Errorf("expected t3.micro to cost 7.3, got %f %t", cost, ok)
+	}
+	cost, ok = table.Instance("x9.bogus")
+	if ok || cost != 0 {
+		t.Errorf("expected unknown instance type to cost nothing, got %f %t", cost, ok)
+	}
+	cost, ok = table.Volume("gp2", 50)
+	if !ok || Round(cost) != 5 {
+		t.Errorf("expected 50GiB gp2 to cost 5, got %f %t", cost, ok)
+	}
+	cost, ok = table.Volume("io9", 50)
+	if ok || cost != 0 {
+		t.Errorf("expected unknown volume type to cost nothing, got %f %t", cost, ok)
+	}
+}
+
+// TestRound tests rounding to the nearest cent.
+func TestRound(t *testing.T) {
+
+	type TestCase struct {
+		Input  float64
+		Output float64
+	}
+
+	tests := []TestCase{
+		{0, 0},
+		{1.234, 1.23},
+		{1.235, 1.24},
+		{62.05000000001, 62.05},
+		{99.999, 100},
+	}
+
+	for _, test := range tests {
+		if out := Round(test.Input); out != test.Output {
+			t.Errorf("%f: expected %f, got %f", test.Input, test.Output, out)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+// Collecting, filtering, sorting, and matching of instances, along with
+// confirming actions upon them, shared by several sub-commands.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/skx/aws-utils/instances"
+)
+
+// instanceCollector gathers the instances of each account, via its
+// CollectInstances method, which is given to utils.HandleRoles.
+//
+// It is embedded in the commands which operate upon instances.
+type instanceCollector struct {
+
+	// states are the states of the instances to collect, if empty
+	// the running and pending instances are collected
+	states []string
+
+	// The instances we've found, across all accounts
+	results []instances.InstanceOutput
+
+	// The EC2 client of each account, for acting upon its instances
+	clients map[string]*ec2.EC2
+}
+
+// CollectInstances gathers the instances of each account.
+func (c *instanceCollector) CollectInstances(svc *ec2.EC2, acct string, void interface{}) error {
+
+	var ret []instances.InstanceOutput
+	var err error
+
+	if len(c.states) > 0 {
+		ret, err = instances.GetInstancesByState(svc, acct, c.states...)
+	} else {
+		ret, err = instances.GetInstances(svc, acct)
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.clients == nil {
+		c.clients = make(map[string]*ec2.EC2)
+	}
+	c.clients[acct] = svc
+	c.results = append(c.results, ret...)
+	return nil
+}
+
+// sortByName sorts the given instances by name, so that output is stable
+// before any sort-keys given via '-sort' are applied.
+func sortByName(objs []instances.InstanceOutput) {
+	sort.SliceStable(objs, func(i, j int) bool {
+		return objs[i].InstanceName < objs[j].InstanceName
+	})
+}
+
+// confirm shows the given warning, and ensures the user wishes to continue
+// by reading "OK" from STDIN.
+func confirm(warning string) error {
+
+	// Warning
+	fmt.Printf("%s", colorRed)
+	fmt.Printf("%s", warning)
+	fmt.Printf("%s", colorReset)
+
+	// Read a line of input
+	reader := bufio.NewReader(os.Stdin)
+	input, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read from STDIN %s", err)
+	}
+
+	// Strip CR/newline from string, then leading/trailing spaces
+	input = strings.TrimSuffix(input, "\n")
+	input = strings.TrimSuffix(input, "\r")
+	input = strings.TrimSpace(input)
+
+	// no input
+	if input == "" {
+		return fmt.Errorf("aborting as you hit enter")
+	}
+
+	// not "OK"
+	if input != "OK" {
+		return fmt.Errorf("aborting as you entered '%s', not 'OK'", input)
+	}
+
+	// OK the user confirmed
+	return nil
+}
+
+// instanceSelection holds the options which allow instances to be
+// filtered, via an expression, and sorted.
+//
+// It is embedded in the commands which output instances.
+type instanceSelection struct {
+
+	// where holds the filter-expression, if any
+	where string
+
+	// sortBy holds the sort specification, if any
+	sortBy string
+
+	// expr is the parsed filter-expression
+	expr *instances.Where
+
+	// keys are the parsed sort-keys
+	keys []instances.SortKey
+}
+
+// selectionArguments adds the filtering/sorting arguments.
+func (s *instanceSelection) selectionArguments(f *flag.FlagSet) {
+	f.StringVar(&s.where, "where", "", "Only show instances matching this expression, e.g. 'amiage > 90 && type =~ ^m5'")
+	f.StringVar(&s.sortBy, "sort", "", "Sort instances by the given field(s), e.g. 'amiage:desc,name'")
+}
+
+// parseSelection validates the filter-expression and sort-keys, this
+// should be called before any AWS operations take place.
+func (s *instanceSelection) parseSelection() error {
+
+	var err error
+
+	if s.where != "" {
+		s.expr, err = instances.ParseWhere(s.where)
+		if err != nil {
+			return fmt.Errorf("invalid expression: %s", err)
+		}
+	}
+
+	if s.sortBy != "" {
+		s.keys, err = instances.ParseSort(s.sortBy)
+		if err != nil {
+			return fmt.Errorf("invalid sort: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// applySelection filters and sorts the given instances.
+func (s *instanceSelection) applySelection(objs []instances.InstanceOutput) ([]instances.InstanceOutput, error) {
+
+	var err error
+
+	if s.expr != nil {
+		objs, err = s.expr.Filter(objs)
+		if err != nil {
+			return nil, fmt.Errorf("error evaluating expression: %s", err)
+		}
+	}
+
+	instances.Sort(objs, s.keys)
+	return objs, nil
+}
+
+// selectionHelp is help-text describing the filtering/sorting options.
+var selectionHelp = `
+Instances may be selected via an expression, using '-where'.  The
+expression may compare any of the fields supported by 'csv-instances'
+using the operators "==", "!=", "<", "<=", ">", ">=", "=~" (regular
+expression match), "!~", and "in", combined via "&&", "||", "!", and
+parenthesis:
+
+    -where='amiage > 90 && type =~ "^m5"'
+    -where='state == running && az in (eu-central-1a, eu-central-1b)'
+
+Fields which contain lists, such as "ipv6" and "security-groups", match
+if any of their members match, except for the negated operators "!=" and
+"!~" which match only if none of their members do.  The per-volume fields
+("vol-*") may not be used in expressions, or for sorting, even with
+'csv-instances -per-volume'.
+
+Instances may be sorted via '-sort', which accepts a list of fields, each
+optionally followed by ":desc" to reverse the order:
+
+    -sort=account,amiage:desc
+`
+
+// tagFilters allows tag filters to be specified multiple times upon the
+// command-line, each is either "Key=Value" or just "Key".
+type tagFilters []string
+
+// String is part of the flag.Value interface.
+func (t *tagFilters) String() string {
+	return strings.Join(*t, ",")
+}
+
+// Set is part of the flag.Value interface.
+func (t *tagFilters) Set(value string) error {
+	if value == "" || strings.HasPrefix(value, "=") {
+		return fmt.Errorf("tag filter should be of the form Key=Value or Key, got '%s'", value)
+	}
+	*t = append(*t, value)
+	return nil
+}
+
+// match returns true if the given tags satisfy every filter.
+func (t tagFilters) match(tags map[string]string) bool {
+
+	for _, filter := range t {
+		kv := strings.SplitN(filter, "=", 2)
+		val, ok := tags[kv[0]]
+		if !ok {
+			return false
+		}
+		if len(kv) == 2 && val != kv[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// instanceMatcher holds the options which control how instances are
+// matched by name, via a regular expression, a glob, or exactly, and
+// by their tags.
+//
+// It is embedded in the commands which operate upon named instances.
+type instanceMatcher struct {
+
+	// exact requires names to match exactly
+	exact bool
+
+	// glob treats patterns as shell-style globs
+	glob bool
+
+	// tags are the tags matching instances must have
+	tags tagFilters
+}
+
+// matcherArguments adds the name-matching arguments.
+func (m *instanceMatcher) matcherArguments(f *flag.FlagSet) {
+	f.BoolVar(&m.exact, "exact", false, "Match instance names exactly, rather than as a regular expression")
+	f.BoolVar(&m.glob, "glob", false, "Match instance names as a shell-style glob, such as 'prod-*', rather than as a regular expression")
+	f.Var(&m.tags, "tag", "Only match instances with the given tag, as 'Key=Value' or 'Key', may be repeated")
+}
+
+// matchInstances returns the instances whose names match the given pattern,
+// and which have the tags we require.
+func (m *instanceMatcher) matchInstances(objs []instances.InstanceOutput, pattern string) ([]instances.InstanceOutput, error) {
+
+	if m.exact && m.glob {
+		return nil, fmt.Errorf("-exact and -glob are mutually exclusive")
+	}
+
+	var re *regexp.Regexp
+	if !m.exact && !m.glob {
+		var err error
+		re, err = regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling regexp %s", err)
+		}
+	}
+
+	ret := []instances.InstanceOutput{}
+	for _, obj := range objs {
+
+		var ok bool
+		var err error
+
+		switch {
+		case m.exact:
+			ok = obj.InstanceName == pattern
+		case m.glob:
+			ok, err = path.Match(pattern, obj.InstanceName)
+			if err != nil {
+				return nil, fmt.Errorf("invalid glob %s: %s", pattern, err)
+			}
+		default:
+			ok = re.MatchString(obj.InstanceName)
+		}
+
+		if ok && m.tags.match(obj.Tags) {
+			ret = append(ret, obj)
+		}
+	}
+	return ret, nil
+}
+
+// matchAny returns the instances matching any of the given patterns, or
+// every instance with the tags we require if there are no patterns.
+func (m *instanceMatcher) matchAny(objs []instances.InstanceOutput, patterns []string) ([]instances.InstanceOutput, error) {
+
+	// Without patterns there are no names to match, so only the tags
+	// are examined.
+	if len(patterns) == 0 {
+		if m.exact && m.glob {
+			return nil, fmt.Errorf("-exact and -glob are mutually exclusive")
+		}
+
+		ret := []instances.InstanceOutput{}
+		for _, obj := range objs {
+			if m.tags.match(obj.Tags) {
+				ret = append(ret, obj)
+			}
+		}
+		return ret, nil
+	}
+
+	seen := make(map[string]bool)
+	ret := []instances.InstanceOutput{}
+
+	for _, pattern := range patterns {
+		found, err := m.matchInstances(objs, pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range found {
+			if !seen[obj.InstanceID] {
+				seen[obj.InstanceID] = true
+				ret = append(ret, obj)
+			}
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/skx/aws-utils/instances"
+)
+
+// TestTagFilters tests that tag filters are parsed and matched.
+func TestTagFilters(t *testing.T) {
+
+	type TestCase struct {
+		Filters []string
+		Tags    map[string]string
+		Result  bool
+	}
+
+	tests := []TestCase{
+		{[]string{}, map[string]string{}, true},
+		{[]string{"Env"}, map[string]string{"Env": "prod"}, true},
+		{[]string{"Env"}, map[string]string{"Env": ""}, true},
+		{[]string{"Env"}, map[string]string{"Name": "web"}, false},
+		{[]string{"Env=prod"}, map[string]string{"Env": "prod"}, true},
+		{[]string{"Env=prod"}, map[string]string{"Env": "staging"}, false},
+		{[]string{"Env="}, map[string]string{"Env": ""}, true},
+		{[]string{"Env="}, map[string]string{}, false},
+		{[]string{"Env=prod", "Role"}, map[string]string{"Env": "prod"}, false},
+		{[]string{"Env=prod", "Role"}, map[string]string{"Env": "prod", "Role": "db"}, true},
+		{[]string{"Url=a=b"}, map[string]string{"Url": "a=b"}, true},
+	}
+
+	for _, test := range tests {
+
+		var f tagFilters
+		for _, ent := range test.Filters {
+			if err := f.Set(ent); err != nil {
+				t.Fatalf("unexpected error parsing %s: %s", ent, err)
+			}
+		}
+
+		if f.match(test.Tags) != test.Result {
+			t.Errorf("filters %v against %v: expected %t", test.Filters, test.Tags, test.Result)
+		}
+	}
+
+	// Invalid filters
+	for _, bogus := range []string{"", "=prod"} {
+		var f tagFilters
+		if err := f.Set(bogus); err == nil {
+			t.Errorf("expected error parsing '%s', got none", bogus)
+		}
+	}
+}
+
+// TestMatchAny tests the selection of instances by name and tag.
+func TestMatchAny(t *testing.T) {
+
+	objs := []instances.InstanceOutput{
+		{InstanceID: "i-1", InstanceName: "prod-web-1", Tags: map[string]string{"Environment": "prod"}},
+		{InstanceID: "i-2", InstanceName: "prod-web-2", Tags: map[string]string{"Environment": "prod"}},
+		{InstanceID: "i-3", InstanceName: "staging-web-1", Tags: map[string]string{"Environment": "staging"}},
+		{InstanceID: "i-4", InstanceName: "staging-db-1", Tags: map[string]string{}},
+	}
+
+	type TestCase struct {
+		Exact    bool
+		Glob     bool
+		Tags     []string
+		Patterns []string
+		Result   string
+		Error    bool
+	}
+
+	tests := []TestCase{
+		{Patterns: []string{}, Result: "i-1,i-2,i-3,i-4"},
+		{Patterns: []string{"web"}, Result: "i-1,i-2,i-3"},
+		{Patterns: []string{"^prod", "web-1"}, Result: "i-1,i-2,i-3"},
+		{Patterns: []string{"("}, Error: true},
+		{Exact: true, Patterns: []string{"prod-web"}, Result: ""},
+		{Exact: true, Patterns: []string{"prod-web-2"}, Result: "i-2"},
+		{Glob: true, Patterns: []string{"staging-*"}, Result: "i-3,i-4"},
+		{Glob: true, Patterns: []string{"[", "x"}, Error: true},
+		{Exact: true, Glob: true, Patterns: []string{"x"}, Error: true},
+		{Exact: true, Glob: true, Patterns: []string{}, Error: true},
+		{Tags: []string{"Environment=staging"}, Patterns: []string{}, Result: "i-3"},
+		{Exact: true, Tags: []string{"Environment=staging"}, Patterns: []string{}, Result: "i-3"},
+		{Glob: true, Tags: []string{"Environment"}, Patterns: []string{}, Result: "i-1,i-2,i-3"},
+		{Tags: []string{"Environment=prod"}, Patterns: []string{"1$"}, Result: "i-1"},
+	}
+
+	for _, test := range tests {
+
+		m := &instanceMatcher{exact: test.Exact, glob: test.Glob, tags: test.Tags}
+		found, err := m.matchAny(objs, test.Patterns)
+
+		if test.Error {
+			if err == nil {
+				t.Errorf("%+v: expected error, got none", test)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: unexpected error %s", test, err)
+			continue
+		}
+
+		ids := []string{}
+		for _, obj := range found {
+			ids = append(ids, obj.InstanceID)
+		}
+		if strings.Join(ids, ",") != test.Result {
+			t.Errorf("%+v: expected %s, got %s", test, test.Result, strings.Join(ids, ","))
+		}
+	}
+}
+
+// TestSortByName tests the default, stable, sort of instances.
+func TestSortByName(t *testing.T) {
+
+	objs := []instances.InstanceOutput{
+		{InstanceID: "i-1", InstanceName: "web"},
+		{InstanceID: "i-2", InstanceName: "db"},
+		{InstanceID: "i-3", InstanceName: "web"},
+		{InstanceID: "i-4", InstanceName: "app"},
+	}
+
+	sortByName(objs)
+
+	ids := []string{}
+	for _, obj := range objs {
+		ids = append(ids, obj.InstanceID)
+	}
+	if strings.Join(ids, ",") != "i-4,i-2,i-1,i-3" {
+		t.Errorf("unexpected order %s", strings.Join(ids, ","))
+	}
+}
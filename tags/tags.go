@@ -0,0 +1,111 @@
+// Package tags contains helpers for working with the tags of AWS
+// resources.
+//
+// The various AWS services each have their own tag type, these are all
+// converted to a simple map of keys to values, from which the name of
+// the resource may be found.
+package tags
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// NameChain is the list of tags which are examined, in order, to find
+// the name of a resource.
+var NameChain = []string{"Name"}
+
+// SetNameChain updates NameChain from a comma-separated list of tag keys,
+// an empty string leaves it unchanged.
+func SetNameChain(keys string) {
+
+	chain := []string{}
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			chain = append(chain, key)
+		}
+	}
+
+	if len(chain) > 0 {
+		NameChain = chain
+	}
+}
+
+// Name returns the name of a resource from the given tags, using the
+// first tag within NameChain which has a value.
+//
+// If there is no name found the fallback value will be returned instead.
+func Name(tags map[string]string, fallback string) string {
+
+	for _, key := range NameChain {
+		if val := tags[key]; val != "" {
+			return val
+		}
+	}
+	return fallback
+}
+
+// add stores the given key and value in the map, if the key is set.
+func add(ret map[string]string, key *string, value *string) {
+	if key != nil {
+		ret[*key] = aws.StringValue(value)
+	}
+}
+
+// FromEC2 converts EC2 tags into a map of keys to values.
+func FromEC2(tags []*ec2.Tag) map[string]string {
+
+	ret := make(map[string]string)
+	for _, tag := range tags {
+		add(ret, tag.Key, tag.Value)
+	}
+	return ret
+}
+
+// FromCloudFormation converts CloudFormation tags into a map of keys to
+// values.
+func FromCloudFormation(tags []*cloudformation.Tag) map[string]string {
+
+	ret := make(map[string]string)
+	for _, tag := range tags {
+		add(ret, tag.Key, tag.Value)
+	}
+	return ret
+}
+
+// FromRoute53 converts Route53 tags into a map of keys to values.
+func FromRoute53(tags []*route53.Tag) map[string]string {
+
+	ret := make(map[string]string)
+	for _, tag := range tags {
+		add(ret, tag.Key, tag.Value)
+	}
+	return ret
+}
+
+// FromIAM converts IAM tags into a map of keys to values.
+func FromIAM(tags []*iam.Tag) map[string]string {
+
+	ret := make(map[string]string)
+	for _, tag := range tags {
+		add(ret, tag.Key, tag.Value)
+	}
+	return ret
+}
+
+// FromRDS converts RDS tags into a map of keys to values.
+func FromRDS(tags []*rds.Tag) map[string]string {
+
+	ret := make(map[string]string)
+	for _, tag := range tags {
+		add(ret, tag.Key, tag.Value)
+	}
+	return ret
+}
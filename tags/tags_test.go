@@ -0,0 +1,70 @@
+package tags
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// TestName tests finding names via the name chain.
+func TestName(t *testing.T) {
+
+	defer func(chain []string) { NameChain = chain }(NameChain)
+
+	type TestCase struct {
+		Chain    string
+		Tags     map[string]string
+		Fallback string
+		Result   string
+	}
+
+	tests := []TestCase{
+		{"Name", map[string]string{"Name": "web"}, "i-1", "web"},
+		{"Name", map[string]string{}, "i-1", "i-1"},
+		{"Name", map[string]string{"Name": ""}, "subnet-1", "subnet-1"},
+		{"Name,stack", map[string]string{"stack": "prod"}, "i-1", "prod"},
+		{"Name,stack", map[string]string{"Name": "web", "stack": "prod"}, "i-1", "web"},
+		{" stack , Name ", map[string]string{"Name": "web", "stack": "prod"}, "i-1", "prod"},
+		{"stack", map[string]string{"Name": "web"}, "i-1", "i-1"},
+	}
+
+	for _, test := range tests {
+
+		NameChain = []string{"Name"}
+		SetNameChain(test.Chain)
+
+		if out := Name(test.Tags, test.Fallback); out != test.Result {
+			t.Errorf("chain %s with %v: expected %s, got %s", test.Chain, test.Tags, test.Result, out)
+		}
+	}
+}
+
+// TestSetNameChainEmpty tests that an empty chain is ignored.
+func TestSetNameChainEmpty(t *testing.T) {
+
+	defer func(chain []string) { NameChain = chain }(NameChain)
+
+	NameChain = []string{"Name"}
+	SetNameChain(" , ")
+	if !reflect.DeepEqual(NameChain, []string{"Name"}) {
+		t.Errorf("empty chain changed the chain to %v", NameChain)
+	}
+}
+
+// TestFromEC2 tests converting EC2 tags.
+func TestFromEC2(t *testing.T) {
+
+	in := []*ec2.Tag{
+		{Key: aws.String("Name"), Value: aws.String("web")},
+		{Key: aws.String("Empty"), Value: nil},
+		{Key: nil, Value: aws.String("ignored")},
+	}
+
+	out := FromEC2(in)
+	expected := map[string]string{"Name": "web", "Empty": ""}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %v, got %v", expected, out)
+	}
+}
@@ -0,0 +1,330 @@
+// Template functions, and built-in templates, used by the instances
+// sub-command.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/skx/aws-utils/instances"
+)
+
+// templateFuncs are the functions available to templates.
+var templateFuncs = template.FuncMap{
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"join":     templateJoin,
+	"pad":      templatePad,
+	"lpad":     templateLPad,
+	"bytes":    templateBytes,
+	"gib":      templateGiB,
+	"date":     templateDate,
+	"since":    templateSince,
+	"days":     templateDays,
+	"add":      templateArith(func(a, b float64) float64 { return a + b }),
+	"sub":      templateArith(func(a, b float64) float64 { return a - b }),
+	"mul":      templateArith(func(a, b float64) float64 { return a * b }),
+	"div":      templateDiv,
+	"default":  templateDefault,
+	"tag":      templateTag,
+	"json":     templateJSON,
+	"totalEBS": templateTotalEBS,
+}
+
+// templateNumber converts the given value to a number, if possible.
+func templateNumber(v interface{}) (float64, error) {
+
+	switch n := v.(type) {
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	case string:
+		if n == "" {
+			return 0, nil
+		}
+		return strconv.ParseFloat(n, 64)
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+// templateJoin joins the elements of the given list with a separator.
+func templateJoin(sep string, list interface{}) (string, error) {
+
+	val := reflect.ValueOf(list)
+	if val.Kind() != reflect.Slice && val.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: %v is not a list", list)
+	}
+
+	out := []string{}
+	for i := 0; i < val.Len(); i++ {
+		out = append(out, fmt.Sprint(val.Index(i).Interface()))
+	}
+	return strings.Join(out, sep), nil
+}
+
+// templatePad pads the given value, with spaces, to the given width.
+func templatePad(width int, v interface{}) string {
+	return fmt.Sprintf("%-*v", width, v)
+}
+
+// templateLPad pads the given value, with leading spaces, to the given width.
+func templateLPad(width int, v interface{}) string {
+	return fmt.Sprintf("%*v", width, v)
+}
+
+// templateBytes humanises a size in bytes.
+func templateBytes(v interface{}) (string, error) {
+
+	n, err := templateNumber(v)
+	if err != nil {
+		return "", err
+	}
+
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 || n == float64(int64(n)) {
+		return fmt.Sprintf("%d%s", int64(n), units[i]), nil
+	}
+	return fmt.Sprintf("%.1f%s", n, units[i]), nil
+}
+
+// templateGiB humanises a size in GiB, as used for volumes.
+func templateGiB(v interface{}) (string, error) {
+
+	n, err := templateNumber(v)
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return "0GiB", nil
+	}
+	return templateBytes(n * 1024 * 1024 * 1024)
+}
+
+// templateDate formats the given time, using a Go layout string.
+func templateDate(layout string, t time.Time) string {
+	return t.Format(layout)
+}
+
+// plural returns the given count and unit, pluralising the unit if needed.
+func plural(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// humanDuration describes the given duration in the largest sensible unit.
+func humanDuration(d time.Duration) string {
+
+	day := 24 * time.Hour
+
+	switch {
+	case d < time.Minute:
+		return "less than a minute"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < day:
+		return plural(int(d/time.Hour), "hour")
+	case d < 365*day:
+		return plural(int(d/day), "day")
+	}
+
+	years := int(d / (365 * day))
+	rest := int((d % (365 * day)) / day)
+	return plural(years, "year") + ", " + plural(rest, "day")
+}
+
+// templateSince describes the time since the given time.
+func templateSince(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return humanDuration(time.Since(t))
+}
+
+// templateDays describes the given number of days.
+func templateDays(v interface{}) (string, error) {
+
+	n, err := templateNumber(v)
+	if err != nil {
+		return "", err
+	}
+	// Negative values are used when the age isn't known, such as an
+	// AMI which has been deregistered.
+	if n < 0 {
+		return "unknown", nil
+	}
+	if n < 1 {
+		return "less than a day", nil
+	}
+	return humanDuration(time.Duration(n) * 24 * time.Hour), nil
+}
+
+// templateArith returns a template function performing the given
+// arithmetic operation.
+func templateArith(op func(a, b float64) float64) func(a, b interface{}) (float64, error) {
+
+	return func(a, b interface{}) (float64, error) {
+		x, err := templateNumber(a)
+		if err != nil {
+			return 0, err
+		}
+		y, err := templateNumber(b)
+		if err != nil {
+			return 0, err
+		}
+		return op(x, y), nil
+	}
+}
+
+// templateDiv divides two numbers.
+func templateDiv(a, b interface{}) (float64, error) {
+
+	y, err := templateNumber(b)
+	if err != nil {
+		return 0, err
+	}
+	if y == 0 {
+		return 0, fmt.Errorf("division by zero")
+	}
+	return templateArith(func(a, b float64) float64 { return a / b })(a, y)
+}
+
+// templateDefault returns the given value, unless it is empty in which
+// case the default is returned.
+func templateDefault(def interface{}, v interface{}) interface{} {
+
+	if v == nil {
+		return def
+	}
+	val := reflect.ValueOf(v)
+	switch val.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		if val.Len() == 0 {
+			return def
+		}
+	}
+	return v
+}
+
+// templateTag returns the value of the given tag of the given instance.
+func templateTag(key string, obj instances.InstanceOutput) string {
+	return obj.Tags[key]
+}
+
+// templateJSON encodes the given value as JSON.
+func templateJSON(v interface{}) (string, error) {
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// templateTotalEBS returns the total size, in GiB, of the volumes attached
+// to the given instances.
+func templateTotalEBS(objs []instances.InstanceOutput) int {
+
+	total := 0
+	for _, obj := range objs {
+		total += obj.EBSGiB()
+	}
+	return total
+}
+
+// parseTemplate compiles the given template, with our functions available.
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("output").Funcs(templateFuncs).Parse(text)
+}
+
+// builtinTemplateNames returns the names of the built-in templates.
+func builtinTemplateNames() []string {
+
+	names := []string{}
+	for name := range builtinTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// builtinTemplates are the named templates available via
+// "-template builtin:name".
+var builtinTemplates = map[string]string{
+
+	"default": `
+{{.InstanceName}} {{.InstanceID}}
+  AMI         : {{.InstanceAMI}}
+  AMI Age     : {{.AMIAge}} days
+  AWS Account : {{.AWSAccount}}
+  Type        : {{.InstanceType}} {{.Architecture}} {{.Lifecycle}}
+  Platform    : {{.Platform}}
+  Launched    : {{.LaunchTime}} ({{.Uptime}} days)
+{{- if .IAMInstanceProfile }}
+  IAM Profile : {{.IAMInstanceProfile}}
+{{- end}}
+{{- if .SecurityGroups }}
+  Groups      :{{range .SecurityGroups}} {{.ID}} ({{.Name}}){{end}}
+{{- end}}
+  Tenancy     : {{.Tenancy}}
+  EBS Optim.  : {{.EBSOptimized}}
+  Monitoring  : {{.Monitoring}}
+  IMDS Tokens : {{.MetadataHTTPTokens}}
+{{- if .SSHKeyName  }}
+  KeyName     : {{.SSHKeyName}}
+{{- end}}
+{{- if .PrivateIPv4 }}
+  Private IPv4: {{.PrivateIPv4}}
+{{- end}}
+{{- if .PublicIPv4  }}
+  Public  IPv4: {{.PublicIPv4}}
+{{- end}}
+{{- if .NetworkInterfaces}}
+  Interfaces:{{range .NetworkInterfaces}}
+     {{.ID}} {{.SubnetID}}{{range .Addresses}} {{.Private}}{{if .Public}}/{{.Public}}{{end}}{{if .AllocationID}} ({{.AllocationID}}){{end}}{{end}}{{range .IPv6Addresses}} {{.}}{{end}}{{end}}
+{{- end}}
+{{if .Volumes}}
+  Volumes:{{range .Volumes}}
+     {{.Device}} {{.ID}} Size:{{.Size}}GiB Type:{{.Type}} Encrypted:{{.Encrypted}} IOPS:{{.IOPS}}{{end}}
+{{end}}
+`,
+
+	"brief": `{{define "header"}}{{pad 30 "NAME"}} {{pad 20 "ID"}} {{pad 12 "TYPE"}} {{pad 15 "PRIVATE IPV4"}} {{pad 15 "PUBLIC IPV4"}} AMI AGE
+{{end -}}
+{{pad 30 .InstanceName}} {{pad 20 .InstanceID}} {{pad 12 .InstanceType}} {{pad 15 (default "-" .PrivateIPv4)}} {{pad 15 (default "-" .PublicIPv4)}} {{days .AMIAge}}
+`,
+
+	"volumes": `{{define "footer"}}
+{{len .}} instances, {{gib (totalEBS .)}} of EBS storage
+{{end -}}
+{{.InstanceName}} {{.InstanceID}} - {{gib .EBSGiB}}
+{{- range .Volumes}}
+  {{pad 12 .Device}} {{pad 22 .ID}} {{lpad 8 (gib .Size)}} {{pad 4 .Type}} {{if eq .Encrypted "true"}}encrypted{{else}}unencrypted{{end}}
+{{- end}}
+`,
+
+	"markdown": `{{define "header"}}| Name | ID | Account | Type | AZ | Private IPv4 | Public IPv4 | AMI Age |
+|------|----|---------|------|----|--------------|-------------|---------|
+{{end -}}
+{{define "footer"}}
+{{len .}} instances.
+{{end -}}
+| {{.InstanceName}} | {{.InstanceID}} | {{.AWSAccount}} | {{.InstanceType}} | {{.AvailabilityZone}} | {{default "-" .PrivateIPv4}} | {{default "-" .PublicIPv4}} | {{days .AMIAge}} |
+`,
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/skx/aws-utils/instances"
+)
+
+// TestTemplateDays tests humanising a number of days.
+func TestTemplateDays(t *testing.T) {
+
+	type TestCase struct {
+		Input  interface{}
+		Output string
+	}
+
+	tests := []TestCase{
+		{-1, "unknown"},
+		{-3, "unknown"},
+		{0, "less than a day"},
+		{1, "1 day"},
+		{2, "2 days"},
+		{364, "364 days"},
+		{365, "1 year, 0 days"},
+		{366, "1 year, 1 day"},
+		{800, "2 years, 70 days"},
+		{"12", "12 days"},
+	}
+
+	for _, test := range tests {
+		out, err := templateDays(test.Input)
+		if err != nil {
+			t.Errorf("unexpected error with %v: %s", test.Input, err)
+			continue
+		}
+		if out != test.Output {
+			t.Errorf("%v: expected %q, got %q", test.Input, test.Output, out)
+		}
+	}
+
+	if _, err := templateDays("bogus"); err == nil {
+		t.Errorf("expected error with a bogus value")
+	}
+}
+
+// TestHumanDuration tests humanising durations.
+func TestHumanDuration(t *testing.T) {
+
+	type TestCase struct {
+		Input  time.Duration
+		Output string
+	}
+
+	tests := []TestCase{
+		{10 * time.Second, "less than a minute"},
+		{time.Minute, "1 minute"},
+		{59 * time.Minute, "59 minutes"},
+		{3 * time.Hour, "3 hours"},
+		{49 * time.Hour, "2 days"},
+	}
+
+	for _, test := range tests {
+		if out := humanDuration(test.Input); out != test.Output {
+			t.Errorf("%s: expected %q, got %q", test.Input, test.Output, out)
+		}
+	}
+
+	if out := templateSince(time.Time{}); out != "" {
+		t.Errorf("expected empty string for zero time, got %q", out)
+	}
+}
+
+// TestBuiltinTemplates tests that the built-in templates render, and that
+// a missing AMI isn't described as new.
+func TestBuiltinTemplates(t *testing.T) {
+
+	objs := []instances.InstanceOutput{
+		{InstanceName: "web", InstanceID: "i-1", AMIAge: -1},
+	}
+
+	for _, name := range builtinTemplateNames() {
+
+		tmpl, err := parseTemplate(builtinTemplates[name])
+		if err != nil {
+			t.Errorf("failed to parse template %s: %s", name, err)
+			continue
+		}
+
+		var buf bytes.Buffer
+		for _, obj := range objs {
+			if err := tmpl.Execute(&buf, obj); err != nil {
+				t.Errorf("failed to execute template %s: %s", name, err)
+			}
+		}
+
+		if bytes.Contains(buf.Bytes(), []byte("less than a day")) {
+			t.Errorf("template %s describes a missing AMI as new:\n%s", name, buf.String())
+		}
+	}
+}
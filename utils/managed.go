@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UpdateManagedBlock replaces the section of the given file which is
+// delimited by "# BEGIN <name>" and "# END <name>" lines with the given
+// content, leaving the remainder of the file untouched.
+//
+// If the file doesn't contain such a section it is appended, and if the
+// file doesn't exist it is created.  Symlinks are followed, so the file
+// they point to is updated.  Running this repeatedly with the same
+// content will leave the file unchanged.
+func UpdateManagedBlock(path string, name string, content string) error {
+
+	begin := "# BEGIN " + name
+	end := "# END " + name
+
+	// If the file is a symlink, as is common with dotfile managers,
+	// update the file it points to rather than replacing the link.
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %s", path, err)
+		}
+		path = real
+	}
+
+	// Read the existing file, if present
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %s", path, err)
+	}
+
+	// The new block, including the markers.
+	block := []string{begin}
+	if content != "" {
+		block = append(block, strings.Split(strings.TrimSuffix(content, "\n"), "\n")...)
+	}
+	block = append(block, end)
+
+	// Copy lines, replacing the existing block if we find one
+	out := []string{}
+	replaced := false
+	inside := false
+
+	lines := []string{}
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+
+	for _, line := range lines {
+
+		switch {
+		case strings.TrimSpace(line) == begin:
+			inside = true
+		case inside && strings.TrimSpace(line) == end:
+			inside = false
+			if !replaced {
+				out = append(out, block...)
+				replaced = true
+			}
+		case !inside:
+			out = append(out, line)
+		}
+	}
+
+	if inside {
+		return fmt.Errorf("%s contains '%s' without a matching '%s'", path, begin, end)
+	}
+
+	// No existing block?  Then append ours.
+	if !replaced {
+		if len(out) > 0 && out[len(out)-1] != "" {
+			out = append(out, "")
+		}
+		out = append(out, block...)
+	}
+
+	// Preserve the permissions of an existing file.
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	// Write to a temporary file, then rename it into place.
+	//
+	// Some files, such as a bind-mounted /etc/hosts, cannot be
+	// replaced, so in that case we fall back to overwriting.
+	result := []byte(strings.Join(out, "\n") + "\n")
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, result, mode)
+	if err == nil {
+		err = os.Rename(tmp, path)
+		if err == nil {
+			return nil
+		}
+		os.Remove(tmp)
+	}
+
+	err = os.WriteFile(path, result, mode)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestUpdateManagedBlock tests replacing the managed block of a file.
+func TestUpdateManagedBlock(t *testing.T) {
+
+	type TestCase struct {
+		Name    string
+		Before  string
+		Content string
+		After   string
+	}
+
+	tests := []TestCase{
+		{"empty file",
+			"",
+			"10.0.0.1 web\n",
+			"# BEGIN test\n10.0.0.1 web\n# END test\n"},
+		{"append",
+			"127.0.0.1 localhost\n",
+			"10.0.0.1 web\n",
+			"127.0.0.1 localhost\n\n# BEGIN test\n10.0.0.1 web\n# END test\n"},
+		{"append without trailing newline",
+			"127.0.0.1 localhost",
+			"10.0.0.1 web",
+			"127.0.0.1 localhost\n\n# BEGIN test\n10.0.0.1 web\n# END test\n"},
+		{"replace",
+			"127.0.0.1 localhost\n# BEGIN test\n10.0.0.1 old\n10.0.0.2 gone\n# END test\n::1 localhost\n",
+			"10.0.0.1 web\n10.0.0.3 db\n",
+			"127.0.0.1 localhost\n# BEGIN test\n10.0.0.1 web\n10.0.0.3 db\n# END test\n::1 localhost\n"},
+		{"replace with nothing",
+			"a\n# BEGIN test\nold\n# END test\nb\n",
+			"",
+			"a\n# BEGIN test\n# END test\nb\n"},
+		{"indented markers",
+			"a\n  # BEGIN test\nold\n  # END test  \n",
+			"new\n",
+			"a\n# BEGIN test\nnew\n# END test\n"},
+		{"duplicate blocks are merged",
+			"# BEGIN test\none\n# END test\nmiddle\n# BEGIN test\ntwo\n# END test\n",
+			"new\n",
+			"# BEGIN test\nnew\n# END test\nmiddle\n"},
+		{"other blocks are untouched",
+			"# BEGIN other\nkeep\n# END other\n",
+			"new\n",
+			"# BEGIN other\nkeep\n# END other\n\n# BEGIN test\nnew\n# END test\n"},
+	}
+
+	for _, test := range tests {
+
+		path := filepath.Join(t.TempDir(), "hosts")
+		if test.Before != "" {
+			if err := os.WriteFile(path, []byte(test.Before), 0600); err != nil {
+				t.Fatalf("failed to write: %s", err)
+			}
+		}
+
+		// Run twice, to ensure updates are idempotent.
+		for i := 0; i < 2; i++ {
+			if err := UpdateManagedBlock(path, "test", test.Content); err != nil {
+				t.Errorf("%s: unexpected error: %s", test.Name, err)
+				continue
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read: %s", err)
+			}
+			if string(data) != test.After {
+				t.Errorf("%s (run %d): expected\n%q\ngot\n%q", test.Name, i+1, test.After, string(data))
+			}
+		}
+
+		// Permissions of existing files are preserved.
+		if test.Before != "" {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("failed to stat: %s", err)
+			}
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("%s: permissions changed to %s", test.Name, info.Mode().Perm())
+			}
+		}
+	}
+}
+
+// TestUpdateManagedBlockUnterminated tests that a block without an end
+// marker is an error, and the file is left alone.
+func TestUpdateManagedBlockUnterminated(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "hosts")
+	before := "a\n# BEGIN test\nold\n"
+	if err := os.WriteFile(path, []byte(before), 0644); err != nil {
+		t.Fatalf("failed to write: %s", err)
+	}
+
+	if err := UpdateManagedBlock(path, "test", "new\n"); err == nil {
+		t.Errorf("expected error with an unterminated block")
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != before {
+		t.Errorf("file was modified: %q", string(data))
+	}
+}
+
+// TestUpdateManagedBlockSymlink tests that a symlink is left in place,
+// and the file it points to is updated.
+func TestUpdateManagedBlockSymlink(t *testing.T) {
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dotfiles", "config")
+	link := filepath.Join(dir, "config")
+
+	if err := os.Mkdir(filepath.Dir(target), 0755); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	if err := os.WriteFile(target, []byte("Host *\n"), 0600); err != nil {
+		t.Fatalf("failed to write: %s", err)
+	}
+	if err := os.Symlink(filepath.Join("dotfiles", "config"), link); err != nil {
+		t.Skipf("symlinks unsupported: %s", err)
+	}
+
+	if err := UpdateManagedBlock(link, "test", "new\n"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	info, err := os.Lstat(link)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("symlink was replaced")
+	}
+
+	data, _ := os.ReadFile(target)
+	if string(data) != "Host *\n\n# BEGIN test\nnew\n# END test\n" {
+		t.Errorf("target wasn't updated: %q", string(data))
+	}
+
+	info, err = os.Stat(target)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("permissions of target weren't preserved")
+	}
+
+	// A dangling symlink is an error.
+	dangling := filepath.Join(dir, "dangling")
+	if err := os.Symlink("missing", dangling); err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+	if err := UpdateManagedBlock(dangling, "test", "new\n"); err == nil {
+		t.Errorf("expected error with a dangling symlink")
+	}
+}